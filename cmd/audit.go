@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	"github.com/VanLavr/auth/internal/pkg/config"
+)
+
+// Run "audit-verify" subcommand: check the hash chain of the configured audit sink and print its head.
+// Exit code is 1 if the chain is broken.
+func runAuditVerify(ctx context.Context, cfg *config.Config) {
+	sink, err := audit.Open(ctx, cfg)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		os.Exit(1)
+	}
+	defer sink.Close(ctx)
+
+	head, err := audit.Verify(ctx, sink)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		sink.Close(ctx)
+		os.Exit(1)
+	}
+	if head == nil {
+		fmt.Println("audit log is empty")
+		return
+	}
+	fmt.Printf("audit chain is valid: %d entries, head %s\n", head.Seq, head.Hash)
+}
@@ -2,15 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/VanLavr/auth/internal/auth/delivery"
 	"github.com/VanLavr/auth/internal/auth/repository"
 	usecase "github.com/VanLavr/auth/internal/auth/service"
+	"github.com/VanLavr/auth/internal/pkg/audit"
 	"github.com/VanLavr/auth/internal/pkg/config"
+	"github.com/VanLavr/auth/internal/pkg/janitor"
 	"github.com/VanLavr/auth/internal/pkg/logging"
+	"github.com/VanLavr/auth/internal/pkg/tracing"
+	"github.com/VanLavr/auth/internal/pkg/webhook"
 )
 
 // @title Demo OAuth2.0 repository
@@ -22,37 +31,162 @@ import (
 // @in header
 // @name Authorization
 func main() {
-	ctx, close := signal.NotifyContext(context.TODO(), os.Interrupt)
-	defer close()
+	ctx, stop := signal.NotifyContext(context.TODO(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	cfg := config.New()
-	logger := logging.New()
+	logger := logging.New(cfg)
 	logger.SetAsDefault()
 
-	repo := repository.New(cfg)
-	repo.Connect(ctx, cfg)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(ctx, cfg, os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "audit-verify" {
+		runAuditVerify(ctx, cfg)
+		return
+	}
+
+	// Export traces if it is configured.
+	flushTraces, err := tracing.Setup(ctx, cfg)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Fail if the store is not reachable before the startup deadline.
+	store := repository.New(cfg)
+	repo := repository.NewResilient(store, cfg)
+	if err := repo.Connect(ctx, cfg); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Purge dead tokens in background if the store keeps them.
+	var workers sync.WaitGroup
+	if purger, ok := repo.Purger(); ok && cfg.JanitorInterval > 0 {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			janitor.New(purger, cfg.JanitorInterval, cfg.JanitorBatch, cfg.RevokedRetention).Run(ctx)
+		}()
+	}
 
-	usecase := usecase.New(repo, cfg)
-	srv := delivery.New(usecase, cfg)
+	// Open the audit log (entries are dropped if it is disabled).
+	auditSink, err := audit.Open(ctx, cfg)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Deliver audited events to webhook subscriptions in background.
+	auditor := audit.New(auditSink)
+	queue, err := webhook.OpenQueue(cfg)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	var dispatcher *webhook.Dispatcher
+	if len(cfg.Webhooks) > 0 {
+		dispatcher = webhook.New(cfg, queue)
+		auditor.Subscribe(dispatcher.Notify)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			dispatcher.Run(ctx)
+		}()
+	}
+
+	// Fail if tokens can not be signed with the configured keys.
+	usecase, err := usecase.New(repo, cfg, auditor)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	srv, err := delivery.New(usecase, cfg)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	srv.AddReadinessCheck("store", repo.Ping)
+	if dispatcher != nil {
+		srv.SetWebhooks(dispatcher)
+	}
 	srv.BindRoutes()
 
 	go func() {
 		slog.Info("running")
-		if err := srv.Run(); err != nil {
+		if err := srv.Run(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+	}()
+
+	go func() {
+		if err := srv.RunAdmin(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			slog.Error(err.Error())
 			os.Exit(1)
 		}
 	}()
 
 	<-ctx.Done()
+	// Second signal kills the process.
+	stop()
+	os.Exit(shutdown(cfg, srv, repo, auditor, auditSink, queue, &workers, flushTraces))
+}
+
+// Fail readiness and wait for load balancers to notice it.
+// Stop accepting requests and wait for in-flight ones until the shutdown timeout.
+// Wait for background workers (they stop with the signal context).
+// Close the store, the audit log (queued entries are written first) and the webhook queue.
+// Flush traces.
+func shutdown(cfg *config.Config, srv *delivery.Server, repo usecase.Repository, auditor *audit.Logger, auditSink audit.Sink, queue webhook.Queue, workers *sync.WaitGroup, flushTraces func(context.Context) error) int {
+	code := 0
+	slog.Info("shutting down", "in_flight", srv.InFlight(), "delay", cfg.ShutdownDelay, "timeout", cfg.ShutdownTimeout)
 
-	if err := srv.ShutDown(context.TODO()); err != nil {
+	// Fail readiness and wait for load balancers to notice it.
+	srv.StartDraining()
+	time.Sleep(cfg.ShutdownDelay)
+
+	// Stop accepting requests and wait for in-flight ones until the shutdown timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.ShutDown(ctx); err != nil {
+		slog.Error("requests were interrupted: "+err.Error(), "in_flight", srv.InFlight())
+		code = 1
+	} else {
+		slog.Info("requests are finished", "in_flight", srv.InFlight())
+	}
+
+	// Wait for background workers (they stop with the signal context).
+	workers.Wait()
+
+	// Close the store, the audit log (queued entries are written first) and the webhook queue.
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelClose()
+	if err := repo.CloseConnetion(closeCtx); err != nil {
 		slog.Error(err.Error())
-		os.Exit(1)
+		code = 1
+	}
+	if err := auditor.Close(closeCtx); err != nil {
+		slog.Error("audit entries were not written: " + err.Error())
+		code = 1
+	}
+	if err := auditSink.Close(closeCtx); err != nil {
+		slog.Error(err.Error())
+		code = 1
+	}
+	if err := queue.Close(closeCtx); err != nil {
+		slog.Error(err.Error())
+		code = 1
 	}
 
-	if err := repo.CloseConnetion(context.TODO()); err != nil {
+	// Flush traces.
+	if err := flushTraces(closeCtx); err != nil {
 		slog.Error(err.Error())
-		os.Exit(1)
+		code = 1
 	}
+
+	slog.Info("stopped")
+	return code
 }
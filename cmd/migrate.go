@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/VanLavr/auth/internal/auth/repository"
+	"github.com/VanLavr/auth/internal/pkg/config"
+)
+
+// Run "migrate [-dry-run]" subcommand: apply pending schema migrations of the configured store and exit.
+func runMigrate(ctx context.Context, cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "print pending migrations without applying them")
+	flags.Parse(args)
+
+	if err := repository.Migrate(ctx, cfg, *dryRun, os.Stdout); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,119 @@
+package delivery
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+)
+
+// Entries returned by one query if limit is not provided and at most.
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// Authenticate client.
+// Check that it is an admin.
+// Parse the query.
+// Call usecase to query the audit log (the query is audited as well).
+// @Summary Query audit log
+// @Tags admin
+// @Description call this endpoint to get audit entries (token issuance, refresh, reuse detection, revocation and admin actions) in chain order. Only admin clients are allowed, credentials are provided via basic auth or client certificate with client_id parameter (RFC 8705).
+// @ID queryAudit
+// @Produce json
+// @Param guid query string false "GUID of the user"
+// @Param from query string false "start of the time range (RFC 3339, inclusive)"
+// @Param to query string false "end of the time range (RFC 3339, exclusive)"
+// @Param limit query int false "max number of entries (100 by default, 1000 at most)"
+// @Param client_id query string false "client id (client certificate authentication)"
+// @Success 200 {object} delivery.Response
+// @Failure 400 {object} delivery.Response
+// @Failure 401 {object} delivery.Response
+// @Failure 403 {object} delivery.Response
+// @Failure 500 {object} delivery.Response
+// @Router /audit [get]
+func (s *Server) queryAudit(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "query audit called")
+
+	// Authenticate client.
+	// Check that it is an admin.
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+
+	// Parse the query.
+	query, err := parseAuditQuery(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeError(r.Context(), w, http.StatusBadRequest, e.ErrBadRequest)
+		return
+	}
+
+	// Call usecase to query the audit log (the query is audited as well).
+	entries, err := s.u.QueryAudit(r.Context(), query, s.clientInfo(r))
+	if err != nil {
+		s.writeError(r.Context(), w, http.StatusInternalServerError, e.ErrInternal)
+		return
+	}
+
+	fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
+		Error:   "",
+		Content: entries,
+	}))
+}
+
+// Parse the form and authenticate client.
+// Check that it is an admin.
+// Errors are written to the response, false is returned then.
+func (s *Server) authenticateAdmin(w http.ResponseWriter, r *http.Request) bool {
+	// Parse the form and authenticate client.
+	if err := r.ParseForm(); err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeError(r.Context(), w, http.StatusBadRequest, e.ErrBadRequest)
+		return false
+	}
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeError(r.Context(), w, http.StatusUnauthorized, err)
+		return false
+	}
+
+	// Check that it is an admin.
+	if !client.Admin {
+		slog.ErrorContext(r.Context(), e.ErrForbidden.Error(), "client_id", client.ID)
+		s.writeError(r.Context(), w, http.StatusForbidden, e.ErrForbidden)
+		return false
+	}
+	return true
+}
+
+func parseAuditQuery(r *http.Request) (audit.Query, error) {
+	query := audit.Query{GUID: r.Form.Get("guid"), Limit: defaultAuditLimit}
+
+	var err error
+	if from := r.Form.Get("from"); from != "" {
+		if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return query, err
+		}
+	}
+	if to := r.Form.Get("to"); to != "" {
+		if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return query, err
+		}
+	}
+	if limit := r.Form.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			return query, err
+		}
+		if query.Limit <= 0 || query.Limit > maxAuditLimit {
+			return query, fmt.Errorf("limit %d is out of range", query.Limit)
+		}
+	}
+	return query, nil
+}
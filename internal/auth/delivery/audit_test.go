@@ -0,0 +1,62 @@
+package delivery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testcases:
+// 1) not authenticated client is 401
+// 2) registered client that is not an admin is 403
+// 3) malformed time range or limit out of range is 400
+// 4) query is passed to the usecase, limit is 100 by default
+// 5) usecase errors are 500
+func TestQueryAudit(t *testing.T) {
+	assert := assert.New(t)
+	u := &usecase{entries: []audit.Entry{{Seq: 1, Event: audit.EventTokenIssued}}}
+	srv := newTestServer(t, u)
+	get := func(query string) *http.Request { return httptest.NewRequest(http.MethodGet, "/audit"+query, nil) }
+
+	// 1
+	w := serve(srv, get(""), "", "")
+	assert.Equal(http.StatusUnauthorized, w.Code)
+	assert.Equal(e.ErrInvalidClient.Error(), decode(t, w)["error"])
+
+	// 2
+	w = serve(srv, get(""), userClient, clientSecret)
+	assert.Equal(http.StatusForbidden, w.Code)
+	assert.Equal(e.ErrForbidden.Error(), decode(t, w)["error"])
+
+	// 3
+	for _, query := range []string{"?from=yesterday", "?to=2024-01-01", "?limit=ten", "?limit=0", "?limit=1001"} {
+		w = serve(srv, get(query), adminClient, clientSecret)
+		assert.Equal(http.StatusBadRequest, w.Code, query)
+		assert.Equal(e.ErrBadRequest.Error(), decode(t, w)["error"], query)
+	}
+
+	// 4
+	w = serve(srv, get("?guid=user&from=2024-01-01T00:00:00Z&to=2024-01-02T00:00:00Z"), adminClient, clientSecret)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Len(decode(t, w)["content"], 1)
+	assert.Equal(audit.Query{
+		GUID:  "user",
+		From:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:    time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		Limit: defaultAuditLimit,
+	}, u.query)
+	w = serve(srv, get("?limit=1000"), adminClient, clientSecret)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(maxAuditLimit, u.query.Limit)
+
+	// 5
+	u.auditErr = e.ErrInternal
+	w = serve(srv, get(""), adminClient, clientSecret)
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal(e.ErrInternal.Error(), decode(t, w)["error"])
+}
@@ -4,3 +4,30 @@ type Response struct {
 	Error   string `json:"error"`
 	Content any    `json:"content"`
 }
+
+// OAuthError is the error response of the OAuth endpoints (RFC 6749 section 5.2).
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// TokenResponse is the token exchange response (RFC 8693 section 2.2.1).
+type TokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+// Introspection is the token introspection response (RFC 7662 section 2.2), inactive tokens are described only with active.
+type Introspection struct {
+	Active    bool           `json:"active"`
+	Sub       string         `json:"sub,omitempty"`
+	TokenType string         `json:"token_type,omitempty"`
+	Exp       int64          `json:"exp,omitempty"`
+	Iat       int64          `json:"iat,omitempty"`
+	Scope     string         `json:"scope,omitempty"`
+	ClientID  string         `json:"client_id,omitempty"`
+	Cnf       map[string]any `json:"cnf,omitempty"`
+}
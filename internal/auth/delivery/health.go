@@ -0,0 +1,87 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+)
+
+// Dependency checked by /readyz.
+type readinessCheck struct {
+	name  string
+	check func(context.Context) error
+}
+
+// AddReadinessCheck() registers a dependency that has to be available to serve traffic (e.g. the store).
+func (s *Server) AddReadinessCheck(name string, check func(context.Context) error) {
+	s.checks = append(s.checks, readinessCheck{name: name, check: check})
+}
+
+// StartDraining() makes /readyz fail, so load balancers stop sending traffic before the server is shut down.
+func (s *Server) StartDraining() {
+	if !s.draining.Swap(true) {
+		slog.Info("draining, readiness is failing")
+	}
+}
+
+// @Summary Liveness probe
+// @Tags health
+// @Description responds while the process is alive.
+// @ID healthz
+// @Produce json
+// @Success 200 {object} delivery.Response
+// @Router /healthz [get]
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
+		Error:   "",
+		Content: "ok",
+	}))
+}
+
+// Fail if shutdown has begun.
+// Run every check, content reports result of each of them.
+// @Summary Readiness probe
+// @Tags health
+// @Description responds with 200 if the store is reachable, signing keys are loaded and the service is not shutting down.
+// @ID readyz
+// @Produce json
+// @Success 200 {object} delivery.Response
+// @Failure 503 {object} delivery.Response
+// @Router /readyz [get]
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	// Fail if shutdown has begun.
+	if s.draining.Load() {
+		s.writeError(r.Context(), w, http.StatusServiceUnavailable, e.ErrShuttingDown)
+		return
+	}
+
+	// Run every check, content reports result of each of them.
+	ready := true
+	results := make(map[string]string, len(s.checks))
+	for _, c := range s.checks {
+		if err := c.check(r.Context()); err != nil {
+			slog.ErrorContext(r.Context(), "readiness check failed", "check", c.name, "error", err)
+			results[c.name] = err.Error()
+			ready = false
+			continue
+		}
+		results[c.name] = "ok"
+	}
+
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
+			Error:   "not ready",
+			Content: results,
+		}))
+		return
+	}
+
+	fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
+		Error:   "",
+		Content: results,
+	}))
+}
@@ -0,0 +1,48 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testcases:
+// 1) ready when every check passes, content reports each of them
+// 2) not ready when a check fails, the failed check is reported
+// 3) not ready while draining even if every check passes, liveness is not affected
+func TestReadyz(t *testing.T) {
+	assert := assert.New(t)
+	u := &usecase{}
+	srv := newTestServer(t, u)
+	var storeErr error
+	srv.AddReadinessCheck("store", func(context.Context) error { return storeErr })
+	get := func(path string) *httptest.ResponseRecorder {
+		return serve(srv, httptest.NewRequest(http.MethodGet, path, nil), "", "")
+	}
+
+	// 1
+	w := get("/readyz")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(map[string]any{"keys": "ok", "store": "ok"}, decode(t, w)["content"])
+
+	// 2
+	storeErr = errors.New("store is unreachable")
+	w = get("/readyz")
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+	body := decode(t, w)
+	assert.Equal("not ready", body["error"])
+	assert.Equal(map[string]any{"keys": "ok", "store": "store is unreachable"}, body["content"])
+
+	// 3
+	storeErr = nil
+	srv.StartDraining()
+	w = get("/readyz")
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+	assert.Equal(e.ErrShuttingDown.Error(), decode(t, w)["error"])
+	assert.Equal(http.StatusOK, get("/healthz").Code)
+}
@@ -0,0 +1,91 @@
+package delivery
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+)
+
+// Parse form.
+// Authenticate client.
+// Validate token the same way the jwt middleware does (refresh tokens are accepted base64 encoded as they are issued).
+// Call usecase to introspect token.
+// Respond with inactive token without any reason if it is not valid.
+// The response is the introspection object itself (RFC 7662 section 2.2).
+// @Summary Introspect token
+// @Tags auth
+// @Description call this endpoint to check if an access or refresh token is active (RFC 7662). Client credentials are provided via basic auth or client certificate with client_id parameter (RFC 8705). Inactive tokens are described only with "active": false.
+// @ID introspectToken
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "access token or base64 encoded refresh token"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Param client_id formData string false "client id (client certificate authentication)"
+// @Success 200 {object} delivery.Introspection
+// @Failure 400 {object} delivery.OAuthError
+// @Failure 401 {object} delivery.OAuthError
+// @Failure 500 {object} delivery.OAuthError
+// @Router /introspect [post]
+func (s *Server) introspectToken(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "introspect token called")
+
+	// Parse form.
+	if err := r.ParseForm(); err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeOAuthError(r.Context(), w, http.StatusBadRequest, e.ErrBadRequest)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		slog.ErrorContext(r.Context(), e.ErrTokenWasNotProvided.Error())
+		s.writeOAuthError(r.Context(), w, http.StatusBadRequest, e.ErrTokenWasNotProvided)
+		return
+	}
+
+	// Authenticate client.
+	if _, err := s.authenticateClient(r); err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeOAuthError(r.Context(), w, http.StatusUnauthorized, err)
+		return
+	}
+
+	// Validate token the same way the jwt middleware does (refresh tokens are accepted base64 encoded as they are issued).
+	claims, err := s.jwt.ParseToken(r.Context(), token)
+	if err != nil {
+		if decoded, decodeErr := base64.StdEncoding.DecodeString(token); decodeErr == nil {
+			token = string(decoded)
+			claims, err = s.jwt.ParseToken(r.Context(), token)
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeInactive(r.Context(), w)
+		return
+	}
+
+	// Call usecase to introspect token.
+	data, err := s.u.IntrospectToken(r.Context(), token, claims)
+
+	// Respond with inactive token without any reason if it is not valid.
+	if errors.Is(err, e.ErrInvalidToken) {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeInactive(r.Context(), w)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeOAuthError(r.Context(), w, http.StatusInternalServerError, e.ErrInternal)
+		return
+	}
+
+	s.writeOAuth(r.Context(), w, http.StatusOK, data)
+}
+
+func (s *Server) writeInactive(ctx context.Context, w http.ResponseWriter) {
+	s.writeOAuth(ctx, w, http.StatusOK, Introspection{Active: false})
+}
@@ -0,0 +1,63 @@
+package delivery
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/VanLavr/auth/internal/models"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testcases:
+// 1) missing token is 400 invalid_request
+// 2) not authenticated client is 401 invalid_client
+// 3) token that can not be verified is inactive without any reason
+// 4) refresh token is accepted base64 encoded as it is issued
+// 5) token the usecase rejects is inactive without any reason
+// 6) other usecase errors are 500 server_error
+func TestIntrospectToken(t *testing.T) {
+	assert := assert.New(t)
+	u := &usecase{introspected: map[string]any{"active": true, "sub": "user"}}
+	srv := newTestServer(t, u)
+	access := newTestToken(t, models.TypAccessToken)
+	introspect := func(token string) url.Values { return url.Values{"token": {token}} }
+
+	// 1
+	w := postForm(srv, "/introspect", url.Values{}, userClient, clientSecret)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Equal("invalid_request", decode(t, w)["error"])
+
+	// 2
+	w = postForm(srv, "/introspect", introspect(access), userClient, "wrong")
+	assert.Equal(http.StatusUnauthorized, w.Code)
+	assert.Equal("invalid_client", decode(t, w)["error"])
+
+	// 3
+	w = postForm(srv, "/introspect", introspect("not a token"), userClient, clientSecret)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(map[string]any{"active": false}, decode(t, w))
+	assert.Empty(u.inspected)
+
+	// 4
+	refresh := newTestToken(t, models.TypRefreshToken)
+	w = postForm(srv, "/introspect", introspect(base64.StdEncoding.EncodeToString([]byte(refresh))), userClient, clientSecret)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(map[string]any{"active": true, "sub": "user"}, decode(t, w))
+	assert.Equal(refresh, u.inspected)
+	assert.Equal("no-store", w.Header().Get("Cache-Control"))
+
+	// 5
+	u.introspectErr = e.ErrInvalidToken
+	w = postForm(srv, "/introspect", introspect(access), userClient, clientSecret)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(map[string]any{"active": false}, decode(t, w))
+
+	// 6
+	u.introspectErr = e.ErrInternal
+	w = postForm(srv, "/introspect", introspect(access), userClient, clientSecret)
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal(map[string]any{"error": "server_error"}, decode(t, w))
+}
@@ -2,6 +2,7 @@ package delivery
 
 import (
 	_ "github.com/VanLavr/auth/docs"
+	"github.com/VanLavr/auth/internal/pkg/metrics"
 	httpSwagger "github.com/swaggo/http-swagger" // http-swagger middleware
 )
 
@@ -9,6 +10,20 @@ func (s *Server) BindRoutes() {
 	s.httpMux.HandleFunc("GET /getToken/{id}", s.getTokenPair)
 	s.httpMux.Handle("GET /restricted", s.jwt.ValidateAccessToken(s.restricted))
 	s.httpMux.HandleFunc("POST /refreshToken", s.refreshToken)
+	s.httpMux.HandleFunc("POST /token", s.exchangeToken)
+	s.httpMux.HandleFunc("POST /introspect", s.introspectToken)
+	s.httpMux.HandleFunc("GET /sessions/{id}", s.getSession)
+	s.httpMux.HandleFunc("GET /audit", s.queryAudit)
+	if s.webhooks != nil {
+		s.httpMux.HandleFunc("GET /webhooks/deliveries", s.webhookDeliveries)
+	}
+	s.httpMux.HandleFunc("GET /healthz", s.healthz)
+	s.httpMux.HandleFunc("GET /readyz", s.readyz)
+	if s.adminMux != nil {
+		s.adminMux.Handle("GET /metrics", metrics.Handler())
+	} else {
+		s.httpMux.Handle("GET /metrics", metrics.Handler())
+	}
 	s.httpMux.HandleFunc("GET /swagger/*", httpSwagger.Handler(
 		httpSwagger.URL("http://localhost:8080/swagger/doc.json"),
 	))
@@ -8,33 +8,81 @@ package delivery
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	"github.com/VanLavr/auth/internal/pkg/certs"
 	"github.com/VanLavr/auth/internal/pkg/config"
 	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/logging"
+	"github.com/VanLavr/auth/internal/pkg/metrics"
 	jwt "github.com/VanLavr/auth/internal/pkg/middlewares/validator"
+	"github.com/VanLavr/auth/internal/pkg/realip"
+	"github.com/VanLavr/auth/internal/pkg/tracing"
+	"github.com/VanLavr/auth/internal/pkg/webhook"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Server struct {
 	httpSrv *http.Server
 	httpMux *http.ServeMux
-	jwt     *jwt.JwtMiddleware
-	u       Usecase
+	// Admin listener serving metrics, nil if they are served by the main one.
+	adminSrv *http.Server
+	adminMux *http.ServeMux
+	jwt      *jwt.JwtMiddleware
+	u        Usecase
+	clients  map[string]config.Client
+	realIP   *realip.Resolver
+	tlsCert  string
+	tlsKey   string
+	tlsCA    string
+
+	// Webhook deliveries, nil if there are no subscriptions.
+	webhooks Webhooks
+
+	checks   []readinessCheck
+	draining atomic.Bool
+	inFlight atomic.Int64
 }
 
 // Busyness logic for refreshing tokens e.g.
 type Usecase interface {
-	RefreshTokenPair(context.Context, models.RefreshToken, string) (map[string]any, error)
-	GetNewTokenPair(context.Context, string) (map[string]any, error)
+	RefreshTokenPair(context.Context, models.RefreshToken, string, models.Confirmation, models.ClientInfo) (map[string]any, error)
+	GetNewTokenPair(context.Context, string, models.Confirmation, models.ClientInfo) (map[string]any, error)
+	GetSession(context.Context, string, models.ClientInfo) (*models.Session, error)
+	QueryAudit(context.Context, audit.Query, models.ClientInfo) ([]audit.Entry, error)
+	ExchangeToken(context.Context, models.TokenExchange) (map[string]any, error)
+	IntrospectToken(context.Context, string, map[string]any) (map[string]any, error)
+	CheckKeys(ctx context.Context) error
 }
 
-func New(u Usecase, cfg *config.Config) *Server {
+// Delivery log and dead letters of webhooks.
+type Webhooks interface {
+	Attempts(ctx context.Context, subscription string, limit int) ([]webhook.Attempt, error)
+	DeadLetters(ctx context.Context, subscription string, limit int) ([]webhook.Delivery, error)
+}
+
+// New() fails if token formats can not be created with the configured keys.
+func New(u Usecase, cfg *config.Config) (*Server, error) {
 	slog.Debug("new server called")
+	jwt, err := jwt.New(cfg)
+	if err != nil {
+		return nil, err
+	}
 	srv := &Server{
 		httpSrv: &http.Server{
 			Addr:           cfg.Addr,
@@ -44,27 +92,172 @@ func New(u Usecase, cfg *config.Config) *Server {
 		},
 		httpMux: http.NewServeMux(),
 		u:       u,
-		jwt:     jwt.New(cfg),
+		jwt:     jwt,
+		clients: cfg.Clients,
+		realIP:  realip.New(cfg.TrustedProxies),
+		tlsCert: cfg.TLSCert,
+		tlsKey:  cfg.TLSKey,
+		tlsCA:   cfg.TLSClientCA,
 	}
 
-	srv.httpSrv.Handler = srv.httpMux
-	return srv
+	srv.httpSrv.Handler = srv.track(srv.httpMux)
+	if cfg.MetricsAddr != "" {
+		srv.adminMux = http.NewServeMux()
+		srv.adminSrv = &http.Server{
+			Addr:              cfg.MetricsAddr,
+			Handler:           srv.adminMux,
+			ReadHeaderTimeout: cfg.ReadTimeout,
+		}
+	}
+	srv.AddReadinessCheck("keys", u.CheckKeys)
+	return srv, nil
 }
 
+// Serve plain http if there is no certificate.
+// Load certificate and client CA bundle (they are reloaded when files change).
+// Serve TLS, client certificates are verified if CA bundle is provided.
 func (s *Server) Run() error {
 	slog.Debug("run server called")
-	return s.httpSrv.ListenAndServe()
+	// Serve plain http if there is no certificate.
+	if s.tlsCert == "" {
+		return s.httpSrv.ListenAndServe()
+	}
+
+	// Load certificate and client CA bundle (they are reloaded when files change).
+	reloader, err := certs.New(s.tlsCert, s.tlsKey, s.tlsCA)
+	if err != nil {
+		return err
+	}
+
+	// Serve TLS, client certificates are verified if CA bundle is provided.
+	s.httpSrv.TLSConfig = reloader.TLSConfig()
+	return s.httpSrv.ListenAndServeTLS("", "")
+}
+
+// Count requests being served.
+// Continue the trace of the caller (W3C trace context headers) in a span of the route.
+// Take request id from the caller or generate it, it is returned in the response and attached to the logs.
+// Record duration of the request by route pattern and response status.
+// Log the request (probes are logged at debug level).
+func (s *Server) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Count requests being served.
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+
+		// Continue the trace of the caller (W3C trace context headers) in a span of the route.
+		_, route := s.httpMux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracing.Start(tracing.Extract(r), route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRoute(route), semconv.HTTPRequestMethodKey.String(r.Method), semconv.URLPath(r.URL.Path)),
+		)
+
+		// Take request id from the caller or generate it, it is returned in the response and attached to the logs.
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		ctx = logging.WithRequestID(ctx, requestID)
+
+		// Record duration of the request by route pattern and response status.
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		duration := time.Since(start)
+		metrics.HTTPDuration.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Observe(duration.Seconds())
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+		span.End()
+
+		// Log the request (probes are logged at debug level).
+		level := slog.LevelInfo
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			level = slog.LevelDebug
+		}
+		slog.LogAttrs(ctx, level, "request",
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Int("bytes", recorder.bytes),
+			slog.Duration("duration", duration),
+			slog.String("client_ip", s.realIP.ClientIP(r)),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	})
+}
+
+// Request id of the caller is accepted if it is short and safe to log.
+const requestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Response writer remembering the status code and the size of the body.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
 }
 
+// InFlight() returns how many requests are being served.
+func (s *Server) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+// RunAdmin() serves the admin listener if it is configured.
+func (s *Server) RunAdmin() error {
+	slog.Debug("run admin server called")
+	if s.adminSrv == nil {
+		return nil
+	}
+	return s.adminSrv.ListenAndServe()
+}
+
+// ShutDown() fails readiness (if draining has not started yet) and shuts the server
+// and the admin listener down.
 func (s *Server) ShutDown(ctx context.Context) error {
-	slog.Debug("shutdown server called")
-	return s.httpSrv.Shutdown(ctx)
+	slog.DebugContext(ctx, "shutdown server called")
+	s.StartDraining()
+	err := s.httpSrv.Shutdown(ctx)
+	if s.adminSrv != nil {
+		err = errors.Join(err, s.adminSrv.Shutdown(ctx))
+	}
+	return err
 }
 
 // Decode refresh token from body.
 // Decode token string from base64.
 // Extract access token from header.
-// Call usecase to refresh token pair.
+// Validate DPoP proof if it was provided.
+// Call usecase to refresh token pair (session is updated with the client of the request).
 // Encode new refresh token to base64.
 // @Summary Refresh token pair
 // @Tads auth
@@ -73,52 +266,64 @@ func (s *Server) ShutDown(ctx context.Context) error {
 // @Accept json
 // @Produce json
 // @Param refreshToken body models.RefreshToken true "refresh token object"
+// @Param DPoP header string false "DPoP proof (required if the refresh token is bound)"
+// @Param client_id query string false "id of the client authenticated with its certificate, recorded in the session"
 // @Success 200 {object} delivery.Response
+// @Failure 400 {object} delivery.Response
 // @Failure 401 {object} delivery.Response
 // @Failure 500 {object} delivery.Response
 // @Router /refreshToken [post]
 func (s *Server) refreshToken(w http.ResponseWriter, r *http.Request) {
-	slog.Info("refresh token called")
+	slog.InfoContext(r.Context(), "refresh token called")
 
 	// Decode refresh token from body.
 	var token models.RefreshToken
 	s.decodeBody(r, &token)
 
-	// Decode token string from base64.
-	tokStr := make([]byte, 1024)
-	if _, err := base64.StdEncoding.Decode(tokStr, []byte(token.TokenString)); err != nil {
-		slog.Error(err.Error())
+	// Decode token string from base64 (encrypted tokens may be long, so it is not decoded into a fixed buffer).
+	tokenString, err := base64.StdEncoding.DecodeString(token.TokenString)
+	if err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
 		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, s.encodeToJSON(Response{
+		fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
 			Error:   e.ErrBadRequest.Error(),
 			Content: nil,
 		}))
 		return
 	}
 
-	tokenString := s.stripZeros(tokStr)
-
 	token.TokenString = string(tokenString)
-	fmt.Println(token.TokenString)
 
 	// Extract access token from header.
 	access, err := s.jwt.ExtractTokenString(r)
 	if err != nil {
-		slog.Error(err.Error())
+		slog.ErrorContext(r.Context(), err.Error())
 		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, s.encodeToJSON(Response{
+		fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
 			Error:   err.Error(),
 			Content: nil,
 		}))
 		return
 	}
 
-	// Call usecase to refresh token pair.
-	data, err := s.u.RefreshTokenPair(r.Context(), token, access)
+	// Validate DPoP proof if it was provided.
+	cnf, err := s.jwt.Confirmation(w, r)
 	if err != nil {
-		slog.Error(err.Error())
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, s.encodeToJSON(Response{
+		slog.ErrorContext(r.Context(), err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
+			Error:   err.Error(),
+			Content: nil,
+		}))
+		return
+	}
+
+	// Call usecase to refresh token pair (session is updated with the client of the request).
+	data, err := s.u.RefreshTokenPair(r.Context(), token, access, cnf, s.clientInfo(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		w.WriteHeader(pairErrorStatus(err))
+		fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
 			Error:   err.Error(),
 			Content: nil,
 		}))
@@ -129,9 +334,9 @@ func (s *Server) refreshToken(w http.ResponseWriter, r *http.Request) {
 	newRefresh := data["refresh_token"]
 	newRefreshToken, ok := newRefresh.(models.RefreshToken)
 	if !ok {
-		slog.Error("conversion error")
+		slog.ErrorContext(r.Context(), "conversion error")
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, s.encodeToJSON(Response{
+		fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
 			Error:   e.ErrInternal.Error(),
 			Content: nil,
 		}))
@@ -141,14 +346,15 @@ func (s *Server) refreshToken(w http.ResponseWriter, r *http.Request) {
 	newRefreshToken.TokenString = base64.StdEncoding.EncodeToString([]byte(newRefreshToken.TokenString))
 	data["refresh_token"] = newRefreshToken
 
-	fmt.Fprint(w, s.encodeToJSON(Response{
+	fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
 		Error:   "",
 		Content: data,
 	}))
 }
 
+// Validate DPoP proof if it was provided.
 // Get guid from path value.
-// Call usecase to generate pair.
+// Call usecase to generate pair (session is started with the client of the request).
 // Encode new refresh token to base64.
 // @Summary Get token pair
 // @Tads auth
@@ -156,20 +362,35 @@ func (s *Server) refreshToken(w http.ResponseWriter, r *http.Request) {
 // @ID getTokenPair
 // @Produce json
 // @Param id path string true "GUID"
+// @Param DPoP header string false "DPoP proof to bind the tokens to the client key"
+// @Param client_id query string false "id of the client authenticated with its certificate, recorded in the session"
 // @Success 200 {object} delivery.Response
+// @Failure 400 {object} delivery.Response
 // @Failure 401 {object} delivery.Response
 // @Failure 500 {object} delivery.Response
 // @Router /getToken/{id} [get]
 func (s *Server) getTokenPair(w http.ResponseWriter, r *http.Request) {
-	slog.Info("get token pair is called")
+	slog.InfoContext(r.Context(), "get token pair is called")
+
+	// Validate DPoP proof if it was provided.
+	cnf, err := s.jwt.Confirmation(w, r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
+			Error:   err.Error(),
+			Content: nil,
+		}))
+		return
+	}
 
 	// Get guid from path value.
-	// Call usecase to generate pair.
-	tokens, err := s.u.GetNewTokenPair(r.Context(), r.PathValue("id"))
+	// Call usecase to generate pair (session is started with the client of the request).
+	tokens, err := s.u.GetNewTokenPair(r.Context(), r.PathValue("id"), cnf, s.clientInfo(r))
 	if err != nil {
-		slog.Error(err.Error())
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, s.encodeToJSON(Response{
+		slog.ErrorContext(r.Context(), err.Error())
+		w.WriteHeader(pairErrorStatus(err))
+		fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
 			Error:   err.Error(),
 			Content: nil,
 		}))
@@ -180,20 +401,19 @@ func (s *Server) getTokenPair(w http.ResponseWriter, r *http.Request) {
 	newRefresh := tokens["refresh_token"]
 	newRefreshToken, ok := newRefresh.(models.RefreshToken)
 	if !ok {
-		slog.Error("conversion error")
+		slog.ErrorContext(r.Context(), "conversion error")
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, s.encodeToJSON(Response{
+		fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
 			Error:   e.ErrInternal.Error(),
 			Content: nil,
 		}))
 		return
 	}
 
-	slog.Debug(fmt.Sprintf("HERE %v", tokens["refresh_token"].(models.RefreshToken).TokenString))
 	newRefreshToken.TokenString = base64.StdEncoding.EncodeToString([]byte(newRefreshToken.TokenString))
 	tokens["refresh_token"] = newRefreshToken
 
-	fmt.Fprint(w, s.encodeToJSON(Response{
+	fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
 		Error:   "",
 		Content: tokens,
 	}))
@@ -209,37 +429,42 @@ func (s *Server) getTokenPair(w http.ResponseWriter, r *http.Request) {
 // @Failure 401 {object} delivery.Response
 // @Router /restricted [get]
 func (s *Server) restricted(w http.ResponseWriter, r *http.Request) {
-	slog.Debug("restricted server called")
-	fmt.Fprint(w, s.encodeToJSON(Response{
+	slog.DebugContext(r.Context(), "restricted server called")
+	fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
 		Error:   "",
 		Content: "got",
 	}))
 }
 
-func (s *Server) encodeToJSON(resp Response) string {
-	slog.Debug("encodetojson server called")
+// Tokens that could not be issued are internal errors, other failures are unauthorized.
+func pairErrorStatus(err error) int {
+	if errors.Is(err, e.ErrInternal) {
+		return http.StatusInternalServerError
+	}
+	return http.StatusUnauthorized
+}
+
+func (s *Server) encodeToJSON(ctx context.Context, resp Response) string {
+	slog.DebugContext(ctx, "encodetojson server called")
 	encoded, err := json.Marshal(resp)
 	if err != nil {
-		slog.Error(err.Error())
+		slog.ErrorContext(ctx, err.Error())
 	}
 
 	return string(encoded)
 }
 
-func (s *Server) decodeBody(r *http.Request, dest *models.RefreshToken) {
-	slog.Debug("decodebody server called")
-	if err := json.NewDecoder(r.Body).Decode(dest); err != nil {
-		slog.Error(err.Error())
-	}
+func (s *Server) writeError(ctx context.Context, w http.ResponseWriter, status int, err error) {
+	w.WriteHeader(status)
+	fmt.Fprint(w, s.encodeToJSON(ctx, Response{
+		Error:   err.Error(),
+		Content: nil,
+	}))
 }
 
-func (s *Server) stripZeros(token []byte) []byte {
-	slog.Debug("stripzeros server called")
-	result := []byte{}
-	for _, b := range token {
-		if b != 0 {
-			result = append(result, b)
-		}
+func (s *Server) decodeBody(r *http.Request, dest *models.RefreshToken) {
+	slog.DebugContext(r.Context(), "decodebody server called")
+	if err := json.NewDecoder(r.Body).Decode(dest); err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
 	}
-	return result
 }
@@ -0,0 +1,244 @@
+package delivery
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/hasher"
+	"github.com/VanLavr/auth/internal/pkg/tokens"
+	"github.com/stretchr/testify/assert"
+)
+
+// Registered clients of test servers, their secret is the same.
+const (
+	adminClient  = "admin"
+	userClient   = "web"
+	clientSecret = "client-secret"
+)
+
+// usecase answers with the configured results and keeps the last calls.
+type usecase struct {
+	pair    map[string]any
+	pairErr error
+
+	session    *models.Session
+	sessionErr error
+	sessionFor models.ClientInfo
+
+	entries  []audit.Entry
+	auditErr error
+	query    audit.Query
+
+	exchanged   map[string]any
+	exchangeErr error
+
+	introspected  map[string]any
+	introspectErr error
+	inspected     string
+
+	keysErr error
+}
+
+func (u *usecase) RefreshTokenPair(context.Context, models.RefreshToken, string, models.Confirmation, models.ClientInfo) (map[string]any, error) {
+	return u.pair, u.pairErr
+}
+
+func (u *usecase) GetNewTokenPair(context.Context, string, models.Confirmation, models.ClientInfo) (map[string]any, error) {
+	return u.pair, u.pairErr
+}
+
+func (u *usecase) GetSession(ctx context.Context, guid string, client models.ClientInfo) (*models.Session, error) {
+	u.sessionFor = client
+	return u.session, u.sessionErr
+}
+
+func (u *usecase) QueryAudit(ctx context.Context, query audit.Query, client models.ClientInfo) ([]audit.Entry, error) {
+	u.query = query
+	return u.entries, u.auditErr
+}
+
+func (u *usecase) ExchangeToken(context.Context, models.TokenExchange) (map[string]any, error) {
+	return u.exchanged, u.exchangeErr
+}
+
+func (u *usecase) IntrospectToken(ctx context.Context, token string, claims map[string]any) (map[string]any, error) {
+	u.inspected = token
+	return u.introspected, u.introspectErr
+}
+
+func (u *usecase) CheckKeys(context.Context) error {
+	return u.keysErr
+}
+
+// Config of test servers with an admin and a regular client.
+func newTestConfig() *config.Config {
+	secretHash := hasher.Hshr.Encrypt(clientSecret)
+	return &config.Config{
+		Secret:         "asdf",
+		AccessExpTime:  time.Minute,
+		RefreshExpTime: time.Hour,
+		Clients: map[string]config.Client{
+			adminClient: {ID: adminClient, SecretHash: secretHash, Admin: true},
+			userClient: {ID: userClient, SecretHash: secretHash, Exchange: config.ExchangePolicy{
+				Impersonation: true,
+			}},
+		},
+	}
+}
+
+// Server of the test config, routes are bound.
+func newTestServer(t *testing.T, u *usecase) *Server {
+	srv, err := New(u, newTestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.BindRoutes()
+	return srv
+}
+
+// Token of the type signed in the format of test servers.
+func newTestToken(t *testing.T, typ string) string {
+	formats, err := tokens.New(newTestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := formats.Access()
+	if typ == models.TypRefreshToken {
+		format = formats.Refresh()
+	}
+	token, err := format.Sign(map[string]any{
+		"guid": "67a23ff3-20be-4420-9274-d16f2833d595",
+		"exp":  time.Now().Add(time.Minute).Unix(),
+		"typ":  typ,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// Form request authenticated as the client (not authenticated if it is empty).
+func postForm(srv *Server, path string, form url.Values, client, secret string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return serve(srv, r, client, secret)
+}
+
+// Serve the request authenticated as the client (not authenticated if it is empty).
+func serve(srv *Server, r *http.Request, client, secret string) *httptest.ResponseRecorder {
+	if client != "" {
+		r.SetBasicAuth(client, secret)
+	}
+	w := httptest.NewRecorder()
+	srv.httpMux.ServeHTTP(w, r)
+	return w
+}
+
+// Decode the response body, the test fails if it is not JSON.
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %q is not JSON: %v", w.Body.String(), err)
+	}
+	return body
+}
+
+// Testcases:
+// 1) not authenticated client gets 401
+// 2) client with a wrong secret gets 401
+// 3) registered client that is not an admin gets 403 and the usecase is not called
+// 4) admin gets the session, the admin is the audited client
+// 5) invalid GUID is 400
+// 6) missing session is 404
+// 7) other usecase errors are 500
+func TestGetSession(t *testing.T) {
+	assert := assert.New(t)
+	const guid = "67a23ff3-20be-4420-9274-d16f2833d595"
+	u := &usecase{session: &models.Session{ClientIP: "10.0.0.1", Rotations: 2}}
+	srv := newTestServer(t, u)
+	get := func() *http.Request { return httptest.NewRequest(http.MethodGet, "/sessions/"+guid, nil) }
+
+	// 1
+	w := serve(srv, get(), "", "")
+	assert.Equal(http.StatusUnauthorized, w.Code)
+
+	// 2
+	w = serve(srv, get(), adminClient, "wrong")
+	assert.Equal(http.StatusUnauthorized, w.Code)
+
+	// 3
+	w = serve(srv, get(), userClient, clientSecret)
+	assert.Equal(http.StatusForbidden, w.Code)
+	assert.Equal(e.ErrForbidden.Error(), decode(t, w)["error"])
+	assert.Empty(u.sessionFor.ClientID)
+
+	// 4
+	w = serve(srv, get(), adminClient, clientSecret)
+	assert.Equal(http.StatusOK, w.Code)
+	content, _ := decode(t, w)["content"].(map[string]any)
+	assert.Equal("10.0.0.1", content["client_ip"])
+	assert.Equal(adminClient, u.sessionFor.ClientID)
+
+	// 5
+	u.sessionErr = e.ErrInvalidGUID
+	w = serve(srv, get(), adminClient, clientSecret)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	// 6
+	u.sessionErr = e.ErrTokenNotFound
+	w = serve(srv, get(), adminClient, clientSecret)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	// 7
+	u.sessionErr = e.ErrInternal
+	w = serve(srv, get(), adminClient, clientSecret)
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal(e.ErrInternal.Error(), decode(t, w)["error"])
+}
+
+// Testcases:
+// 1) issued pair is returned with the refresh token encoded to base64
+// 2) invalid GUID is 401
+// 3) pair that could not be issued (e.g. it could not be signed) is 500 without tokens
+func TestGetTokenPair(t *testing.T) {
+	assert := assert.New(t)
+	const guid = "67a23ff3-20be-4420-9274-d16f2833d595"
+	u := &usecase{pair: map[string]any{
+		"access_token":  "access",
+		"refresh_token": models.RefreshToken{GUID: guid, TokenString: "refresh"},
+	}}
+	srv := newTestServer(t, u)
+	get := func() *http.Request { return httptest.NewRequest(http.MethodGet, "/getToken/"+guid, nil) }
+
+	// 1
+	w := serve(srv, get(), "", "")
+	assert.Equal(http.StatusOK, w.Code)
+	content, _ := decode(t, w)["content"].(map[string]any)
+	assert.Equal("access", content["access_token"])
+	refresh, _ := content["refresh_token"].(map[string]any)
+	assert.Equal(base64.StdEncoding.EncodeToString([]byte("refresh")), refresh["refresh_token"])
+
+	// 2
+	u.pair, u.pairErr = nil, e.ErrInvalidGUID
+	w = serve(srv, get(), "", "")
+	assert.Equal(http.StatusUnauthorized, w.Code)
+
+	// 3
+	u.pairErr = e.ErrInternal
+	w = serve(srv, get(), "", "")
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	body := decode(t, w)
+	assert.Equal(e.ErrInternal.Error(), body["error"])
+	assert.Nil(body["content"])
+}
@@ -0,0 +1,81 @@
+package delivery
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/VanLavr/auth/internal/models"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	jwt "github.com/VanLavr/auth/internal/pkg/middlewares/validator"
+)
+
+// Authenticate client.
+// Check that it is an admin.
+// Call usecase to get session of the user (the client is audited).
+// @Summary Get session
+// @Tags auth
+// @Description call this endpoint to see when and where the refresh token of the user was issued and last used. Only admin clients are allowed, credentials are provided via basic auth or client certificate with client_id parameter (RFC 8705).
+// @ID getSession
+// @Produce json
+// @Param id path string true "GUID"
+// @Param client_id query string false "client id (client certificate authentication)"
+// @Success 200 {object} delivery.Response
+// @Failure 400 {object} delivery.Response
+// @Failure 401 {object} delivery.Response
+// @Failure 403 {object} delivery.Response
+// @Failure 404 {object} delivery.Response
+// @Failure 500 {object} delivery.Response
+// @Router /sessions/{id} [get]
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "get session called")
+
+	// Authenticate client.
+	// Check that it is an admin.
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+
+	// Call usecase to get session of the user (the client is audited).
+	session, err := s.u.GetSession(r.Context(), r.PathValue("id"), s.clientInfo(r))
+	switch err {
+	case nil:
+	case e.ErrInvalidGUID:
+		s.writeError(r.Context(), w, http.StatusBadRequest, err)
+		return
+	case e.ErrTokenNotFound:
+		s.writeError(r.Context(), w, http.StatusNotFound, err)
+		return
+	default:
+		s.writeError(r.Context(), w, http.StatusInternalServerError, e.ErrInternal)
+		return
+	}
+
+	fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
+		Error:   "",
+		Content: session,
+	}))
+}
+
+// Client of the request: address (X-Forwarded-For is trusted only from trusted proxies), user agent
+// and id of the authenticated client (basic auth or client certificate of the client named by client_id).
+// The id is left empty if the client is not authenticated, client_id alone is not trusted.
+func (s *Server) clientInfo(r *http.Request) models.ClientInfo {
+	client := models.ClientInfo{
+		IP:        s.realIP.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if id, _, ok := r.BasicAuth(); ok {
+		if _, err := s.authenticateClient(r); err == nil {
+			client.ClientID = id
+		}
+		return client
+	}
+	id := r.URL.Query().Get("client_id")
+	if cert := jwt.ClientCertificate(r); cert != nil {
+		if registered, ok := s.clients[id]; ok && registered.MatchCertificate(cert) {
+			client.ClientID = id
+		}
+	}
+	return client
+}
@@ -0,0 +1,249 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/hasher"
+	jwt "github.com/VanLavr/auth/internal/pkg/middlewares/validator"
+)
+
+const grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// Parse form.
+// Check grant type.
+// Authenticate client.
+// Validate DPoP proof if it was provided.
+// Validate subject token and optional actor token.
+// Call usecase to exchange token (bound tokens must be presented with their key, the issued token is bound to the presented one).
+// @Summary Exchange token
+// @Tags auth
+// @Description call this endpoint to exchange a subject token (and optional actor token) for a down-scoped, audience-restricted access token (RFC 8693). Client credentials are provided via basic auth or client certificate with client_id parameter (RFC 8705).
+// @ID exchangeToken
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "urn:ietf:params:oauth:grant-type:token-exchange"
+// @Param subject_token formData string true "subject access token"
+// @Param subject_token_type formData string true "urn:ietf:params:oauth:token-type:access_token"
+// @Param actor_token formData string false "actor access token (delegation)"
+// @Param actor_token_type formData string false "urn:ietf:params:oauth:token-type:access_token"
+// @Param audience formData []string true "target audience" collectionFormat(multi)
+// @Param scope formData string false "space separated scopes"
+// @Param client_id formData string false "client id (client certificate authentication)"
+// @Param DPoP header string false "DPoP proof, required if a presented token is bound to a DPoP key (RFC 9449)"
+// @Success 200 {object} delivery.TokenResponse
+// @Failure 400 {object} delivery.OAuthError
+// @Failure 401 {object} delivery.OAuthError
+// @Failure 500 {object} delivery.OAuthError
+// @Router /token [post]
+func (s *Server) exchangeToken(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "exchange token called")
+
+	// Parse form.
+	if err := r.ParseForm(); err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeOAuthError(r.Context(), w, http.StatusBadRequest, e.ErrBadRequest)
+		return
+	}
+
+	// Check grant type.
+	if r.PostForm.Get("grant_type") != grantTypeTokenExchange {
+		slog.ErrorContext(r.Context(), e.ErrUnsupportedGrantType.Error())
+		s.writeOAuthError(r.Context(), w, http.StatusBadRequest, e.ErrUnsupportedGrantType)
+		return
+	}
+
+	// Authenticate client.
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeOAuthError(r.Context(), w, http.StatusUnauthorized, err)
+		return
+	}
+
+	// Validate DPoP proof if it was provided.
+	cnf, err := s.jwt.Confirmation(w, r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeOAuthError(r.Context(), w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Validate subject token and optional actor token.
+	subject, err := s.validateExchangedToken(r.Context(), r.PostForm.Get("subject_token"), r.PostForm.Get("subject_token_type"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeOAuthError(r.Context(), w, http.StatusBadRequest, e.ErrInvalidToken)
+		return
+	}
+
+	var actor map[string]any
+	if r.PostForm.Get("actor_token") != "" {
+		actor, err = s.validateExchangedToken(r.Context(), r.PostForm.Get("actor_token"), r.PostForm.Get("actor_token_type"))
+		if err != nil {
+			slog.ErrorContext(r.Context(), err.Error())
+			s.writeOAuthError(r.Context(), w, http.StatusBadRequest, e.ErrInvalidToken)
+			return
+		}
+	}
+
+	// Call usecase to exchange token (bound tokens must be presented with their key, the issued token is bound to the presented one).
+	data, err := s.u.ExchangeToken(r.Context(), models.TokenExchange{
+		ClientID:     client.ID,
+		Subject:      subject,
+		Actor:        actor,
+		Audience:     r.PostForm["audience"],
+		Scope:        strings.Fields(r.PostForm.Get("scope")),
+		Confirmation: cnf,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeOAuthError(r.Context(), w, exchangeErrorStatus(err), err)
+		return
+	}
+
+	s.writeOAuth(r.Context(), w, http.StatusOK, data)
+}
+
+// Authenticate with client certificate if there are no client credentials.
+// Extract client credentials from basic auth.
+// Find registered client.
+// Compare provided secret with stored hash.
+func (s *Server) authenticateClient(r *http.Request) (*config.Client, error) {
+	slog.DebugContext(r.Context(), "authenticateclient server called")
+	// Authenticate with client certificate if there are no client credentials.
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return s.authenticateClientCertificate(r)
+	}
+
+	// Find registered client.
+	client, ok := s.clients[id]
+	if !ok {
+		return nil, e.ErrInvalidClient
+	}
+
+	// Compare provided secret with stored hash.
+	if client.SecretHash == "" || !hasher.Hshr.Validate(client.SecretHash, secret) {
+		return nil, e.ErrInvalidClient
+	}
+
+	return &client, nil
+}
+
+// Take verified client certificate.
+// Find client by client_id parameter (RFC 8705).
+// Check that the certificate belongs to the client.
+func (s *Server) authenticateClientCertificate(r *http.Request) (*config.Client, error) {
+	slog.DebugContext(r.Context(), "authenticateclientcertificate server called")
+	// Take verified client certificate.
+	cert := jwt.ClientCertificate(r)
+	if cert == nil {
+		return nil, e.ErrInvalidClient
+	}
+
+	// Find client by client_id parameter (RFC 8705).
+	client, ok := s.clients[r.Form.Get("client_id")]
+	if !ok {
+		return nil, e.ErrInvalidClient
+	}
+
+	// Check that the certificate belongs to the client.
+	if !client.MatchCertificate(cert) {
+		return nil, e.ErrInvalidClient
+	}
+
+	return &client, nil
+}
+
+// Check token type.
+// Validate token the same way the jwt middleware does.
+func (s *Server) validateExchangedToken(ctx context.Context, token, tokenType string) (map[string]any, error) {
+	slog.DebugContext(ctx, "validateexchangedtoken server called")
+	// Check token type.
+	if tokenType != models.TokenTypeAccessToken && tokenType != models.TokenTypeJWT {
+		return nil, e.ErrBadRequest
+	}
+
+	// Validate token the same way the jwt middleware does.
+	claims, err := s.jwt.ParseToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if claims["typ"] == models.TypRefreshToken {
+		return nil, e.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+func exchangeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, e.ErrUnauthorizedClient),
+		errors.Is(err, e.ErrInvalidScope),
+		errors.Is(err, e.ErrInvalidTarget),
+		errors.Is(err, e.ErrAmbiguousEncryption),
+		errors.Is(err, e.ErrInvalidToken):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Map error to the OAuth error code (RFC 6749 section 5.2, RFC 8693 section 2.2.2).
+func oauthErrorCode(err error) string {
+	switch {
+	case errors.Is(err, e.ErrInvalidClient):
+		return "invalid_client"
+	case errors.Is(err, e.ErrUnauthorizedClient):
+		return "unauthorized_client"
+	case errors.Is(err, e.ErrUnsupportedGrantType):
+		return "unsupported_grant_type"
+	case errors.Is(err, e.ErrInvalidScope):
+		return "invalid_scope"
+	case errors.Is(err, e.ErrInvalidTarget), errors.Is(err, e.ErrAmbiguousEncryption):
+		return "invalid_target"
+	case errors.Is(err, e.ErrInvalidDPoPProof), errors.Is(err, e.ErrUnsupportedKey):
+		return "invalid_dpop_proof"
+	case errors.Is(err, e.ErrUseDPoPNonce):
+		return "use_dpop_nonce"
+	case errors.Is(err, e.ErrInternal):
+		return "server_error"
+	default:
+		return "invalid_request"
+	}
+}
+
+// Write the body as a top-level JSON object, token responses must not be cached.
+func (s *Server) writeOAuth(ctx context.Context, w http.ResponseWriter, status int, body any) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		status, encoded = http.StatusInternalServerError, []byte(`{"error":"server_error"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	w.Write(encoded)
+}
+
+// Write the OAuth error response, failed client authentication is challenged with basic auth.
+// Server errors are not described.
+func (s *Server) writeOAuthError(ctx context.Context, w http.ResponseWriter, status int, err error) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth"`)
+	}
+	resp := OAuthError{Error: oauthErrorCode(err), ErrorDescription: err.Error()}
+	if status >= http.StatusInternalServerError {
+		resp = OAuthError{Error: "server_error"}
+	}
+	s.writeOAuth(ctx, w, status, resp)
+}
@@ -0,0 +1,93 @@
+package delivery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/VanLavr/auth/internal/models"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testcases:
+// 1) unsupported grant type is 400 unsupported_grant_type
+// 2) not authenticated client is 401 invalid_client challenged with basic auth
+// 3) invalid subject token, refresh token or unknown token type is 400 invalid_request
+// 4) usecase errors are mapped to their OAuth error codes
+// 5) server errors are 500 server_error without description
+// 6) exchanged token is the top-level response that must not be cached
+func TestExchangeToken(t *testing.T) {
+	assert := assert.New(t)
+	u := &usecase{}
+	srv := newTestServer(t, u)
+	form := func(subject, tokenType string) url.Values {
+		return url.Values{
+			"grant_type":         {grantTypeTokenExchange},
+			"subject_token":      {subject},
+			"subject_token_type": {tokenType},
+			"audience":           {"orders"},
+		}
+	}
+	access := newTestToken(t, models.TypAccessToken)
+
+	// 1
+	body := form(access, models.TokenTypeAccessToken)
+	body.Set("grant_type", "password")
+	w := postForm(srv, "/token", body, userClient, clientSecret)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Equal("unsupported_grant_type", decode(t, w)["error"])
+
+	// 2
+	for _, w := range []*httptest.ResponseRecorder{
+		postForm(srv, "/token", form(access, models.TokenTypeAccessToken), "", ""),
+		postForm(srv, "/token", form(access, models.TokenTypeAccessToken), userClient, "wrong"),
+	} {
+		assert.Equal(http.StatusUnauthorized, w.Code)
+		assert.Equal("invalid_client", decode(t, w)["error"])
+		assert.Equal(`Basic realm="auth"`, w.Header().Get("WWW-Authenticate"))
+	}
+
+	// 3
+	for _, body := range []url.Values{
+		form("not a token", models.TokenTypeAccessToken),
+		form(newTestToken(t, models.TypRefreshToken), models.TokenTypeAccessToken),
+		form(access, "urn:ietf:params:oauth:token-type:refresh_token"),
+	} {
+		w = postForm(srv, "/token", body, userClient, clientSecret)
+		assert.Equal(http.StatusBadRequest, w.Code)
+		assert.Equal("invalid_request", decode(t, w)["error"])
+	}
+
+	// 4
+	for err, code := range map[error]string{
+		e.ErrUnauthorizedClient:  "unauthorized_client",
+		e.ErrInvalidScope:        "invalid_scope",
+		e.ErrInvalidTarget:       "invalid_target",
+		e.ErrAmbiguousEncryption: "invalid_target",
+		e.ErrInvalidToken:        "invalid_request",
+	} {
+		u.exchangeErr = err
+		w = postForm(srv, "/token", form(access, models.TokenTypeAccessToken), userClient, clientSecret)
+		assert.Equal(http.StatusBadRequest, w.Code, err.Error())
+		resp := decode(t, w)
+		assert.Equal(code, resp["error"], err.Error())
+		assert.Equal(err.Error(), resp["error_description"])
+	}
+
+	// 5
+	u.exchangeErr = e.ErrInternal
+	w = postForm(srv, "/token", form(access, models.TokenTypeAccessToken), userClient, clientSecret)
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal(map[string]any{"error": "server_error"}, decode(t, w))
+
+	// 6
+	u.exchangeErr = nil
+	u.exchanged = map[string]any{"access_token": "exchanged", "token_type": "Bearer"}
+	w = postForm(srv, "/token", form(access, models.TokenTypeJWT), userClient, clientSecret)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("exchanged", decode(t, w)["access_token"])
+	assert.Equal("no-store", w.Header().Get("Cache-Control"))
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+}
@@ -0,0 +1,97 @@
+package delivery
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/webhook"
+)
+
+// Attempts and dead letters returned by one query if limit is not provided and at most.
+const (
+	defaultWebhookLimit = 100
+	maxWebhookLimit     = 1000
+)
+
+// WebhookDeliveries is the content of the webhook deliveries response.
+type WebhookDeliveries struct {
+	Attempts    []webhook.Attempt  `json:"attempts"`
+	DeadLetters []webhook.Delivery `json:"dead_letters"`
+}
+
+// SetWebhooks() makes the server expose the delivery log of webhooks, it has to be called before BindRoutes().
+func (s *Server) SetWebhooks(webhooks Webhooks) {
+	s.webhooks = webhooks
+}
+
+// Authenticate client.
+// Check that it is an admin.
+// Parse the query.
+// Return latest delivery attempts and dead letters.
+// @Summary Webhook delivery log
+// @Tags admin
+// @Description call this endpoint to get latest webhook delivery attempts (newest first) and dead-lettered deliveries. Only admin clients are allowed, credentials are provided via basic auth or client certificate with client_id parameter (RFC 8705).
+// @ID webhookDeliveries
+// @Produce json
+// @Param subscription query string false "id of the subscription"
+// @Param limit query int false "max number of attempts and dead letters (100 by default, 1000 at most)"
+// @Param client_id query string false "client id (client certificate authentication)"
+// @Success 200 {object} delivery.Response
+// @Failure 400 {object} delivery.Response
+// @Failure 401 {object} delivery.Response
+// @Failure 403 {object} delivery.Response
+// @Failure 500 {object} delivery.Response
+// @Router /webhooks/deliveries [get]
+func (s *Server) webhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "webhook deliveries called")
+
+	// Authenticate client.
+	// Check that it is an admin.
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+
+	// Parse the query.
+	subscription := r.Form.Get("subscription")
+	limit := defaultWebhookLimit
+	if value := r.Form.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxWebhookLimit {
+			slog.ErrorContext(r.Context(), fmt.Sprintf("invalid limit %q", value))
+			s.writeError(r.Context(), w, http.StatusBadRequest, e.ErrBadRequest)
+			return
+		}
+	}
+
+	// Return latest delivery attempts and dead letters.
+	attempts, err := s.webhooks.Attempts(r.Context(), subscription, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeError(r.Context(), w, http.StatusInternalServerError, e.ErrInternal)
+		return
+	}
+	dead, err := s.webhooks.DeadLetters(r.Context(), subscription, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), err.Error())
+		s.writeError(r.Context(), w, http.StatusInternalServerError, e.ErrInternal)
+		return
+	}
+
+	if attempts == nil {
+		attempts = []webhook.Attempt{}
+	}
+	if dead == nil {
+		dead = []webhook.Delivery{}
+	}
+
+	fmt.Fprint(w, s.encodeToJSON(r.Context(), Response{
+		Error: "",
+		Content: WebhookDeliveries{
+			Attempts:    attempts,
+			DeadLetters: dead,
+		},
+	}))
+}
@@ -3,12 +3,15 @@ package repository
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
+	"time"
 
 	usecase "github.com/VanLavr/auth/internal/auth/service"
 	"github.com/VanLavr/auth/internal/models"
 	"github.com/VanLavr/auth/internal/pkg/config"
 	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/migrate"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -16,75 +19,154 @@ import (
 
 type authRepository struct {
 	conn       string
+	ttl        time.Duration
 	client     *mongo.Client
 	database   *mongo.Database
 	collection *mongo.Collection
 }
 
+// New() creates the repository selected in config (mongo by default).
 func New(cfg *config.Config) usecase.Repository {
 	slog.Debug("new repo called")
-	return &authRepository{conn: cfg.Mongo}
+	switch cfg.Store {
+	case config.StoreMemory:
+		return newMemoryRepository(cfg)
+	case config.StorePostgres:
+		return newPostgresRepository(cfg)
+	case config.StoreBolt:
+		return newBoltRepository(cfg)
+	case config.StoreRedis:
+		return newRedisRepository(cfg)
+	default:
+		return &authRepository{conn: cfg.Mongo, ttl: cfg.RefreshExpTime}
+	}
 }
 
-// Connet() connects to mongo and selects the database and the collection.
-func (a *authRepository) Connect(ctx context.Context, cfg *config.Config) error {
-	slog.Debug("connect repo called")
-	// Create client options.
-	clientOptions := options.Client().ApplyURI(a.conn)
+// Connet() connects to mongo, selects the database and the collection,
+// applies migrations (unless they are skipped) and ensures indexes.
+// The client is disconnected if any of it fails, so retried connects do not leak clients.
+func (a *authRepository) Connect(ctx context.Context, cfg *config.Config) (err error) {
+	slog.DebugContext(ctx, "connect repo called")
+	// Create client options (unreachable server fails operations after the operation timeout, commands are traced).
+	clientOptions := options.Client().ApplyURI(a.conn).SetMonitor(newCommandMonitor())
+	if cfg.OperationTimeout > 0 {
+		clientOptions.SetServerSelectionTimeout(cfg.OperationTimeout)
+	}
 	// Connect.
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		slog.Error(err.Error())
+		slog.ErrorContext(ctx, err.Error())
 		return err
 	}
 
 	if err = client.Ping(ctx, nil); err != nil {
-		slog.Error(err.Error())
+		client.Disconnect(context.WithoutCancel(ctx))
+		slog.ErrorContext(ctx, err.Error())
 		return err
 	}
-	slog.Info("pinged")
+	slog.InfoContext(ctx, "pinged")
 
-	// Select database and collection.
+	// Select database and collection, they are dropped with the client if the rest fails.
 	a.client = client
 	a.database = client.Database(cfg.DBName)
 	a.collection = a.database.Collection(cfg.CollectionName)
+	defer func() {
+		if err != nil {
+			client.Disconnect(context.WithoutCancel(ctx))
+			a.client, a.database, a.collection = nil, nil, nil
+		}
+	}()
+
+	// Apply migrations, they ensure indexes when the schema is up to date.
+	if !cfg.SkipMigrations {
+		if err := a.Migrate(ctx, false, io.Discard); err != nil {
+			slog.ErrorContext(ctx, err.Error())
+			return err
+		}
+		return nil
+	}
+
+	// Indexes of outdated schema may be impossible to build, they are ensured by migrate command then.
+	version, err := a.migrationStore().Version(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+	if latest := migrate.Latest(a.migrations()); version < latest {
+		slog.WarnContext(ctx, "schema is outdated, run migrate", "version", version, "latest", latest)
+		return nil
+	}
+
+	// Ensure indexes.
+	if err := a.ensureIndexes(ctx); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Create unique index on guid, so there is one token per user.
+// Create TTL index on expiration time, so mongo removes expired tokens by itself.
+// Create index on rotation time, so the janitor finds revoked hashes to purge.
+// Creating an existing index is a no-op, so it is done on every start.
+func (a *authRepository) ensureIndexes(ctx context.Context) error {
+	names, err := a.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "guid", Value: 1}},
+			Options: options.Index().SetName("guid_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresat", Value: 1}},
+			Options: options.Index().SetName("expiresat_ttl").SetExpireAfterSeconds(0),
+		},
+		{
+			Keys:    bson.D{{Key: "rotatedat", Value: 1}},
+			Options: options.Index().SetName("rotatedat"),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("ensure indexes of %s (remove duplicate guids if unique index can't be built): %w", a.collection.Name(), err)
+	}
 
+	slog.InfoContext(ctx, "ensured indexes", "collection", a.collection.Name(), "indexes", names)
 	return nil
 }
 
 func (a *authRepository) CloseConnetion(ctx context.Context) error {
-	slog.Debug("closeconnection repo called")
+	slog.DebugContext(ctx, "closeconnection repo called")
 	if err := a.client.Disconnect(ctx); err != nil {
-		slog.Error(err.Error())
+		slog.ErrorContext(ctx, err.Error())
 		return err
 	}
 	return nil
 }
 
-// Create a filter.
-// Find a token via tokenstring and guid.
+// Ping() checks that mongo is reachable.
+func (a *authRepository) Ping(ctx context.Context) error {
+	return a.client.Ping(ctx, nil)
+}
+
+// Create a filter (TTL monitor runs once a minute, so expired tokens are filtered out explicitly).
+// Find a token via guid.
 // Bind it to an object and check if it's fields empty or not.
 func (a *authRepository) GetToken(ctx context.Context, provided models.RefreshToken) (*models.RefreshToken, error) {
-	slog.Debug("gettoken repo called")
-	// Create a filter.
-	filter := bson.M{
+	slog.DebugContext(ctx, "gettoken repo called")
+	// Create a filter (TTL monitor runs once a minute, so expired tokens are filtered out explicitly).
+	filter := notExpired(bson.M{
 		"guid": provided.GUID,
-	}
-
-	// Find a token via tokenstring and guid.
-	cursor, err := a.collection.Find(ctx, filter)
-	if err != nil {
-		slog.Error(err.Error())
-		return nil, err
-	}
+	})
 
+	// Find a token via guid.
 	// Bind it to an object and check if it's fields empty or not.
 	var result models.RefreshToken
-	for cursor.Next(ctx) {
-		if err := cursor.Decode(&result); err != nil {
-			slog.Error(err.Error())
-			return nil, err
-		}
+	err := a.collection.FindOne(ctx, filter).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, e.ErrTokenNotFound
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, err
 	}
 
 	if result.GUID == "" || result.TokenString == "" {
@@ -94,12 +176,12 @@ func (a *authRepository) GetToken(ctx context.Context, provided models.RefreshTo
 	return &result, nil
 }
 
-// Store generated refresh token.
+// Store generated refresh token with its expiration time (it replaces the previous one of the user).
 func (a *authRepository) StoreToken(ctx context.Context, token models.RefreshToken) error {
-	slog.Debug("storetoken repo called")
-	_, err := a.collection.InsertOne(ctx, token)
+	slog.DebugContext(ctx, "storetoken repo called")
+	_, err := a.collection.UpdateOne(ctx, bson.M{"guid": token.GUID}, a.set(token), options.Update().SetUpsert(true))
 	if err != nil {
-		slog.Error(err.Error())
+		slog.ErrorContext(ctx, err.Error())
 		return err
 	}
 
@@ -110,32 +192,156 @@ func (a *authRepository) StoreToken(ctx context.Context, token models.RefreshTok
 // Create an updated document.
 // Update a document that matches the filter.
 func (a *authRepository) UpdateToken(ctx context.Context, provided models.RefreshToken) error {
-	slog.Debug("updatetoken repo called")
+	slog.DebugContext(ctx, "updatetoken repo called")
 	// Create a filter.
-	filter := bson.M{
+	filter := notExpired(bson.M{
 		"guid": provided.GUID,
-	}
+	})
 
 	// Create an updated document.
-	update := bson.M{
-		"$set": bson.M{
-			"tokenstring": provided.TokenString,
-			"guid":        provided.GUID,
-		},
-	}
-	slog.Debug(fmt.Sprintf("%v\n", update))
+	update := a.set(provided)
 
 	// Update a document that matches the filter.
 	result, err := a.collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		slog.Error(err.Error())
+		slog.ErrorContext(ctx, err.Error())
 		return err
 	}
 
 	if result.MatchedCount != 1 {
-		slog.Error("no matches")
+		slog.ErrorContext(ctx, "no matches")
 		return e.ErrUserNotFound
 	}
 
 	return nil
 }
+
+// Create a filter with the previous hash.
+// Replace the hash in a document that matches the filter.
+// Check if the token was already rotated or if there is no token at all.
+func (a *authRepository) RotateToken(ctx context.Context, previous string, provided models.RefreshToken) error {
+	slog.DebugContext(ctx, "rotatetoken repo called")
+	// Create a filter with the previous hash.
+	filter := notExpired(bson.M{
+		"guid":        provided.GUID,
+		"tokenstring": previous,
+	})
+
+	// Replace the hash in a document that matches the filter (keep the grace data along).
+	result, err := a.collection.UpdateOne(ctx, filter, a.set(provided))
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+
+	if result.MatchedCount == 1 {
+		return nil
+	}
+
+	// Check if the token was already rotated or if there is no token at all.
+	count, err := a.collection.CountDocuments(ctx, notExpired(bson.M{"guid": provided.GUID}))
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+
+	if count == 0 {
+		slog.ErrorContext(ctx, "no matches")
+		return e.ErrUserNotFound
+	}
+
+	slog.ErrorContext(ctx, "token was already rotated")
+	return e.ErrTokenAlreadyUsed
+}
+
+// Find ids of at most limit tokens expired before the time.
+// Delete them if they are still expired (a concurrent store could have renewed the token).
+func (a *authRepository) PurgeExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	slog.DebugContext(ctx, "purgeexpired repo called")
+	// Find ids of at most limit tokens expired before the time.
+	filter := bson.M{"expiresat": bson.M{"$lt": before}}
+	ids, err := a.batch(ctx, filter, limit)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	// Delete them if they are still expired (a concurrent store could have renewed the token).
+	filter["_id"] = bson.M{"$in": ids}
+	result, err := a.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return 0, err
+	}
+
+	return int(result.DeletedCount), nil
+}
+
+// Find ids of at most limit tokens rotated before the time that still keep the revoked hash.
+// Drop the revoked hash and the grace pair if the token was not rotated again meanwhile.
+func (a *authRepository) PurgeRotated(ctx context.Context, before time.Time, limit int) (int, error) {
+	slog.DebugContext(ctx, "purgerotated repo called")
+	// Find ids of at most limit tokens rotated before the time that still keep the revoked hash.
+	filter := bson.M{"rotatedat": bson.M{"$lt": before}, "previous": bson.M{"$nin": bson.A{"", nil}}}
+	ids, err := a.batch(ctx, filter, limit)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	// Drop the revoked hash and the grace pair if the token was not rotated again meanwhile.
+	filter["_id"] = bson.M{"$in": ids}
+	result, err := a.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"previous": "", "pair": ""}})
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return 0, err
+	}
+
+	return int(result.ModifiedCount), nil
+}
+
+// Ids of at most limit documents matching the filter.
+func (a *authRepository) batch(ctx context.Context, filter bson.M, limit int) (bson.A, error) {
+	cursor, err := a.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(int64(limit)))
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, err
+	}
+
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, err
+	}
+
+	ids := make(bson.A, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc["_id"])
+	}
+	return ids, nil
+}
+
+// Update document of the token, expiration time is moved by the refresh token lifetime.
+func (a *authRepository) set(token models.RefreshToken) bson.M {
+	fields := bson.M{
+		"guid":        token.GUID,
+		"tokenstring": token.TokenString,
+		"previous":    token.Previous,
+		"pair":        token.Pair,
+		"rotatedat":   token.RotatedAt,
+		"session":     token.Session,
+	}
+	if a.ttl <= 0 {
+		return bson.M{"$set": fields, "$unset": bson.M{"expiresat": ""}}
+	}
+
+	fields["expiresat"] = time.Now().Add(a.ttl)
+	return bson.M{"$set": fields}
+}
+
+// Add expiration condition to the filter (tokens stored without expiration time never expire).
+func notExpired(filter bson.M) bson.M {
+	filter["$or"] = bson.A{
+		bson.M{"expiresat": bson.M{"$gt": time.Now()}},
+		bson.M{"expiresat": bson.M{"$exists": false}},
+	}
+	return filter
+}
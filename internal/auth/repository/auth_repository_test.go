@@ -2,168 +2,109 @@ package repository_test
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
 	"testing"
+	"time"
 
 	"github.com/VanLavr/auth/internal/auth/repository"
-	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/auth/repository/repositorytest"
+	usecase "github.com/VanLavr/auth/internal/auth/service"
 	"github.com/VanLavr/auth/internal/pkg/config"
-	e "github.com/VanLavr/auth/internal/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func TestStoreToken(t *testing.T) {
-	testcases := []struct {
-		arg           models.RefreshToken
-		expectedError error
-	}{
-		{
-			arg: models.RefreshToken{
-				GUID:        "adsf",
-				TokenString: "adsf",
-			},
-			expectedError: nil,
+func TestMongoRepository(t *testing.T) {
+	uri := startMongo(t)
+	repositorytest.Suite{
+		New: func(t *testing.T, cfg *config.Config) usecase.Repository {
+			cfg.Mongo = uri
+			return connectMongo(t, cfg)
 		},
-	}
+	}.Run(t)
+}
 
-	config := &config.Config{
-		Mongo:          "mongodb://127.0.0.1:27017",
-		DBName:         "auth_test",
-		CollectionName: "users_tokens",
-	}
-	repo := repository.New(config)
-	repo.Connect(context.Background(), config)
-	defer func() {
-		if err := repo.CloseConnetion(context.Background()); err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	for _, tc := range testcases {
-		assert := assert.New(t)
-		err := repo.StoreToken(context.TODO(), tc.arg)
-		log.Println(err)
-		assert.Equal(tc.expectedError, err)
+// Indexes are created on connect and connecting again does not fail.
+func TestMongoIndexes(t *testing.T) {
+	assert := assert.New(t)
+	uri := startMongo(t)
+	for i := 0; i < 2; i++ {
+		repo := connectMongo(t, &config.Config{Mongo: uri, RefreshExpTime: time.Minute})
+		assert.Nil(repo.CloseConnetion(context.Background()))
 	}
-}
 
-func TestUpdateToken(t *testing.T) {
-	testcases := []struct {
-		tokenToStore  models.RefreshToken
-		tokenToUpdate models.RefreshToken
-		expectedError error
-	}{
-		{
-			tokenToStore: models.RefreshToken{
-				GUID:        "adsf",
-				TokenString: "adsf",
-			},
-			tokenToUpdate: models.RefreshToken{
-				GUID:        "adsf",
-				TokenString: "updated",
-			},
-			expectedError: nil,
-		},
-		{
-			tokenToStore: models.RefreshToken{
-				GUID:        "adsf",
-				TokenString: "adsf",
-			},
-			tokenToUpdate: models.RefreshToken{
-				GUID:        "333",
-				TokenString: "won't update",
-			},
-			expectedError: e.ErrUserNotFound,
-		},
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer client.Disconnect(context.Background())
 
-	config := &config.Config{
-		Mongo:          "mongodb://127.0.0.1:27017",
-		DBName:         "auth_test",
-		CollectionName: "users_tokens",
+	cursor, err := client.Database("auth_test").Collection("users_tokens").Indexes().List(context.Background())
+	if err != nil {
+		t.Fatal(err)
 	}
-	repo := repository.New(config)
-	repo.Connect(context.Background(), config)
-	defer func() {
-		if err := repo.CloseConnetion(context.Background()); err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	for _, tc := range testcases {
-		assert := assert.New(t)
-		err := repo.StoreToken(context.Background(), tc.tokenToStore)
-		fatalOnErr(err)
-
-		err = repo.UpdateToken(context.Background(), tc.tokenToUpdate)
-
-		assert.Equal(tc.expectedError, err)
+	var indexes []bson.M
+	if err := cursor.All(context.Background(), &indexes); err != nil {
+		t.Fatal(err)
 	}
+
+	byName := make(map[string]bson.M)
+	for _, index := range indexes {
+		byName[index["name"].(string)] = index
+	}
+	assert.Equal(true, byName["guid_unique"]["unique"])
+	assert.EqualValues(0, byName["expiresat_ttl"]["expireAfterSeconds"])
 }
 
-func TestGetToken(t *testing.T) {
-	testcases := []struct {
-		tokenToStore   models.RefreshToken
-		provided       models.RefreshToken
-		expectedResult *models.RefreshToken
-		expectedError  error
-	}{
-		{
-			tokenToStore: models.RefreshToken{
-				GUID:        "adsf",
-				TokenString: "adsf",
-			},
-			provided: models.RefreshToken{
-				GUID:        "adsf",
-				TokenString: "",
-			},
-			expectedResult: &models.RefreshToken{
-				GUID:        "adsf",
-				TokenString: "adsf",
-			},
-			expectedError: nil,
-		},
-		{
-			tokenToStore: models.RefreshToken{
-				GUID:        "adsf",
-				TokenString: "adsf",
-			},
-			provided: models.RefreshToken{
-				GUID:        "333",
-				TokenString: "",
-			},
-			expectedResult: nil,
-			expectedError:  e.ErrTokenNotFound,
-		},
+// Use mongo from MONGO_TEST_URI or start a local mongod in a temporary directory.
+// Skip the test if there is neither.
+func startMongo(t *testing.T) string {
+	if uri := os.Getenv("MONGO_TEST_URI"); uri != "" {
+		return uri
+	}
+
+	mongod, err := exec.LookPath("mongod")
+	if err != nil {
+		t.Skip("mongod is not available, set MONGO_TEST_URI to test against running mongo")
 	}
 
-	config := &config.Config{
-		Mongo:          "mongodb://127.0.0.1:27017",
-		DBName:         "auth_test",
-		CollectionName: "users_tokens",
+	// Pick a free port.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
 	}
-	repo := repository.New(config)
-	repo.Connect(context.Background(), config)
-	defer func() {
-		if err := repo.CloseConnetion(context.Background()); err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	for _, tc := range testcases {
-		assert := assert.New(t)
-		err := repo.StoreToken(context.Background(), tc.tokenToStore)
-		fatalOnErr(err)
-
-		token, err := repo.GetToken(context.Background(), tc.provided)
-
-		assert.Equal(tc.expectedError, err)
-		assert.Equal(tc.expectedResult, token)
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cmd := exec.Command(mongod, "--dbpath", t.TempDir(), "--bind_ip", "127.0.0.1", "--port", fmt.Sprint(port), "--quiet")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
 	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	return fmt.Sprintf("mongodb://127.0.0.1:%d", port)
 }
 
-func fatalOnErr(err error) {
-	if err != nil {
-		log.Fatal(err)
+// Connect to mongo, waiting for it to start.
+func connectMongo(t *testing.T, cfg *config.Config) usecase.Repository {
+	cfg.DBName = "auth_test"
+	cfg.CollectionName = "users_tokens"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	repo := repository.New(cfg)
+	if err := repo.Connect(ctx, cfg); err != nil {
+		t.Fatalf("mongo is not available: %v", err)
 	}
+
+	return repo
 }
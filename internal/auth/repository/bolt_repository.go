@@ -0,0 +1,433 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("refresh_tokens")
+
+// Refresh token hashes in a single bbolt file, keyed by guid.
+// Every write is a fsynced transaction and bbolt has a single writer, so rotation is a check-and-set.
+// Expired tokens are swept in background (revoked hashes are purged by the janitor) and the file is periodically compacted
+// into a temporary file that atomically replaces the data file.
+type boltRepository struct {
+	path            string
+	ttl             time.Duration
+	sweepInterval   time.Duration
+	compactInterval time.Duration
+
+	// mu guards db, it is replaced by compaction.
+	mu   sync.RWMutex
+	db   *bolt.DB
+	stop chan struct{}
+	done chan struct{}
+}
+
+type boltToken struct {
+	GUID        string         `json:"guid"`
+	TokenString string         `json:"tokenstring"`
+	Previous    string         `json:"previous,omitempty"`
+	Pair        string         `json:"pair,omitempty"`
+	RotatedAt   time.Time      `json:"rotated_at,omitempty"`
+	Session     models.Session `json:"session"`
+	Expires     time.Time      `json:"expires,omitempty"`
+}
+
+func newBoltRepository(cfg *config.Config) *boltRepository {
+	return &boltRepository{
+		path:            cfg.BoltFile,
+		ttl:             cfg.RefreshExpTime,
+		sweepInterval:   cfg.BoltSweepInterval,
+		compactInterval: cfg.BoltCompactInterval,
+	}
+}
+
+// Open data file.
+// Start sweeping and compaction.
+func (b *boltRepository) Connect(ctx context.Context, cfg *config.Config) error {
+	slog.DebugContext(ctx, "connect bolt repo called")
+	// Open data file.
+	db, err := openBolt(b.path)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+
+	// Start sweeping and compaction.
+	b.mu.Lock()
+	b.db = db
+	b.stop = make(chan struct{})
+	b.done = make(chan struct{})
+	b.mu.Unlock()
+	go b.maintain(context.WithoutCancel(ctx), b.stop, b.done)
+
+	return nil
+}
+
+// Stop background work and close data file.
+func (b *boltRepository) CloseConnetion(ctx context.Context) error {
+	slog.DebugContext(ctx, "closeconnection bolt repo called")
+	close(b.stop)
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.db.Close(); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Ping() checks that the data file is open.
+func (b *boltRepository) Ping(ctx context.Context) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.db.View(func(*bolt.Tx) error { return nil })
+}
+
+// Find not expired token by guid.
+func (b *boltRepository) GetToken(ctx context.Context, provided models.RefreshToken) (*models.RefreshToken, error) {
+	slog.DebugContext(ctx, "gettoken bolt repo called")
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var (
+		stored boltToken
+		found  bool
+	)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		stored, found, err = lookupBolt(tx, provided.GUID)
+		return err
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, err
+	}
+	if !found {
+		return nil, e.ErrTokenNotFound
+	}
+
+	return stored.model(), nil
+}
+
+// Store generated refresh token (it replaces the previous one of the user).
+func (b *boltRepository) StoreToken(ctx context.Context, token models.RefreshToken) error {
+	slog.DebugContext(ctx, "storetoken bolt repo called")
+	return b.update(ctx, func(tx *bolt.Tx) error {
+		return b.put(tx, token)
+	})
+}
+
+// Check if there is a token of the user.
+// Replace it.
+func (b *boltRepository) UpdateToken(ctx context.Context, provided models.RefreshToken) error {
+	slog.DebugContext(ctx, "updatetoken bolt repo called")
+	return b.update(ctx, func(tx *bolt.Tx) error {
+		// Check if there is a token of the user.
+		_, found, err := lookupBolt(tx, provided.GUID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return e.ErrUserNotFound
+		}
+
+		// Replace it.
+		return b.put(tx, provided)
+	})
+}
+
+// Check if there is a token of the user.
+// Check if it was already rotated.
+// Replace it.
+func (b *boltRepository) RotateToken(ctx context.Context, previous string, provided models.RefreshToken) error {
+	slog.DebugContext(ctx, "rotatetoken bolt repo called")
+	return b.update(ctx, func(tx *bolt.Tx) error {
+		// Check if there is a token of the user.
+		stored, found, err := lookupBolt(tx, provided.GUID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return e.ErrUserNotFound
+		}
+
+		// Check if it was already rotated.
+		if stored.TokenString != previous {
+			return e.ErrTokenAlreadyUsed
+		}
+
+		// Replace it.
+		return b.put(tx, provided)
+	})
+}
+
+// Delete at most limit tokens expired before the time.
+func (b *boltRepository) PurgeExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	slog.DebugContext(ctx, "purgeexpired bolt repo called")
+	return b.purge(ctx, func(stored *boltToken) bool {
+		return stored.expired(before)
+	}, nil, limit)
+}
+
+// Drop revoked hashes and grace pairs of at most limit tokens rotated before the time.
+func (b *boltRepository) PurgeRotated(ctx context.Context, before time.Time, limit int) (int, error) {
+	slog.DebugContext(ctx, "purgerotated bolt repo called")
+	return b.purge(ctx, func(stored *boltToken) bool {
+		return stored.Previous != "" && !stored.RotatedAt.IsZero() && stored.RotatedAt.Before(before)
+	}, func(stored *boltToken) {
+		stored.Previous, stored.Pair = "", ""
+	}, limit)
+}
+
+// Find at most limit matching tokens.
+// Delete them (or rewrite them with drop if it is set) after the walk, changing the bucket under a cursor invalidates it.
+func (b *boltRepository) purge(ctx context.Context, match func(*boltToken) bool, drop func(*boltToken), limit int) (int, error) {
+	purged := 0
+	err := b.update(ctx, func(tx *bolt.Tx) error {
+		// Find at most limit matching tokens.
+		bucket := tx.Bucket(boltBucket)
+		var found []boltToken
+		cursor := bucket.Cursor()
+		for key, value := cursor.First(); key != nil && (limit <= 0 || len(found) < limit); key, value = cursor.Next() {
+			var stored boltToken
+			if err := json.Unmarshal(value, &stored); err != nil {
+				return err
+			}
+			if match(&stored) {
+				found = append(found, stored)
+			}
+		}
+
+		// Delete them (or rewrite them with drop if it is set) after the walk.
+		for _, stored := range found {
+			if drop == nil {
+				if err := bucket.Delete([]byte(stored.GUID)); err != nil {
+					return err
+				}
+				continue
+			}
+			drop(&stored)
+			value, err := json.Marshal(stored)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(stored.GUID), value); err != nil {
+				return err
+			}
+		}
+		purged = len(found)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+func (b *boltRepository) update(ctx context.Context, fn func(*bolt.Tx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.db.Update(fn); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+	return nil
+}
+
+func (b *boltRepository) put(tx *bolt.Tx, token models.RefreshToken) error {
+	stored := boltToken{
+		GUID:        token.GUID,
+		TokenString: token.TokenString,
+		Previous:    token.Previous,
+		Pair:        token.Pair,
+		RotatedAt:   token.RotatedAt,
+		Session:     token.Session,
+	}
+	if b.ttl > 0 {
+		stored.Expires = time.Now().Add(b.ttl)
+	}
+
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltBucket).Put([]byte(token.GUID), value)
+}
+
+// Return not expired token of the user.
+func lookupBolt(tx *bolt.Tx, guid string) (boltToken, bool, error) {
+	value := tx.Bucket(boltBucket).Get([]byte(guid))
+	if value == nil {
+		return boltToken{}, false, nil
+	}
+
+	var stored boltToken
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return boltToken{}, false, err
+	}
+	if stored.expired(time.Now()) {
+		return boltToken{}, false, nil
+	}
+	return stored, true, nil
+}
+
+func (t boltToken) expired(now time.Time) bool {
+	return !t.Expires.IsZero() && !now.Before(t.Expires)
+}
+
+func (t boltToken) model() *models.RefreshToken {
+	return &models.RefreshToken{
+		GUID:        t.GUID,
+		TokenString: t.TokenString,
+		Previous:    t.Previous,
+		Pair:        t.Pair,
+		RotatedAt:   t.RotatedAt,
+		Session:     t.Session,
+	}
+}
+
+// Sweep expired tokens and compact the file every interval until stopped.
+func (b *boltRepository) maintain(ctx context.Context, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	sweep, compact := tick(b.sweepInterval), tick(b.compactInterval)
+	defer sweep.Stop()
+	defer compact.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-sweep.C:
+			if err := b.sweep(ctx, now); err != nil {
+				slog.ErrorContext(ctx, err.Error())
+			}
+		case <-compact.C:
+			if err := b.compact(ctx); err != nil {
+				slog.ErrorContext(ctx, err.Error())
+			}
+		}
+	}
+}
+
+// Ticker that never fires if interval is not positive.
+func tick(interval time.Duration) *time.Ticker {
+	if interval <= 0 {
+		ticker := time.NewTicker(time.Hour)
+		ticker.Stop()
+		return ticker
+	}
+	return time.NewTicker(interval)
+}
+
+// Delete expired tokens.
+func (b *boltRepository) sweep(ctx context.Context, now time.Time) error {
+	swept := 0
+	err := b.update(ctx, func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltBucket).Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			var stored boltToken
+			if err := json.Unmarshal(value, &stored); err != nil {
+				return err
+			}
+			if stored.expired(now) {
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+				swept++
+			}
+		}
+		return nil
+	})
+	if err == nil && swept > 0 {
+		slog.InfoContext(ctx, "swept expired tokens", "count", swept)
+	}
+	return err
+}
+
+// Copy live data into a temporary file (bbolt never shrinks the data file on its own).
+// Replace the data file with it and switch to the copy (the live file is kept if it could not be replaced).
+func (b *boltRepository) compact(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Copy live data into a temporary file (bbolt never shrinks the data file on its own).
+	tmp := b.path + ".compact"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	if err := bolt.Compact(dst, b.db, 64<<20); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	// Replace the data file with it and switch to the copy (the live file is kept if it could not be replaced).
+	// The copy stays open while it is renamed, so there is no reopening that could fail.
+	if err := os.Rename(tmp, b.path); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := syncDir(filepath.Dir(b.path)); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
+	if err := b.db.Close(); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
+	b.db = dst
+	slog.InfoContext(ctx, "compacted data file", "path", b.path)
+	return nil
+}
+
+// Open data file and create the bucket.
+func openBolt(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Persist rename in the directory.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
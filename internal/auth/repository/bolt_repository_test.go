@@ -0,0 +1,93 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/auth/repository"
+	"github.com/VanLavr/auth/internal/auth/repository/repositorytest"
+	usecase "github.com/VanLavr/auth/internal/auth/service"
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	"github.com/beevik/guid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoltRepository(t *testing.T) {
+	repositorytest.Suite{
+		New: func(t *testing.T, cfg *config.Config) usecase.Repository {
+			cfg.BoltFile = filepath.Join(t.TempDir(), "tokens.db")
+			return connectBolt(t, cfg)
+		},
+	}.Run(t)
+}
+
+// Tokens survive reopening of the data file.
+func TestBoltRepositoryReopen(t *testing.T) {
+	assert := assert.New(t)
+	cfg := &config.Config{BoltFile: filepath.Join(t.TempDir(), "tokens.db"), RefreshExpTime: time.Minute}
+	token := models.RefreshToken{GUID: guid.NewString(), TokenString: "stored"}
+
+	repo := connectBolt(t, cfg)
+	assert.Nil(repo.StoreToken(context.Background(), token))
+	assert.Nil(repo.CloseConnetion(context.Background()))
+
+	repo = connectBolt(t, cfg)
+	defer repo.CloseConnetion(context.Background())
+	stored, err := repo.GetToken(context.Background(), token)
+	assert.Nil(err)
+	assert.Equal(&token, stored)
+}
+
+// Expired tokens are swept and the data file shrinks after compaction.
+func TestBoltRepositoryCompaction(t *testing.T) {
+	assert := assert.New(t)
+	cfg := &config.Config{
+		BoltFile:            filepath.Join(t.TempDir(), "tokens.db"),
+		RefreshExpTime:      100 * time.Millisecond,
+		BoltSweepInterval:   50 * time.Millisecond,
+		BoltCompactInterval: 300 * time.Millisecond,
+	}
+	repo := connectBolt(t, cfg)
+	defer repo.CloseConnetion(context.Background())
+
+	for i := 0; i < 2000; i++ {
+		token := models.RefreshToken{GUID: guid.NewString(), TokenString: strings.Repeat("x", 128)}
+		if err := repo.StoreToken(context.Background(), token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	full := fileSize(t, cfg.BoltFile)
+
+	assert.Eventually(func() bool {
+		return fileSize(t, cfg.BoltFile) < full/2
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// Repository still works after the file was replaced.
+	token := models.RefreshToken{GUID: guid.NewString(), TokenString: "stored"}
+	assert.Nil(repo.StoreToken(context.Background(), token))
+	_, err := repo.GetToken(context.Background(), token)
+	assert.Nil(err)
+}
+
+func connectBolt(t *testing.T, cfg *config.Config) usecase.Repository {
+	cfg.Store = config.StoreBolt
+	repo := repository.New(cfg)
+	if err := repo.Connect(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+)
+
+// In-memory storage of refresh token hashes (one per guid).
+// Tokens expire after the refresh token lifetime since the last write,
+// expired tokens are not returned and are purged in background while connected.
+type memoryRepository struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	tokens map[string]memoryToken
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+type memoryToken struct {
+	token   models.RefreshToken
+	expires time.Time
+}
+
+func newMemoryRepository(cfg *config.Config) *memoryRepository {
+	return &memoryRepository{
+		ttl:    cfg.RefreshExpTime,
+		tokens: make(map[string]memoryToken),
+	}
+}
+
+// Connect() starts purging of expired tokens.
+func (m *memoryRepository) Connect(ctx context.Context, cfg *config.Config) error {
+	slog.DebugContext(ctx, "connect memory repo called")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stop != nil || m.ttl <= 0 {
+		return nil
+	}
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go m.purge(m.ttl, m.stop, m.done)
+
+	return nil
+}
+
+// CloseConnetion() stops purging of expired tokens, stored tokens are kept.
+func (m *memoryRepository) CloseConnetion(ctx context.Context) error {
+	slog.DebugContext(ctx, "closeconnection memory repo called")
+	m.mu.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.mu.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Ping() never fails, tokens are in process memory.
+func (m *memoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Find a token by guid.
+// Check if it is expired.
+func (m *memoryRepository) GetToken(ctx context.Context, provided models.RefreshToken) (*models.RefreshToken, error) {
+	slog.DebugContext(ctx, "gettoken memory repo called")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Find a token by guid.
+	// Check if it is expired.
+	stored, ok := m.lookup(provided.GUID)
+	if !ok {
+		return nil, e.ErrTokenNotFound
+	}
+
+	token := stored.token
+	return &token, nil
+}
+
+// Store generated refresh token (it replaces the previous one of the user).
+func (m *memoryRepository) StoreToken(ctx context.Context, token models.RefreshToken) error {
+	slog.DebugContext(ctx, "storetoken memory repo called")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tokens[token.GUID] = m.entry(token)
+	return nil
+}
+
+// Check if there is a token of the user.
+// Replace it.
+func (m *memoryRepository) UpdateToken(ctx context.Context, provided models.RefreshToken) error {
+	slog.DebugContext(ctx, "updatetoken memory repo called")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Check if there is a token of the user.
+	if _, ok := m.lookup(provided.GUID); !ok {
+		slog.ErrorContext(ctx, "no matches")
+		return e.ErrUserNotFound
+	}
+
+	// Replace it.
+	m.tokens[provided.GUID] = m.entry(provided)
+	return nil
+}
+
+// Check if there is a token of the user.
+// Check if it was already rotated.
+// Replace it.
+func (m *memoryRepository) RotateToken(ctx context.Context, previous string, provided models.RefreshToken) error {
+	slog.DebugContext(ctx, "rotatetoken memory repo called")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Check if there is a token of the user.
+	stored, ok := m.lookup(provided.GUID)
+	if !ok {
+		slog.ErrorContext(ctx, "no matches")
+		return e.ErrUserNotFound
+	}
+
+	// Check if it was already rotated.
+	if stored.token.TokenString != previous {
+		slog.ErrorContext(ctx, "token was already rotated")
+		return e.ErrTokenAlreadyUsed
+	}
+
+	// Replace it.
+	m.tokens[provided.GUID] = m.entry(provided)
+	return nil
+}
+
+// Return not expired token of the user, must be called with the lock held.
+func (m *memoryRepository) lookup(guid string) (memoryToken, bool) {
+	stored, ok := m.tokens[guid]
+	if !ok {
+		return memoryToken{}, false
+	}
+	if !stored.expires.IsZero() && !time.Now().Before(stored.expires) {
+		delete(m.tokens, guid)
+		return memoryToken{}, false
+	}
+	return stored, true
+}
+
+func (m *memoryRepository) entry(token models.RefreshToken) memoryToken {
+	stored := memoryToken{token: token}
+	if m.ttl > 0 {
+		stored.expires = time.Now().Add(m.ttl)
+	}
+	return stored
+}
+
+// Remove expired tokens every interval until stopped.
+func (m *memoryRepository) purge(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for guid, stored := range m.tokens {
+				if !stored.expires.IsZero() && !now.Before(stored.expires) {
+					delete(m.tokens, guid)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
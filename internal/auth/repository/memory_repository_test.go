@@ -0,0 +1,25 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/VanLavr/auth/internal/auth/repository"
+	"github.com/VanLavr/auth/internal/auth/repository/repositorytest"
+	usecase "github.com/VanLavr/auth/internal/auth/service"
+	"github.com/VanLavr/auth/internal/pkg/config"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	repositorytest.Suite{New: connectMemory}.Run(t)
+}
+
+func connectMemory(t *testing.T, cfg *config.Config) usecase.Repository {
+	cfg.Store = config.StoreMemory
+	repo := repository.New(cfg)
+	if err := repo.Connect(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/VanLavr/auth/internal/pkg/config"
+)
+
+// Migrator is implemented by repositories with a schema (mongo and postgres).
+type Migrator interface {
+	// Migrate() applies pending migrations under a lock, on dry run it only writes what would change.
+	Migrate(ctx context.Context, dryRun bool, out io.Writer) error
+}
+
+// Connect to the store selected in config without migrating on connect.
+// Apply migrations if the store has a schema.
+func Migrate(ctx context.Context, cfg *config.Config, dryRun bool, out io.Writer) error {
+	// Connect to the store selected in config without migrating on connect.
+	migrateCfg := *cfg
+	migrateCfg.SkipMigrations = true
+
+	repo := New(&migrateCfg)
+	migrator, ok := repo.(Migrator)
+	if !ok {
+		fmt.Fprintf(out, "%s store has no schema to migrate\n", cfg.Store)
+		return nil
+	}
+
+	if err := repo.Connect(ctx, &migrateCfg); err != nil {
+		return err
+	}
+	defer repo.CloseConnetion(context.WithoutCancel(ctx))
+
+	// Apply migrations if the store has a schema.
+	return migrator.Migrate(ctx, dryRun, out)
+}
@@ -0,0 +1,82 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/auth/repository"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMigrateWithoutSchema(t *testing.T) {
+	var out bytes.Buffer
+
+	err := repository.Migrate(context.Background(), &config.Config{Store: config.StoreMemory}, false, &out)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "memory store has no schema to migrate\n", out.String())
+}
+
+// Legacy {guid, tokenstring} documents with duplicates are described on dry run,
+// then deduplicated and completed, and migrating again changes nothing.
+func TestMongoMigrations(t *testing.T) {
+	assert := assert.New(t)
+	uri := startMongo(t)
+	cfg := &config.Config{Mongo: uri, DBName: "auth_migrations_test", CollectionName: "users_tokens", RefreshExpTime: time.Minute}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+	database := client.Database(cfg.DBName)
+	if err := database.Drop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	collection := database.Collection(cfg.CollectionName)
+	if _, err := collection.InsertMany(context.Background(), []any{
+		bson.M{"guid": "a", "tokenstring": "old"},
+		bson.M{"guid": "a", "tokenstring": "new"},
+		bson.M{"guid": "b", "tokenstring": "only"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	assert.Nil(repository.Migrate(context.Background(), cfg, true, &out))
+	assert.Equal("would apply 1 dedupe_guids: delete 1 outdated duplicate documents\n"+
+		"would apply 2 grace_and_expiration_fields: add grace fields to 3 documents, add expiration time to 3 documents\n", out.String())
+
+	out.Reset()
+	assert.Nil(repository.Migrate(context.Background(), cfg, false, &out))
+	assert.Equal("applied 1 dedupe_guids\napplied 2 grace_and_expiration_fields\n", out.String())
+
+	count, err := collection.CountDocuments(context.Background(), bson.M{"expiresat": bson.M{"$exists": true}})
+	assert.Nil(err)
+	assert.EqualValues(2, count)
+	var latest bson.M
+	assert.Nil(collection.FindOne(context.Background(), bson.M{"guid": "a"}).Decode(&latest))
+	assert.Equal("new", latest["tokenstring"])
+
+	out.Reset()
+	assert.Nil(repository.Migrate(context.Background(), cfg, false, &out))
+	assert.True(strings.HasPrefix(out.String(), "schema is up to date (version 2)"))
+}
+
+// Dry run prints SQL of pending migrations.
+func TestPostgresMigrationsDryRun(t *testing.T) {
+	dsn := startPostgres(t)
+	var out bytes.Buffer
+
+	err := repository.Migrate(context.Background(), &config.Config{Store: config.StorePostgres, Postgres: dsn}, true, &out)
+
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "CREATE TABLE IF NOT EXISTS refresh_tokens")
+}
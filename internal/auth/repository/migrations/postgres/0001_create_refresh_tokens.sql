@@ -0,0 +1,12 @@
+-- One refresh token hash per user (guid), previous/pair/rotated_at keep the grace data of the last rotation.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    guid        TEXT PRIMARY KEY,
+    tokenstring TEXT NOT NULL,
+    previous    TEXT NOT NULL DEFAULT '',
+    pair        TEXT NOT NULL DEFAULT '',
+    rotated_at  TIMESTAMPTZ,
+    expires_at  TIMESTAMPTZ
+);
+
+-- Expired tokens are looked up by expiration time when they are purged.
+CREATE INDEX IF NOT EXISTS refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
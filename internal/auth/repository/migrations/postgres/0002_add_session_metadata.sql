@@ -0,0 +1,8 @@
+-- Metadata of the session the token belongs to, carried over by rotation.
+ALTER TABLE refresh_tokens
+    ADD COLUMN IF NOT EXISTS created_at   TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS rotations    INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS client_ip    TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS user_agent   TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS client_id    TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Tokens keeping a revoked hash are looked up by rotation time when the hash is purged.
+CREATE INDEX IF NOT EXISTS refresh_tokens_rotated_at_idx ON refresh_tokens (rotated_at) WHERE previous <> '';
@@ -0,0 +1,243 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/migrate"
+	"github.com/beevik/guid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How long a crashed migration keeps the lock, a running one renews it.
+const mongoMigrationLease = time.Minute
+
+// Migrate() applies migrations of token documents (only describes them on dry run)
+// and ensures indexes once the schema is up to date.
+func (a *authRepository) Migrate(ctx context.Context, dryRun bool, out io.Writer) error {
+	if err := migrate.Run(ctx, a.migrationStore(), a.migrations(), dryRun, out); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	return a.ensureIndexes(ctx)
+}
+
+// Migrations of token documents, versions are never reused or reordered.
+func (a *authRepository) migrations() []migrate.Migration {
+	return []migrate.Migration{
+		{
+			// Older versions inserted a new document on every login,
+			// keep only the latest document of every guid so the unique index can be built.
+			Version: 1,
+			Name:    "dedupe_guids",
+			Plan: func(ctx context.Context) (string, error) {
+				ids, err := a.duplicates(ctx)
+				return fmt.Sprintf("delete %d outdated duplicate documents", len(ids)), err
+			},
+			Up: func(ctx context.Context) error {
+				ids, err := a.duplicates(ctx)
+				if err != nil || len(ids) == 0 {
+					return err
+				}
+				_, err = a.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+				return err
+			},
+		},
+		{
+			// Documents stored as {guid, tokenstring} get empty grace fields and the expiration time
+			// (as if they were written now), so they are removed by the TTL index.
+			Version: 2,
+			Name:    "grace_and_expiration_fields",
+			Plan: func(ctx context.Context) (string, error) {
+				grace, err := a.collection.CountDocuments(ctx, bson.M{"previous": bson.M{"$exists": false}})
+				if err != nil {
+					return "", err
+				}
+				expiration, err := a.collection.CountDocuments(ctx, bson.M{"expiresat": bson.M{"$exists": false}})
+				if err != nil {
+					return "", err
+				}
+				if a.ttl <= 0 {
+					expiration = 0
+				}
+				return fmt.Sprintf("add grace fields to %d documents, add expiration time to %d documents", grace, expiration), nil
+			},
+			Up: func(ctx context.Context) error {
+				if _, err := a.collection.UpdateMany(ctx,
+					bson.M{"previous": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"previous": "", "pair": "", "rotatedat": time.Time{}}},
+				); err != nil {
+					return err
+				}
+				if a.ttl <= 0 {
+					return nil
+				}
+				_, err := a.collection.UpdateMany(ctx,
+					bson.M{"expiresat": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"expiresat": time.Now().Add(a.ttl)}},
+				)
+				return err
+			},
+		},
+	}
+}
+
+// Ids of all but the latest document of every guid.
+func (a *authRepository) duplicates(ctx context.Context) ([]any, error) {
+	cursor, err := a.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.M{"_id": -1}}},
+		{{Key: "$group", Value: bson.M{"_id": "$guid", "ids": bson.M{"$push": "$_id"}}}},
+		{{Key: "$match", Value: bson.M{"ids.1": bson.M{"$exists": true}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		IDs []any `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	var ids []any
+	for _, group := range groups {
+		ids = append(ids, group.IDs[1:]...)
+	}
+	return ids, nil
+}
+
+// Schema version and lock are documents of <collection>_migrations.
+type mongoMigrationStore struct {
+	collection *mongo.Collection
+	lease      time.Duration
+}
+
+func (a *authRepository) migrationStore() *mongoMigrationStore {
+	return &mongoMigrationStore{collection: a.database.Collection(a.collection.Name() + "_migrations"), lease: mongoMigrationLease}
+}
+
+// Insert lock document or take over an expired one.
+// Retry until it is acquired or ctx is done.
+func (s *mongoMigrationStore) Lock(ctx context.Context) (context.Context, func(context.Context) error, error) {
+	owner := guid.NewString()
+	for {
+		// Insert lock document or take over an expired one.
+		now := time.Now()
+		_, err := s.collection.InsertOne(ctx, bson.M{"_id": "lock", "owner": owner, "expiresat": now.Add(s.lease)})
+		if err == nil {
+			locked, unlock := s.hold(ctx, owner, now)
+			return locked, unlock, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, nil, err
+		}
+
+		result, err := s.collection.UpdateOne(ctx,
+			bson.M{"_id": "lock", "expiresat": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"owner": owner, "expiresat": now.Add(s.lease)}},
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		if result.ModifiedCount == 1 {
+			slog.WarnContext(ctx, "took over expired migration lock")
+			locked, unlock := s.hold(ctx, owner, now)
+			return locked, unlock, nil
+		}
+
+		// Retry until it is acquired or ctx is done.
+		slog.InfoContext(ctx, "waiting for migration lock")
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// Renew the lease every third of it while the lock is held.
+// Cancel the locked context if the lock was taken over or could not be renewed before the lease ran out.
+func (s *mongoMigrationStore) hold(ctx context.Context, owner string, acquired time.Time) (context.Context, func(context.Context) error) {
+	locked, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.lease / 3)
+		defer ticker.Stop()
+
+		renewed := acquired
+		for {
+			select {
+			case <-locked.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// Renew the lease every third of it while the lock is held.
+			now := time.Now()
+			result, err := s.collection.UpdateOne(locked,
+				bson.M{"_id": "lock", "owner": owner},
+				bson.M{"$set": bson.M{"expiresat": now.Add(s.lease)}},
+			)
+			if err == nil && result.MatchedCount == 1 {
+				renewed = now
+				continue
+			}
+
+			// Cancel the locked context if the lock was taken over or could not be renewed before the lease ran out.
+			if locked.Err() != nil {
+				return
+			}
+			if err == nil {
+				slog.ErrorContext(ctx, "migration lock was taken over")
+				cancel(migrate.ErrLockLost)
+				return
+			}
+			slog.WarnContext(ctx, "renew migration lock", "error", err)
+			if now.Sub(renewed) >= s.lease {
+				slog.ErrorContext(ctx, "migration lock expired")
+				cancel(migrate.ErrLockLost)
+				return
+			}
+		}
+	}()
+
+	return locked, func(ctx context.Context) error {
+		cancel(nil)
+		<-done
+		_, err := s.collection.DeleteOne(ctx, bson.M{"_id": "lock", "owner": owner})
+		return err
+	}
+}
+
+func (s *mongoMigrationStore) Version(ctx context.Context) (int, error) {
+	var doc struct {
+		Version int `bson:"version"`
+	}
+	err := s.collection.FindOne(ctx, bson.M{"_id": "version"}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return doc.Version, err
+}
+
+// Save the version and append the migration to the history.
+func (s *mongoMigrationStore) Record(ctx context.Context, m migrate.Migration) error {
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": "version"},
+		bson.M{
+			"$set":  bson.M{"version": m.Version},
+			"$push": bson.M{"history": bson.M{"version": m.Version, "name": m.Name, "appliedat": time.Now()}},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
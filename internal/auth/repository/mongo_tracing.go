@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/VanLavr/auth/internal/pkg/tracing"
+	"go.mongodb.org/mongo-driver/event"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span of every mongo command, started and ended by command events of the driver.
+// Commands are not recorded, they contain token hashes.
+type commandTracer struct {
+	mu    sync.Mutex
+	spans map[commandKey]trace.Span
+}
+
+type commandKey struct {
+	connection string
+	request    int64
+}
+
+func newCommandMonitor() *event.CommandMonitor {
+	t := &commandTracer{spans: make(map[commandKey]trace.Span)}
+	return &event.CommandMonitor{
+		Started: t.started,
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			t.finished(evt.CommandFinishedEvent, nil)
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			t.finished(evt.CommandFinishedEvent, errors.New(evt.Failure))
+		},
+	}
+}
+
+// Start span named after the command and its collection (the first command field holds it).
+func (t *commandTracer) started(ctx context.Context, evt *event.CommandStartedEvent) {
+	name := evt.CommandName
+	attributes := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemMongoDB, semconv.DBOperationName(evt.CommandName), semconv.DBNamespace(evt.DatabaseName)),
+	}
+	if first, err := evt.Command.IndexErr(0); err == nil {
+		if collection, ok := first.Value().StringValueOK(); ok {
+			name += " " + collection
+			attributes = append(attributes, trace.WithAttributes(semconv.DBCollectionName(collection)))
+		}
+	}
+
+	_, span := tracing.Start(ctx, name, attributes...)
+	if !span.IsRecording() {
+		return
+	}
+
+	t.mu.Lock()
+	t.spans[commandKey{evt.ConnectionID, evt.RequestID}] = span
+	t.mu.Unlock()
+}
+
+// End span of the command.
+func (t *commandTracer) finished(evt event.CommandFinishedEvent, err error) {
+	key := commandKey{evt.ConnectionID, evt.RequestID}
+	t.mu.Lock()
+	span, ok := t.spans[key]
+	delete(t.spans, key)
+	t.mu.Unlock()
+
+	if ok {
+		tracing.End(span, err)
+	}
+}
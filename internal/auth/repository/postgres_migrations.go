@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/VanLavr/auth/internal/pkg/migrate"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Migrations are named <version>_<name>.sql, every file is applied in its own transaction.
+//
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// Arbitrary key of the advisory lock that serializes migrations of concurrently starting instances.
+const postgresMigrationLock = 7_140_036
+
+// Migrate() applies embedded SQL migrations (only prints them on dry run).
+func (p *postgresRepository) Migrate(ctx context.Context, dryRun bool, out io.Writer) error {
+	migrations, err := postgresMigrationList(p.pool)
+	if err != nil {
+		return err
+	}
+
+	return migrate.Run(ctx, &postgresMigrationStore{pool: p.pool}, migrations, dryRun, out)
+}
+
+// Read embedded files in order of their names.
+// Parse version and name, plan of a migration is its SQL.
+func postgresMigrationList(pool *pgxpool.Pool) ([]migrate.Migration, error) {
+	// Read embedded files in order of their names.
+	files, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migrate.Migration
+	for _, file := range files {
+		// Parse version and name, plan of a migration is its SQL.
+		prefix, name, _ := strings.Cut(strings.TrimSuffix(path.Base(file), ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", file, err)
+		}
+		sql, err := postgresMigrations.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migrate.Migration{
+			Version: version,
+			Name:    name,
+			Plan:    func(context.Context) (string, error) { return "\n" + string(sql), nil },
+			Up: func(ctx context.Context) error {
+				tx, err := pool.Begin(ctx)
+				if err != nil {
+					return err
+				}
+				defer tx.Rollback(ctx)
+
+				if _, err := tx.Exec(ctx, string(sql)); err != nil {
+					return err
+				}
+				return tx.Commit(ctx)
+			},
+		})
+	}
+
+	return migrations, nil
+}
+
+// Applied migrations are rows of schema_migrations named as their files.
+type postgresMigrationStore struct {
+	pool *pgxpool.Pool
+}
+
+// Hold session advisory lock on a dedicated connection (it never expires while the connection is open).
+func (s *postgresMigrationStore) Lock(ctx context.Context) (context.Context, func(context.Context) error, error) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, postgresMigrationLock); err != nil {
+		conn.Release()
+		return nil, nil, err
+	}
+
+	return ctx, func(ctx context.Context) error {
+		defer conn.Release()
+		_, err := conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, postgresMigrationLock)
+		return err
+	}, nil
+}
+
+// Highest version in schema_migrations, 0 if the table does not exist yet.
+func (s *postgresMigrationStore) Version(ctx context.Context) (int, error) {
+	var exists bool
+	if err := s.pool.QueryRow(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	latest := 0
+	for rows.Next() {
+		var applied string
+		if err := rows.Scan(&applied); err != nil {
+			return 0, err
+		}
+		prefix, _, _ := strings.Cut(applied, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return 0, fmt.Errorf("applied migration %s: %w", applied, err)
+		}
+		latest = max(latest, version)
+	}
+
+	return latest, rows.Err()
+}
+
+func (s *postgresMigrationStore) Record(ctx context.Context, m migrate.Migration) error {
+	if _, err := s.pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+
+	_, err := s.pool.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1) ON CONFLICT DO NOTHING`,
+		fmt.Sprintf("%04d_%s", m.Version, m.Name))
+	return err
+}
@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/migrate"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Refresh token hashes in PostgreSQL, one row per guid.
+// Rotation is a conditional update on the previous hash, so only one of concurrent rotations matches the row.
+// Tokens expire after the refresh token lifetime since the last write.
+type postgresRepository struct {
+	dsn  string
+	ttl  time.Duration
+	pool *pgxpool.Pool
+}
+
+func newPostgresRepository(cfg *config.Config) *postgresRepository {
+	return &postgresRepository{dsn: cfg.Postgres, ttl: cfg.RefreshExpTime}
+}
+
+// Parse connection string and apply pool settings.
+// Connect and ping.
+// Apply migrations (unless they are skipped).
+func (p *postgresRepository) Connect(ctx context.Context, cfg *config.Config) error {
+	slog.DebugContext(ctx, "connect postgres repo called")
+	// Parse connection string and apply pool settings.
+	poolConfig, err := pgxpool.ParseConfig(p.dsn)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+	if cfg.PostgresMaxConns > 0 {
+		poolConfig.MaxConns = int32(cfg.PostgresMaxConns)
+	}
+	if cfg.PostgresMinConns > 0 {
+		poolConfig.MinConns = int32(cfg.PostgresMinConns)
+	}
+	if cfg.PostgresConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.PostgresConnLifetime
+	}
+	if cfg.PostgresConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.PostgresConnIdleTime
+	}
+
+	// Connect and ping.
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+	slog.InfoContext(ctx, "pinged")
+
+	p.pool = pool
+
+	// Apply migrations (unless they are skipped).
+	if !cfg.SkipMigrations {
+		if err := p.Migrate(ctx, false, io.Discard); err != nil {
+			pool.Close()
+			slog.ErrorContext(ctx, err.Error())
+			return err
+		}
+		return nil
+	}
+
+	migrations, err := postgresMigrationList(pool)
+	if err != nil {
+		pool.Close()
+		return err
+	}
+	version, err := (&postgresMigrationStore{pool: pool}).Version(ctx)
+	if err != nil {
+		pool.Close()
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+	if latest := migrate.Latest(migrations); version < latest {
+		slog.WarnContext(ctx, "schema is outdated, run migrate", "version", version, "latest", latest)
+	}
+
+	return nil
+}
+
+func (p *postgresRepository) CloseConnetion(ctx context.Context) error {
+	slog.DebugContext(ctx, "closeconnection postgres repo called")
+	p.pool.Close()
+	return nil
+}
+
+// Ping() checks that postgres is reachable.
+func (p *postgresRepository) Ping(ctx context.Context) error {
+	return p.pool.Ping(ctx)
+}
+
+// Find not expired token by guid.
+func (p *postgresRepository) GetToken(ctx context.Context, provided models.RefreshToken) (*models.RefreshToken, error) {
+	slog.DebugContext(ctx, "gettoken postgres repo called")
+	var (
+		result                           models.RefreshToken
+		rotatedAt, createdAt, lastUsedAt *time.Time
+	)
+	err := p.pool.QueryRow(ctx, `
+		SELECT guid, tokenstring, previous, pair, rotated_at,
+			created_at, last_used_at, rotations, client_ip, user_agent, client_id
+		FROM refresh_tokens
+		WHERE guid = $1 AND (expires_at IS NULL OR expires_at > now())`,
+		provided.GUID,
+	).Scan(
+		&result.GUID, &result.TokenString, &result.Previous, &result.Pair, &rotatedAt,
+		&createdAt, &lastUsedAt, &result.Session.Rotations, &result.Session.ClientIP, &result.Session.UserAgent, &result.Session.ClientID,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, e.ErrTokenNotFound
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, err
+	}
+
+	if rotatedAt != nil {
+		result.RotatedAt = *rotatedAt
+	}
+	if createdAt != nil {
+		result.Session.CreatedAt = *createdAt
+	}
+	if lastUsedAt != nil {
+		result.Session.LastUsedAt = *lastUsedAt
+	}
+	return &result, nil
+}
+
+// Store generated refresh token (it replaces the previous one of the user).
+func (p *postgresRepository) StoreToken(ctx context.Context, token models.RefreshToken) error {
+	slog.DebugContext(ctx, "storetoken postgres repo called")
+	_, err := p.pool.Exec(ctx, `
+		INSERT INTO refresh_tokens (guid, tokenstring, previous, pair, rotated_at, expires_at,
+			created_at, last_used_at, rotations, client_ip, user_agent, client_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (guid) DO UPDATE SET
+			tokenstring = excluded.tokenstring,
+			previous = excluded.previous,
+			pair = excluded.pair,
+			rotated_at = excluded.rotated_at,
+			expires_at = excluded.expires_at,
+			created_at = excluded.created_at,
+			last_used_at = excluded.last_used_at,
+			rotations = excluded.rotations,
+			client_ip = excluded.client_ip,
+			user_agent = excluded.user_agent,
+			client_id = excluded.client_id`,
+		p.values(token)...,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Replace not expired token of the user.
+func (p *postgresRepository) UpdateToken(ctx context.Context, provided models.RefreshToken) error {
+	slog.DebugContext(ctx, "updatetoken postgres repo called")
+	tag, err := p.pool.Exec(ctx, `
+		UPDATE refresh_tokens SET `+postgresSet+`
+		WHERE guid = $1 AND (expires_at IS NULL OR expires_at > now())`,
+		p.values(provided)...,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+
+	if tag.RowsAffected() != 1 {
+		slog.ErrorContext(ctx, "no matches")
+		return e.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// Replace the hash in a row that still has the previous hash.
+// Check if the token was already rotated or if there is no token at all.
+func (p *postgresRepository) RotateToken(ctx context.Context, previous string, provided models.RefreshToken) error {
+	slog.DebugContext(ctx, "rotatetoken postgres repo called")
+	// Replace the hash in a row that still has the previous hash.
+	tag, err := p.pool.Exec(ctx, `
+		UPDATE refresh_tokens SET `+postgresSet+`
+		WHERE guid = $1 AND tokenstring = $13 AND (expires_at IS NULL OR expires_at > now())`,
+		append(p.values(provided), previous)...,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+
+	if tag.RowsAffected() == 1 {
+		return nil
+	}
+
+	// Check if the token was already rotated or if there is no token at all.
+	var exists bool
+	if err := p.pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM refresh_tokens WHERE guid = $1 AND (expires_at IS NULL OR expires_at > now()))`,
+		provided.GUID,
+	).Scan(&exists); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+
+	if !exists {
+		slog.ErrorContext(ctx, "no matches")
+		return e.ErrUserNotFound
+	}
+
+	slog.ErrorContext(ctx, "token was already rotated")
+	return e.ErrTokenAlreadyUsed
+}
+
+// Delete at most limit tokens expired before the time (rows locked by a concurrent write are left for the next batch).
+func (p *postgresRepository) PurgeExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	slog.DebugContext(ctx, "purgeexpired postgres repo called")
+	tag, err := p.pool.Exec(ctx, `
+		DELETE FROM refresh_tokens WHERE guid IN (
+			SELECT guid FROM refresh_tokens WHERE expires_at < $1 LIMIT $2 FOR UPDATE SKIP LOCKED
+		)`,
+		before, limit,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+// Drop revoked hashes and grace pairs of at most limit tokens rotated before the time.
+func (p *postgresRepository) PurgeRotated(ctx context.Context, before time.Time, limit int) (int, error) {
+	slog.DebugContext(ctx, "purgerotated postgres repo called")
+	tag, err := p.pool.Exec(ctx, `
+		UPDATE refresh_tokens SET previous = '', pair = '' WHERE guid IN (
+			SELECT guid FROM refresh_tokens WHERE rotated_at < $1 AND previous <> '' LIMIT $2 FOR UPDATE SKIP LOCKED
+		)`,
+		before, limit,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+// Assignments of every column from values() parameters.
+const postgresSet = `tokenstring = $2, previous = $3, pair = $4, rotated_at = $5, expires_at = $6,
+			created_at = $7, last_used_at = $8, rotations = $9, client_ip = $10, user_agent = $11, client_id = $12`
+
+// Query parameters $1-$12 of the token.
+func (p *postgresRepository) values(token models.RefreshToken) []any {
+	return []any{
+		token.GUID, token.TokenString, token.Previous, token.Pair, nullTime(token.RotatedAt), p.expiresAt(),
+		nullTime(token.Session.CreatedAt), nullTime(token.Session.LastUsedAt), token.Session.Rotations,
+		token.Session.ClientIP, token.Session.UserAgent, token.Session.ClientID,
+	}
+}
+
+func (p *postgresRepository) expiresAt() *time.Time {
+	if p.ttl <= 0 {
+		return nil
+	}
+	expires := time.Now().Add(p.ttl)
+	return &expires
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
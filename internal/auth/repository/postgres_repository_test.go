@@ -0,0 +1,88 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/auth/repository"
+	"github.com/VanLavr/auth/internal/auth/repository/repositorytest"
+	usecase "github.com/VanLavr/auth/internal/auth/service"
+	"github.com/VanLavr/auth/internal/pkg/config"
+)
+
+func TestPostgresRepository(t *testing.T) {
+	dsn := startPostgres(t)
+	repositorytest.Suite{
+		New: func(t *testing.T, cfg *config.Config) usecase.Repository {
+			cfg.Postgres = dsn
+			return connectPostgres(t, cfg)
+		},
+	}.Run(t)
+}
+
+// Use postgres from POSTGRES_TEST_DSN or init and start a local cluster in a temporary directory.
+// Skip the test if there is neither.
+func startPostgres(t *testing.T) string {
+	if dsn := os.Getenv("POSTGRES_TEST_DSN"); dsn != "" {
+		return dsn
+	}
+
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		t.Skip("initdb is not available, set POSTGRES_TEST_DSN to test against running postgres")
+	}
+	postgres, err := exec.LookPath("postgres")
+	if err != nil {
+		t.Skip("postgres is not available, set POSTGRES_TEST_DSN to test against running postgres")
+	}
+
+	dir := t.TempDir()
+	if out, err := exec.Command(initdb, "-D", dir, "-U", "postgres", "--auth=trust").CombinedOutput(); err != nil {
+		t.Fatalf("initdb: %v: %s", err, out)
+	}
+
+	// Pick a free port.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cmd := exec.Command(postgres, "-D", dir, "-h", "127.0.0.1", "-p", fmt.Sprint(port), "-k", dir)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	return fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+}
+
+// Connect to postgres, waiting for it to start.
+func connectPostgres(t *testing.T, cfg *config.Config) usecase.Repository {
+	cfg.Store = config.StorePostgres
+	repo := repository.New(cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for {
+		err := repo.Connect(ctx, cfg)
+		if err == nil {
+			return repo
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("postgres is not available: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "auth:refresh_token:"
+
+// Replace the hash fields and reset expiration (no expiration if ttl is 0).
+// KEYS[1] - token key, ARGV - tokenstring, previous, pair, rotatedat, session json, ttl in milliseconds.
+const redisSet = `
+redis.call('HSET', KEYS[1], 'tokenstring', ARGV[1], 'previous', ARGV[2], 'pair', ARGV[3], 'rotatedat', ARGV[4], 'session', ARGV[5])
+if tonumber(ARGV[6]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[6])
+else
+	redis.call('PERSIST', KEYS[1])
+end
+`
+
+// Store token replacing the previous one.
+var redisStore = redis.NewScript(redisSet)
+
+// Update existing token, 0 if there is no token.
+var redisUpdate = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+` + redisSet + `
+return 1
+`)
+
+// Rotate token only if it still has the previous hash (ARGV[7]),
+// 0 if there is no token, -1 if it was already rotated.
+var redisRotate = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'tokenstring')
+if not current then
+	return 0
+end
+if current ~= ARGV[7] then
+	return -1
+end
+` + redisSet + `
+return 1
+`)
+
+// Refresh token hashes in Redis, a hash per guid with TTL equal to the refresh token lifetime,
+// so expired tokens disappear by themselves. Update and rotation are Lua scripts, so they are atomic.
+type redisRepository struct {
+	url    string
+	ttl    time.Duration
+	client *redis.Client
+}
+
+func newRedisRepository(cfg *config.Config) *redisRepository {
+	return &redisRepository{url: cfg.Redis, ttl: cfg.RefreshExpTime}
+}
+
+// Parse connection url.
+// Connect and ping.
+func (r *redisRepository) Connect(ctx context.Context, cfg *config.Config) error {
+	slog.DebugContext(ctx, "connect redis repo called")
+	// Parse connection url.
+	options, err := redis.ParseURL(r.url)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+
+	// Connect and ping.
+	client := redis.NewClient(options)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+	slog.InfoContext(ctx, "pinged")
+
+	r.client = client
+	return nil
+}
+
+func (r *redisRepository) CloseConnetion(ctx context.Context) error {
+	slog.DebugContext(ctx, "closeconnection redis repo called")
+	if err := r.client.Close(); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Ping() checks that redis is reachable.
+func (r *redisRepository) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// Read token fields by guid.
+func (r *redisRepository) GetToken(ctx context.Context, provided models.RefreshToken) (*models.RefreshToken, error) {
+	slog.DebugContext(ctx, "gettoken redis repo called")
+	fields, err := r.client.HGetAll(ctx, redisKeyPrefix+provided.GUID).Result()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, err
+	}
+	if fields["tokenstring"] == "" {
+		return nil, e.ErrTokenNotFound
+	}
+
+	token := &models.RefreshToken{
+		GUID:        provided.GUID,
+		TokenString: fields["tokenstring"],
+		Previous:    fields["previous"],
+		Pair:        fields["pair"],
+	}
+	if fields["rotatedat"] != "" {
+		if token.RotatedAt, err = time.Parse(time.RFC3339Nano, fields["rotatedat"]); err != nil {
+			slog.ErrorContext(ctx, err.Error())
+			return nil, err
+		}
+	}
+	if fields["session"] != "" {
+		if err := json.Unmarshal([]byte(fields["session"]), &token.Session); err != nil {
+			slog.ErrorContext(ctx, err.Error())
+			return nil, err
+		}
+	}
+
+	return token, nil
+}
+
+// Store generated refresh token (it replaces the previous one of the user).
+func (r *redisRepository) StoreToken(ctx context.Context, token models.RefreshToken) error {
+	slog.DebugContext(ctx, "storetoken redis repo called")
+	args, err := r.args(token)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+	if err := redisStore.Run(ctx, r.client, []string{redisKeyPrefix + token.GUID}, args...).Err(); err != nil && err != redis.Nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Replace token of the user if there is one.
+func (r *redisRepository) UpdateToken(ctx context.Context, provided models.RefreshToken) error {
+	slog.DebugContext(ctx, "updatetoken redis repo called")
+	args, err := r.args(provided)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+	result, err := redisUpdate.Run(ctx, r.client, []string{redisKeyPrefix + provided.GUID}, args...).Int()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+
+	if result == 0 {
+		slog.ErrorContext(ctx, "no matches")
+		return e.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// Replace the hash if it is still equal to the previous one.
+func (r *redisRepository) RotateToken(ctx context.Context, previous string, provided models.RefreshToken) error {
+	slog.DebugContext(ctx, "rotatetoken redis repo called")
+	args, err := r.args(provided)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+	result, err := redisRotate.Run(ctx, r.client, []string{redisKeyPrefix + provided.GUID}, append(args, previous)...).Int()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return err
+	}
+
+	switch result {
+	case 0:
+		slog.ErrorContext(ctx, "no matches")
+		return e.ErrUserNotFound
+	case -1:
+		slog.ErrorContext(ctx, "token was already rotated")
+		return e.ErrTokenAlreadyUsed
+	default:
+		return nil
+	}
+}
+
+// Script arguments of the token (see redisSet).
+func (r *redisRepository) args(token models.RefreshToken) ([]any, error) {
+	rotatedAt := ""
+	if !token.RotatedAt.IsZero() {
+		rotatedAt = token.RotatedAt.Format(time.RFC3339Nano)
+	}
+	session, err := json.Marshal(token.Session)
+	if err != nil {
+		return nil, err
+	}
+	return []any{token.TokenString, token.Previous, token.Pair, rotatedAt, string(session), r.ttl.Milliseconds()}, nil
+}
@@ -0,0 +1,76 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/auth/repository"
+	"github.com/VanLavr/auth/internal/auth/repository/repositorytest"
+	usecase "github.com/VanLavr/auth/internal/auth/service"
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/beevik/guid"
+	"github.com/stretchr/testify/assert"
+)
+
+// Run the suite against in-process redis (its clock does not run by itself, so expiry is tested separately)
+// and against a real server from REDIS_TEST_URL if it is set.
+func TestRedisRepository(t *testing.T) {
+	t.Run("miniredis", func(t *testing.T) {
+		repositorytest.Suite{
+			New: func(t *testing.T, cfg *config.Config) usecase.Repository {
+				cfg.Redis = "redis://" + miniredis.RunT(t).Addr()
+				return connectRedis(t, cfg)
+			},
+			Expiry: -1,
+		}.Run(t)
+	})
+
+	t.Run("server", func(t *testing.T) {
+		url := os.Getenv("REDIS_TEST_URL")
+		if url == "" {
+			t.Skip("set REDIS_TEST_URL to test against running redis")
+		}
+		repositorytest.Suite{
+			New: func(t *testing.T, cfg *config.Config) usecase.Repository {
+				cfg.Redis = url
+				return connectRedis(t, cfg)
+			},
+		}.Run(t)
+	})
+}
+
+// Token key expires after the refresh token lifetime, every write resets it.
+func TestRedisRepositoryTTL(t *testing.T) {
+	assert := assert.New(t)
+	server := miniredis.RunT(t)
+	repo := connectRedis(t, &config.Config{Redis: "redis://" + server.Addr(), RefreshExpTime: time.Minute})
+	defer repo.CloseConnetion(context.Background())
+
+	token := models.RefreshToken{GUID: guid.NewString(), TokenString: "stored"}
+	key := "auth:refresh_token:" + token.GUID
+	assert.Nil(repo.StoreToken(context.Background(), token))
+	assert.Equal(time.Minute, server.TTL(key))
+
+	server.FastForward(30 * time.Second)
+	assert.Nil(repo.RotateToken(context.Background(), "stored", models.RefreshToken{GUID: token.GUID, TokenString: "rotated"}))
+	assert.Equal(time.Minute, server.TTL(key))
+
+	server.FastForward(time.Minute)
+	_, err := repo.GetToken(context.Background(), token)
+	assert.Equal(e.ErrTokenNotFound, err)
+}
+
+func connectRedis(t *testing.T, cfg *config.Config) usecase.Repository {
+	cfg.Store = config.StoreRedis
+	repo := repository.New(cfg)
+	if err := repo.Connect(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
@@ -0,0 +1,302 @@
+// Package repositorytest is a conformance suite for usecase.Repository implementations.
+// Every storage backend runs it, so all of them (and the mocks the service tests rely on)
+// agree on not found errors, conditional rotation, concurrency and expiry.
+package repositorytest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	usecase "github.com/VanLavr/auth/internal/auth/service"
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/janitor"
+	"github.com/beevik/guid"
+	"github.com/stretchr/testify/assert"
+)
+
+// Suite describes a backend under test.
+type Suite struct {
+	// New returns a connected repository for the config (refresh token lifetime is set by the suite).
+	// The suite closes it.
+	New func(t *testing.T, cfg *config.Config) usecase.Repository
+	// Expiry is how long after the refresh token lifetime the backend is allowed to keep returning
+	// expired tokens, negative if the backend does not expire tokens on its own.
+	Expiry time.Duration
+}
+
+// Run() runs every contract test as a subtest.
+func (s Suite) Run(t *testing.T) {
+	t.Run("GetMissing", s.testGetMissing)
+	t.Run("StoreGet", s.testStoreGet)
+	t.Run("UpdateMissing", s.testUpdateMissing)
+	t.Run("Update", s.testUpdate)
+	t.Run("RotateMissing", s.testRotateMissing)
+	t.Run("RotateStale", s.testRotateStale)
+	t.Run("Rotate", s.testRotate)
+	t.Run("ConcurrentRotate", s.testConcurrentRotate)
+	if s.Expiry >= 0 {
+		t.Run("Expiry", s.testExpiry)
+	}
+	t.Run("Purge", s.testPurge)
+}
+
+func (s Suite) connect(t *testing.T, ttl time.Duration) usecase.Repository {
+	repo := s.New(t, &config.Config{RefreshExpTime: ttl})
+	t.Cleanup(func() {
+		if err := repo.CloseConnetion(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	return repo
+}
+
+// Store a token of a new user.
+func store(t *testing.T, repo usecase.Repository) models.RefreshToken {
+	token := models.RefreshToken{GUID: guid.NewString(), TokenString: "stored"}
+	if err := repo.StoreToken(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// Get token of the user, fails the test on error.
+func get(t *testing.T, repo usecase.Repository, id string) *models.RefreshToken {
+	token, err := repo.GetToken(context.Background(), models.RefreshToken{GUID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func (s Suite) testGetMissing(t *testing.T) {
+	repo := s.connect(t, time.Minute)
+
+	token, err := repo.GetToken(context.Background(), models.RefreshToken{GUID: guid.NewString()})
+
+	assert.Equal(t, e.ErrTokenNotFound, err)
+	assert.Nil(t, token)
+}
+
+func (s Suite) testStoreGet(t *testing.T) {
+	repo := s.connect(t, time.Minute)
+	token := store(t, repo)
+	store(t, repo)
+
+	// Token is found by guid only, provided tokenstring does not matter.
+	stored, err := repo.GetToken(context.Background(), models.RefreshToken{GUID: token.GUID, TokenString: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, token.GUID, stored.GUID)
+	assert.Equal(t, token.TokenString, stored.TokenString)
+}
+
+func (s Suite) testUpdateMissing(t *testing.T) {
+	repo := s.connect(t, time.Minute)
+
+	err := repo.UpdateToken(context.Background(), models.RefreshToken{GUID: guid.NewString(), TokenString: "won't update"})
+
+	assert.Equal(t, e.ErrUserNotFound, err)
+}
+
+func (s Suite) testUpdate(t *testing.T) {
+	assert := assert.New(t)
+	repo := s.connect(t, time.Minute)
+	token := store(t, repo)
+
+	// Rotate with grace data, then update (new login) must drop it.
+	rotated := models.RefreshToken{
+		GUID:        token.GUID,
+		TokenString: "rotated",
+		Previous:    token.TokenString,
+		Pair:        "pair",
+		RotatedAt:   time.Now(),
+	}
+	assert.Nil(repo.RotateToken(context.Background(), token.TokenString, rotated))
+	assert.Nil(repo.UpdateToken(context.Background(), models.RefreshToken{GUID: token.GUID, TokenString: "updated"}))
+
+	stored := get(t, repo, token.GUID)
+	assert.Equal("updated", stored.TokenString)
+	assert.Empty(stored.Previous)
+	assert.Empty(stored.Pair)
+}
+
+func (s Suite) testRotateMissing(t *testing.T) {
+	repo := s.connect(t, time.Minute)
+	id := guid.NewString()
+
+	err := repo.RotateToken(context.Background(), "stored", models.RefreshToken{GUID: id, TokenString: "won't rotate"})
+
+	assert.Equal(t, e.ErrUserNotFound, err)
+}
+
+func (s Suite) testRotateStale(t *testing.T) {
+	assert := assert.New(t)
+	repo := s.connect(t, time.Minute)
+	token := store(t, repo)
+
+	err := repo.RotateToken(context.Background(), "stale", models.RefreshToken{GUID: token.GUID, TokenString: "won't rotate"})
+
+	assert.Equal(e.ErrTokenAlreadyUsed, err)
+	assert.Equal(token.TokenString, get(t, repo, token.GUID).TokenString)
+}
+
+func (s Suite) testRotate(t *testing.T) {
+	assert := assert.New(t)
+	repo := s.connect(t, time.Minute)
+	token := store(t, repo)
+	rotatedAt := time.Now().Truncate(time.Millisecond)
+
+	rotated := models.RefreshToken{
+		GUID:        token.GUID,
+		TokenString: "rotated",
+		Previous:    token.TokenString,
+		Pair:        "pair",
+		RotatedAt:   rotatedAt,
+		Session: models.Session{
+			CreatedAt:  rotatedAt.Add(-time.Hour),
+			LastUsedAt: rotatedAt,
+			Rotations:  3,
+			ClientIP:   "203.0.113.7",
+			UserAgent:  "test",
+			ClientID:   "orders",
+		},
+	}
+	assert.Nil(repo.RotateToken(context.Background(), token.TokenString, rotated))
+
+	stored := get(t, repo, token.GUID)
+	assert.Equal(rotated.TokenString, stored.TokenString)
+	assert.Equal(rotated.Previous, stored.Previous)
+	assert.Equal(rotated.Pair, stored.Pair)
+	assert.True(rotatedAt.Equal(stored.RotatedAt), "rotated at %v, got %v", rotatedAt, stored.RotatedAt)
+
+	// Session is stored along (times are compared as instants, backends may change location).
+	session := stored.Session
+	assert.True(rotated.Session.CreatedAt.Equal(session.CreatedAt), "created at %v, got %v", rotated.Session.CreatedAt, session.CreatedAt)
+	assert.True(rotated.Session.LastUsedAt.Equal(session.LastUsedAt), "last used at %v, got %v", rotated.Session.LastUsedAt, session.LastUsedAt)
+	session.CreatedAt, session.LastUsedAt = rotated.Session.CreatedAt, rotated.Session.LastUsedAt
+	assert.Equal(rotated.Session, session)
+
+	// The same rotation again is stale.
+	assert.Equal(e.ErrTokenAlreadyUsed, repo.RotateToken(context.Background(), token.TokenString, rotated))
+}
+
+// Exactly one of concurrent rotations of the same token wins.
+func (s Suite) testConcurrentRotate(t *testing.T) {
+	assert := assert.New(t)
+	repo := s.connect(t, time.Minute)
+	token := store(t, repo)
+
+	const parallel = 16
+	var (
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+		errs  = make(chan error, parallel)
+	)
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs <- repo.RotateToken(context.Background(), token.TokenString, models.RefreshToken{
+				GUID:        token.GUID,
+				TokenString: guid.NewString(),
+			})
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.Equal(e.ErrTokenAlreadyUsed, err)
+	}
+	assert.Equal(1, succeeded)
+}
+
+// Expired token is not returned and can not be rotated.
+func (s Suite) testExpiry(t *testing.T) {
+	assert := assert.New(t)
+	const ttl = time.Second
+	repo := s.connect(t, ttl)
+	token := store(t, repo)
+
+	assert.Eventually(func() bool {
+		_, err := repo.GetToken(context.Background(), token)
+		return err == e.ErrTokenNotFound
+	}, ttl+s.Expiry+time.Second, 50*time.Millisecond)
+	assert.Equal(e.ErrUserNotFound, repo.RotateToken(context.Background(), token.TokenString, token))
+}
+
+// Expired tokens and old revoked hashes are purged in batches, the rest is kept.
+func (s Suite) testPurge(t *testing.T) {
+	assert := assert.New(t)
+	repo := s.connect(t, time.Minute)
+	purger, ok := repo.(janitor.Purger)
+	if !ok {
+		t.Skip("backend removes dead tokens by itself")
+	}
+	ctx := context.Background()
+	now := time.Now()
+
+	// Nothing is expired yet.
+	expired := []models.RefreshToken{store(t, repo), store(t, repo), store(t, repo)}
+	n, err := purger.PurgeExpired(ctx, now.Add(-time.Hour), 10)
+	assert.Nil(err)
+	assert.Zero(n)
+
+	// Tokens are expired in two minutes (tokens of other tests may be purged along).
+	purged := 0
+	for {
+		n, err := purger.PurgeExpired(ctx, now.Add(2*time.Minute), 1)
+		if !assert.Nil(err) || n == 0 {
+			break
+		}
+		purged += n
+	}
+	assert.GreaterOrEqual(purged, len(expired))
+	for _, token := range expired {
+		_, err := repo.GetToken(ctx, token)
+		assert.Equal(e.ErrTokenNotFound, err)
+	}
+
+	// Revoked hash is dropped only after the retention.
+	old, recent := store(t, repo), store(t, repo)
+	for _, token := range []models.RefreshToken{old, recent} {
+		rotatedAt := now
+		if token.GUID == old.GUID {
+			rotatedAt = now.Add(-2 * time.Hour)
+		}
+		assert.Nil(repo.RotateToken(ctx, token.TokenString, models.RefreshToken{
+			GUID:        token.GUID,
+			TokenString: "rotated",
+			Previous:    token.TokenString,
+			Pair:        "pair",
+			RotatedAt:   rotatedAt,
+		}))
+	}
+	for {
+		n, err := purger.PurgeRotated(ctx, now.Add(-time.Hour), 1)
+		if !assert.Nil(err) || n == 0 {
+			break
+		}
+	}
+
+	stored := get(t, repo, old.GUID)
+	assert.Equal("rotated", stored.TokenString)
+	assert.Empty(stored.Previous)
+	assert.Empty(stored.Pair)
+	stored = get(t, repo, recent.GUID)
+	assert.Equal(recent.TokenString, stored.Previous)
+	assert.Equal("pair", stored.Pair)
+}
@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	usecase "github.com/VanLavr/auth/internal/auth/service"
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	"github.com/VanLavr/auth/internal/pkg/janitor"
+	"github.com/VanLavr/auth/internal/pkg/metrics"
+	"github.com/VanLavr/auth/internal/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Delays between connection attempts, doubled after every failed attempt.
+const (
+	connectBackoff    = 100 * time.Millisecond
+	connectMaxBackoff = 5 * time.Second
+)
+
+// Pinger is implemented by every repository, Ping() checks that the store is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Resilient wraps a repository: it connects with retries until the startup deadline,
+// bounds every operation with the operation timeout, traces it, records its duration and tracks whether
+// the store is reachable (drivers reconnect by themselves, the connection is pinged every ping interval).
+type Resilient struct {
+	usecase.Repository
+	connectTimeout time.Duration
+	timeout        time.Duration
+	pingInterval   time.Duration
+	store          string
+
+	ready atomic.Bool
+	mu    sync.Mutex
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func NewResilient(repo usecase.Repository, cfg *config.Config) *Resilient {
+	store := cfg.Store
+	if store == "" {
+		store = config.StoreMongo
+	}
+	return &Resilient{
+		Repository:     repo,
+		connectTimeout: cfg.ConnectTimeout,
+		timeout:        cfg.OperationTimeout,
+		pingInterval:   cfg.PingInterval,
+		store:          store,
+	}
+}
+
+// Connect until it succeeds or the startup deadline is exceeded, waiting longer after every attempt.
+// Start watching the connection.
+func (r *Resilient) Connect(ctx context.Context, cfg *config.Config) error {
+	slog.DebugContext(ctx, "connect resilient repo called")
+	if r.connectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.connectTimeout)
+		defer cancel()
+	}
+
+	// Connect until it succeeds or the startup deadline is exceeded, waiting longer after every attempt.
+	backoff := connectBackoff
+	for attempt := 1; ; attempt++ {
+		err := r.Repository.Connect(ctx, cfg)
+		if err == nil {
+			break
+		}
+
+		slog.WarnContext(ctx, "store is unreachable", "attempt", attempt, "retry_in", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("store is unreachable after %d attempts: %w", attempt, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, connectMaxBackoff)
+	}
+	r.ready.Store(true)
+	slog.InfoContext(ctx, "store is connected")
+
+	// Start watching the connection.
+	if r.pingInterval > 0 {
+		r.mu.Lock()
+		r.stop = make(chan struct{})
+		r.done = make(chan struct{})
+		go r.watch(r.pingInterval, r.stop, r.done)
+		r.mu.Unlock()
+	}
+
+	return nil
+}
+
+// CloseConnetion() stops watching the connection and closes it.
+func (r *Resilient) CloseConnetion(ctx context.Context) error {
+	slog.DebugContext(ctx, "closeconnection resilient repo called")
+	r.mu.Lock()
+	stop, done := r.stop, r.done
+	r.stop, r.done = nil, nil
+	r.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	r.ready.Store(false)
+	return r.Repository.CloseConnetion(ctx)
+}
+
+// Ping() checks the store within the operation timeout and updates readiness.
+func (r *Resilient) Ping(ctx context.Context) error {
+	pinger, ok := r.Repository.(Pinger)
+	if !ok {
+		return nil
+	}
+
+	err := r.do(ctx, "ping", pinger.Ping)
+
+	switch wasReady := r.ready.Swap(err == nil); {
+	case err != nil && wasReady:
+		slog.ErrorContext(ctx, "store became unreachable", "error", err)
+	case err == nil && !wasReady:
+		slog.InfoContext(ctx, "store is reachable again")
+	}
+	return err
+}
+
+// Ping the store every interval until stopped.
+func (r *Resilient) watch(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			r.Ping(context.Background())
+		}
+	}
+}
+
+func (r *Resilient) GetToken(ctx context.Context, provided models.RefreshToken) (*models.RefreshToken, error) {
+	var token *models.RefreshToken
+	err := r.do(ctx, "get_token", func(ctx context.Context) (err error) {
+		token, err = r.Repository.GetToken(ctx, provided)
+		return err
+	})
+	return token, err
+}
+
+func (r *Resilient) StoreToken(ctx context.Context, token models.RefreshToken) error {
+	return r.do(ctx, "store_token", func(ctx context.Context) error {
+		return r.Repository.StoreToken(ctx, token)
+	})
+}
+
+func (r *Resilient) UpdateToken(ctx context.Context, provided models.RefreshToken) error {
+	return r.do(ctx, "update_token", func(ctx context.Context) error {
+		return r.Repository.UpdateToken(ctx, provided)
+	})
+}
+
+func (r *Resilient) RotateToken(ctx context.Context, previous string, provided models.RefreshToken) error {
+	return r.do(ctx, "rotate_token", func(ctx context.Context) error {
+		return r.Repository.RotateToken(ctx, previous, provided)
+	})
+}
+
+// Purger() returns the purger of the store (if it keeps dead tokens) whose purges are bounded, traced
+// and measured like other operations.
+func (r *Resilient) Purger() (janitor.Purger, bool) {
+	inner, ok := r.Repository.(janitor.Purger)
+	if !ok {
+		return nil, false
+	}
+	return resilientPurger{r: r, inner: inner}, true
+}
+
+type resilientPurger struct {
+	r     *Resilient
+	inner janitor.Purger
+}
+
+func (p resilientPurger) PurgeExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	var n int
+	err := p.r.do(ctx, "purge_expired", func(ctx context.Context) (err error) {
+		n, err = p.inner.PurgeExpired(ctx, before, limit)
+		return err
+	})
+	return n, err
+}
+
+func (p resilientPurger) PurgeRotated(ctx context.Context, before time.Time, limit int) (int, error) {
+	var n int
+	err := p.r.do(ctx, "purge_rotated", func(ctx context.Context) (err error) {
+		n, err = p.inner.PurgeRotated(ctx, before, limit)
+		return err
+	})
+	return n, err
+}
+
+// Run the operation within the operation timeout in its own span and record its duration.
+func (r *Resilient) do(ctx context.Context, operation string, fn func(context.Context) error) error {
+	ctx, span := tracing.Start(ctx, "repository."+operation, trace.WithAttributes(attribute.String("store", r.store)))
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+
+	start := time.Now()
+	err := fn(ctx)
+	metrics.ObserveRepository(operation, start, err)
+	tracing.End(span, err)
+	return err
+}
+
+// Context of a single operation (not bounded if operation timeout is 0).
+func (r *Resilient) bound(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
@@ -0,0 +1,169 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/auth/repository"
+	usecase "github.com/VanLavr/auth/internal/auth/service"
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var errUnreachable = errors.New("unreachable")
+
+// Memory repository that is unreachable for the first failures connects and while down is set.
+type flakyRepository struct {
+	usecase.Repository
+	failures atomic.Int32
+	attempts atomic.Int32
+	down     atomic.Bool
+}
+
+func newFlakyRepository(failures int32) *flakyRepository {
+	f := &flakyRepository{Repository: repository.New(&config.Config{Store: config.StoreMemory})}
+	f.failures.Store(failures)
+	return f
+}
+
+func (f *flakyRepository) Connect(ctx context.Context, cfg *config.Config) error {
+	if f.attempts.Add(1) <= f.failures.Load() {
+		return errUnreachable
+	}
+	return f.Repository.Connect(ctx, cfg)
+}
+
+func (f *flakyRepository) Ping(ctx context.Context) error {
+	if f.down.Load() {
+		return errUnreachable
+	}
+	return nil
+}
+
+// Blocks until the operation is cancelled.
+func (f *flakyRepository) GetToken(ctx context.Context, provided models.RefreshToken) (*models.RefreshToken, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// Testcases:
+// 1) connect is retried until the store is reachable
+// 2) connect fails when the store is not reachable before the startup deadline
+func TestResilientConnect(t *testing.T) {
+	assert := assert.New(t)
+	cfg := &config.Config{ConnectTimeout: 5 * time.Second}
+
+	// 1
+	flaky := newFlakyRepository(2)
+	repo := repository.NewResilient(flaky, cfg)
+	assert.Nil(repo.Connect(context.Background(), cfg))
+	assert.EqualValues(3, flaky.attempts.Load())
+	assert.Nil(repo.Ping(context.Background()))
+	assert.Nil(repo.CloseConnetion(context.Background()))
+
+	// 2
+	cfg.ConnectTimeout = 500 * time.Millisecond
+	flaky = newFlakyRepository(100)
+	repo = repository.NewResilient(flaky, cfg)
+	start := time.Now()
+	err := repo.Connect(context.Background(), cfg)
+	assert.ErrorIs(err, errUnreachable)
+	assert.Less(time.Since(start), 2*time.Second)
+	assert.Greater(flaky.attempts.Load(), int32(1))
+}
+
+// Testcases:
+// 1) operation is cancelled after the operation timeout
+// 2) ping follows the connection state
+func TestResilientOperations(t *testing.T) {
+	assert := assert.New(t)
+	cfg := &config.Config{OperationTimeout: 50 * time.Millisecond, PingInterval: 10 * time.Millisecond}
+	flaky := newFlakyRepository(0)
+	repo := repository.NewResilient(flaky, cfg)
+	assert.Nil(repo.Connect(context.Background(), cfg))
+	defer repo.CloseConnetion(context.Background())
+
+	// 1
+	_, err := repo.GetToken(context.Background(), models.RefreshToken{GUID: "id"})
+	assert.ErrorIs(err, context.DeadlineExceeded)
+
+	// 2
+	flaky.down.Store(true)
+	assert.ErrorIs(repo.Ping(context.Background()), errUnreachable)
+	flaky.down.Store(false)
+	assert.Nil(repo.Ping(context.Background()))
+}
+
+// Store keeping dead tokens whose purges block until they are cancelled.
+type hungPurger struct {
+	usecase.Repository
+}
+
+func (h hungPurger) PurgeExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func (h hungPurger) PurgeRotated(ctx context.Context, before time.Time, limit int) (int, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+// Testcases:
+// 1) there is no purger if the store removes dead tokens by itself
+// 2) purges of the store are cancelled after the operation timeout
+func TestResilientPurger(t *testing.T) {
+	assert := assert.New(t)
+	cfg := &config.Config{Store: config.StoreMemory, OperationTimeout: 50 * time.Millisecond}
+
+	// 1
+	_, ok := repository.NewResilient(repository.New(cfg), cfg).Purger()
+	assert.False(ok)
+
+	// 2
+	purger, ok := repository.NewResilient(hungPurger{repository.New(cfg)}, cfg).Purger()
+	if !assert.True(ok) {
+		return
+	}
+	_, err := purger.PurgeExpired(context.Background(), time.Now(), 10)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	_, err = purger.PurgeRotated(context.Background(), time.Now(), 10)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+}
+
+// Operations are traced as children of the caller span.
+func TestResilientTracing(t *testing.T) {
+	assert := assert.New(t)
+	exporter, restore := tracing.SetupInMemory()
+	defer restore()
+	cfg := &config.Config{Store: config.StoreMemory}
+	repo := repository.NewResilient(repository.New(cfg), cfg)
+	assert.Nil(repo.Connect(context.Background(), cfg))
+	defer repo.CloseConnetion(context.Background())
+
+	ctx, parent := tracing.Start(context.Background(), "caller")
+	assert.Nil(repo.StoreToken(ctx, models.RefreshToken{GUID: "id", TokenString: "stored"}))
+	_, err := repo.GetToken(ctx, models.RefreshToken{GUID: "missing"})
+	assert.Equal(e.ErrTokenNotFound, err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if !assert.Len(spans, 3) {
+		return
+	}
+	assert.Equal("repository.store_token", spans[0].Name)
+	assert.Equal("repository.get_token", spans[1].Name)
+	assert.Equal(codes.Error, spans[1].Status.Code)
+	for _, span := range spans[:2] {
+		assert.Equal(parent.SpanContext().SpanID(), span.Parent.SpanID())
+		assert.Contains(span.Attributes, attribute.String("store", config.StoreMemory))
+	}
+}
@@ -0,0 +1,114 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	usecase "github.com/VanLavr/auth/internal/auth/service"
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/beevik/guid"
+	"github.com/stretchr/testify/assert"
+)
+
+// Exactly one of parallel refreshes with the same token wins, others see it as already used.
+// The pair is issued and refreshed within the same second, so rotated tokens differ only by jti.
+func TestConcurrentRefresh(t *testing.T) {
+	backends := map[string]func(*testing.T, *config.Config) usecase.Repository{
+		"mongo": func(t *testing.T, cfg *config.Config) usecase.Repository {
+			cfg.Mongo = startMongo(t)
+			return connectMongo(t, cfg)
+		},
+		"postgres": func(t *testing.T, cfg *config.Config) usecase.Repository {
+			cfg.Postgres = startPostgres(t)
+			return connectPostgres(t, cfg)
+		},
+		"bolt": func(t *testing.T, cfg *config.Config) usecase.Repository {
+			cfg.BoltFile = filepath.Join(t.TempDir(), "tokens.db")
+			return connectBolt(t, cfg)
+		},
+		"redis": func(t *testing.T, cfg *config.Config) usecase.Repository {
+			cfg.Redis = "redis://" + miniredis.RunT(t).Addr()
+			return connectRedis(t, cfg)
+		},
+		"memory": connectMemory,
+	}
+
+	for name, connect := range backends {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			cfg := &config.Config{
+				Secret:         "concurrent",
+				AccessExpTime:  10 * time.Second,
+				RefreshExpTime: 20 * time.Second,
+			}
+			repo := connect(t, cfg)
+			defer repo.CloseConnetion(context.Background())
+			sink := audit.NewMemorySink()
+			auditor := audit.New(sink)
+			service, err := usecase.New(repo, cfg, auditor)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Start at the beginning of a second.
+			time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+			id := guid.NewString()
+			pair, err := service.GetNewTokenPair(context.Background(), id, models.Confirmation{}, models.ClientInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			access := pair["access_token"].(string)
+			refresh := pair["refresh_token"].(models.RefreshToken)
+
+			const parallel = 16
+			var (
+				wg    sync.WaitGroup
+				start = make(chan struct{})
+				errs  = make(chan error, parallel)
+			)
+			for i := 0; i < parallel; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					_, err := service.RefreshTokenPair(context.Background(), refresh, access, models.Confirmation{}, models.ClientInfo{})
+					errs <- err
+				}()
+			}
+			close(start)
+			wg.Wait()
+			close(errs)
+
+			succeeded := 0
+			for err := range errs {
+				if err == nil {
+					succeeded++
+					continue
+				}
+				assert.Equal(e.ErrTokenAlreadyUsed, err)
+			}
+			assert.Equal(1, succeeded)
+
+			// Every attempt is audited in a valid chain: issuance, the refresh and detected reuses.
+			assert.Nil(auditor.Flush(context.Background()))
+			head, err := audit.Verify(context.Background(), sink)
+			assert.Nil(err)
+			assert.EqualValues(1+parallel, head.Seq)
+			entries, err := auditor.Query(context.Background(), audit.Query{GUID: id})
+			assert.Nil(err)
+			events := map[string]int{}
+			for _, entry := range entries {
+				events[entry.Event]++
+			}
+			assert.Equal(map[string]int{audit.EventTokenIssued: 1, audit.EventTokenRefreshed: 1, audit.EventTokenReuse: parallel - 1}, events)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	"github.com/VanLavr/auth/internal/pkg/tracing"
+)
+
+// Record the event of the user caused by the client (the actor is the authenticated client only).
+func (a *authUsecase) record(ctx context.Context, event, guid string, client models.ClientInfo, details map[string]string) {
+	a.auditor.Record(ctx, audit.Entry{
+		Event:    event,
+		GUID:     guid,
+		Actor:    client.ClientID,
+		ClientIP: client.IP,
+		Details:  details,
+	})
+}
+
+// Audit the query of the admin.
+// Return the selected entries.
+func (a *authUsecase) QueryAudit(ctx context.Context, q audit.Query, admin models.ClientInfo) (entries []audit.Entry, err error) {
+	slog.DebugContext(ctx, "queryaudit service called")
+	ctx, span := tracing.Start(ctx, "usecase.QueryAudit")
+	defer func() { tracing.End(span, err) }()
+
+	// Audit the query of the admin.
+	details := map[string]string{}
+	if !q.From.IsZero() {
+		details["from"] = q.From.Format(time.RFC3339)
+	}
+	if !q.To.IsZero() {
+		details["to"] = q.To.Format(time.RFC3339)
+	}
+	a.record(ctx, audit.EventAuditQueried, q.GUID, admin, details)
+
+	// Return the selected entries.
+	entries, err = a.auditor.Query(ctx, q)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, err
+	}
+	return entries, nil
+}
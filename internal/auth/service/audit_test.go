@@ -0,0 +1,58 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	auth_repo_mocks "github.com/VanLavr/auth/internal/mocks/auht/repo"
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Testcases:
+// 1) new pair replacing a live one revokes it
+// 2) session view of a client is audited
+// 3) audit query of an admin is audited and returns entries of the user
+func TestAudit(t *testing.T) {
+	const id = "67a23ff3-20be-4420-9274-d16f2833d595"
+	assert := assert.New(t)
+	client := models.ClientInfo{IP: "203.0.113.7", ClientID: "support"}
+	sink := audit.NewMemorySink()
+	auditor := audit.New(sink)
+	repo := &auth_repo_mocks.Repository{}
+	service := newService(t, repo, &config.Config{Secret: "audit", AccessExpTime: time.Minute, RefreshExpTime: time.Minute}, auditor)
+
+	// 1
+	repo.On("GetToken", context.Background(), models.RefreshToken{GUID: id}).Return(&models.RefreshToken{GUID: id}, nil)
+	repo.On("UpdateToken", context.Background(), mock.AnythingOfType("models.RefreshToken")).Return(nil).Once()
+	_, err := service.GetNewTokenPair(context.Background(), id, models.Confirmation{}, client)
+	assert.Nil(err)
+
+	// 2
+	_, err = service.GetSession(context.Background(), id, client)
+	assert.Nil(err)
+
+	// 3
+	entries, err := service.QueryAudit(context.Background(), audit.Query{GUID: id}, models.ClientInfo{ClientID: "admin"})
+	assert.Nil(err)
+
+	var events []string
+	for _, entry := range entries {
+		events = append(events, entry.Event)
+		assert.Equal(id, entry.GUID)
+	}
+	assert.Equal([]string{audit.EventTokenRevoked, audit.EventTokenIssued, audit.EventSessionViewed, audit.EventAuditQueried}, events)
+	assert.Equal("replaced", entries[0].Details["reason"])
+	assert.Equal(client.ClientID, entries[2].Actor)
+	assert.Equal(client.IP, entries[2].ClientIP)
+	assert.Equal("admin", entries[3].Actor)
+
+	assert.Nil(auditor.Flush(context.Background()))
+	_, err = audit.Verify(context.Background(), sink)
+	assert.Nil(err)
+	repo.AssertExpectations(t)
+}
@@ -5,12 +5,17 @@ package usecase
 import (
 	"context"
 	"log/slog"
+	"strconv"
+	"time"
 
 	"github.com/VanLavr/auth/internal/auth/delivery"
 	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
 	"github.com/VanLavr/auth/internal/pkg/config"
 	e "github.com/VanLavr/auth/internal/pkg/errors"
 	"github.com/VanLavr/auth/internal/pkg/hasher"
+	"github.com/VanLavr/auth/internal/pkg/metrics"
+	"github.com/VanLavr/auth/internal/pkg/tracing"
 
 	"github.com/beevik/guid"
 )
@@ -18,6 +23,9 @@ import (
 type authUsecase struct {
 	tokenManager *tokenManager
 	repository   Repository
+	clients      map[string]config.Client
+	grace        *graceCache
+	auditor      *audit.Logger
 }
 
 // Repository for working with MongoDB
@@ -31,47 +39,64 @@ type Repository interface {
 	GetToken(context.Context, models.RefreshToken) (*models.RefreshToken, error)
 	// UpdateToken() is used to mark tokens as used
 	UpdateToken(context.Context, models.RefreshToken) error
+	// RotateToken() replaces stored hash only if it is still equal to the provided previous hash,
+	// so only one of concurrent rotations succeeds. Others get ErrTokenAlreadyUsed.
+	RotateToken(context.Context, string, models.RefreshToken) error
 }
 
-func New(r Repository, cfg *config.Config) delivery.Usecase {
+// New() fails if token formats can not be created with the configured keys.
+func New(r Repository, cfg *config.Config, auditor *audit.Logger) (delivery.Usecase, error) {
 	slog.Debug("new service called")
-	tokenManager := newTokenManager(cfg)
-	return &authUsecase{repository: r, tokenManager: tokenManager}
+	tokenManager, err := newTokenManager(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &authUsecase{repository: r, tokenManager: tokenManager, clients: cfg.Clients, grace: newGraceCache(cfg), auditor: auditor}, nil
+}
+
+// CheckKeys() checks that tokens can be signed and verified with the configured keys.
+func (a *authUsecase) CheckKeys(ctx context.Context) error {
+	return a.tokenManager.formats.Check(ctx)
+}
+
+// RefreshTokenPair() rotates the provided refresh token in its own span and counts the attempt by its result.
+func (a *authUsecase) RefreshTokenPair(ctx context.Context, provided models.RefreshToken, access string, cnf models.Confirmation, client models.ClientInfo) (map[string]any, error) {
+	ctx, span := tracing.Start(ctx, "usecase.RefreshTokenPair")
+	tokens, err := a.refreshTokenPair(ctx, provided, access, cnf, client)
+	tracing.End(span, err)
+	metrics.ObserveRefresh(err)
+	return tokens, err
 }
 
 // Check if provided token exists.
-// Compare provided token with stored hash.
 // Validate refresh token jwt.
 // Check if this token owned by provided user and check if this token was already used (refresh tokenstrings are not the same).
+// A used token is still accepted within the grace window and gets the pair it was rotated to.
 // Validate access and refresh token coherence.
-// Generate new token pair.
-// Hash refresh token.
-// Update token in mongo -> it will replace used tokenstring with new tokenstring.
+// Check that bound refresh token is presented with the same key.
+// Generate new token pair bound to the presented key.
+// Hash refresh token, carry the session over.
+// Keep the new pair for retries if grace window is configured.
+// Rotate token in mongo -> it will replace used tokenstring with new tokenstring only if it was not replaced by a concurrent refresh.
 // Return the pair.
-func (a *authUsecase) RefreshTokenPair(ctx context.Context, provided models.RefreshToken, access string) (map[string]any, error) {
-	slog.Debug("refreshtokenpair service called")
+func (a *authUsecase) refreshTokenPair(ctx context.Context, provided models.RefreshToken, access string, cnf models.Confirmation, client models.ClientInfo) (map[string]any, error) {
+	slog.DebugContext(ctx, "refreshtokenpair service called")
 	token, err := a.repository.GetToken(ctx, provided)
 	if err != nil {
-		slog.Error(err.Error())
+		slog.ErrorContext(ctx, err.Error())
 		return nil, err
 	}
 
 	// Check if provided token exists.
 	if token == nil {
-		slog.Error("tonken not found")
-		return nil, e.ErrInvalidToken
-	}
-
-	// Compare provided token with stored hash.
-	if !hasher.Hshr.Validate(token.TokenString, provided.TokenString) {
-		slog.Error("token hash malformed")
+		slog.ErrorContext(ctx, "tonken not found")
 		return nil, e.ErrInvalidToken
 	}
 
 	// Validate refresh token jwt. (expired or not)
-	guid, valid := a.tokenManager.ValidateRefreshToken(provided.TokenString)
+	guid, valid := a.tokenManager.ValidateRefreshToken(ctx, provided.TokenString)
 	if !valid {
-		slog.Error("token jwt malformed")
+		slog.ErrorContext(ctx, "token jwt malformed")
 		return nil, e.ErrInvalidToken
 	}
 
@@ -81,36 +106,66 @@ func (a *authUsecase) RefreshTokenPair(ctx context.Context, provided models.Refr
 	// to inspect if provided token was updated or not. This comparison allow us to check if we can use provided token for refreshing
 	// or not even if token was not expired, but was updated (used). So if it not expired, but updated
 	// it is an already used token, so we can not use it anymore.
-	if token.GUID != guid || !hasher.Hshr.Validate(token.TokenString, provided.TokenString) {
-		slog.Error("token is used")
+	if token.GUID != guid {
+		slog.ErrorContext(ctx, "token owned by another user")
 		return nil, e.ErrInvalidToken
 	}
+	if !hasher.Hshr.Validate(token.TokenString, provided.TokenString) {
+		return a.retryRotation(ctx, token, provided, access, cnf, client)
+	}
 
-	// Validate access and refresh token coherence.
-	if !a.tokenManager.ValidateTokensCoherence(access, provided.TokenString) {
-		slog.Error("tokens coherence malformed")
-		return nil, e.ErrInvalidToken
+	if err := a.checkSession(ctx, provided, access, cnf); err != nil {
+		return nil, err
 	}
 
-	// Generate new token pair.
-	tokens := a.tokenManager.GenerateTokenPair(provided.GUID)
+	// Generate new token pair bound to the presented key.
+	tokens, err := a.tokenManager.GenerateBoundTokenPair(ctx, provided.GUID, cnf)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, e.ErrInternal
+	}
 	refresh := models.RefreshToken{
 		GUID:        provided.GUID,
 		TokenString: tokens["refresh_token"],
 	}
 
-	// Hash refresh token.
+	// Hash refresh token, carry the session over.
 	hash := hasher.Hshr.Encrypt(refresh.TokenString)
 	toStoreToken := models.RefreshToken{
 		GUID:        provided.GUID,
 		TokenString: hash,
+		Session:     rotateSession(token.Session, client),
+	}
+
+	// Keep the new pair for retries if grace window is configured.
+	if a.grace.Enabled() {
+		pair, err := a.grace.Seal(provided.GUID, token.TokenString, tokens)
+		if err != nil {
+			slog.ErrorContext(ctx, err.Error())
+			return nil, e.ErrInternal
+		}
+		toStoreToken.Previous = token.TokenString
+		toStoreToken.Pair = pair
+		toStoreToken.RotatedAt = time.Now()
 	}
 
-	// Update token in mongo -> it will replace used tokenstring with new tokenstring.
-	if err := a.repository.UpdateToken(ctx, toStoreToken); err != nil {
-		slog.Error(err.Error())
+	// Rotate token in mongo -> it will replace used tokenstring with new tokenstring
+	// only if it was not replaced by a concurrent refresh.
+	if err := a.repository.RotateToken(ctx, token.TokenString, toStoreToken); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		// A concurrent refresh with the same token could have won, retry gets its pair.
+		if err == e.ErrTokenAlreadyUsed && a.grace.Enabled() {
+			if token, err = a.repository.GetToken(ctx, provided); err == nil {
+				return a.retryRotation(ctx, token, provided, access, cnf, client)
+			}
+		}
+		if err == e.ErrTokenAlreadyUsed {
+			a.record(ctx, audit.EventTokenReuse, provided.GUID, client, nil)
+		}
 		return nil, err
 	}
+	logSession(ctx, "session refreshed", provided.GUID, toStoreToken.Session)
+	a.record(ctx, audit.EventTokenRefreshed, provided.GUID, client, map[string]string{"rotations": strconv.Itoa(toStoreToken.Session.Rotations)})
 
 	// Return the pair.
 	return map[string]any{
@@ -119,23 +174,81 @@ func (a *authUsecase) RefreshTokenPair(ctx context.Context, provided models.Refr
 	}, nil
 }
 
+// Validate access and refresh token coherence.
+// Check that bound refresh token is presented with the same key.
+func (a *authUsecase) checkSession(ctx context.Context, provided models.RefreshToken, access string, cnf models.Confirmation) error {
+	// Validate access and refresh token coherence.
+	if !a.tokenManager.ValidateTokensCoherence(ctx, access, provided.TokenString) {
+		slog.ErrorContext(ctx, "tokens coherence malformed")
+		return e.ErrInvalidToken
+	}
+
+	// Check that bound refresh token is presented with the same key.
+	if !a.tokenManager.Confirmation(ctx, provided.TokenString).PresentedWith(cnf) {
+		slog.ErrorContext(ctx, "token is bound to another key")
+		return e.ErrInvalidToken
+	}
+
+	return nil
+}
+
+// Check if provided token is the one that was just rotated (within grace window), otherwise it is a replay.
+// Check that retry comes from the same session.
+// Return the pair the token was rotated to.
+func (a *authUsecase) retryRotation(ctx context.Context, token *models.RefreshToken, provided models.RefreshToken, access string, cnf models.Confirmation, client models.ClientInfo) (map[string]any, error) {
+	// Check if provided token is the one that was just rotated (within grace window), otherwise it is a replay.
+	pair, ok := a.grace.Open(ctx, token, provided.TokenString)
+	if !ok {
+		slog.ErrorContext(ctx, "token is used")
+		a.record(ctx, audit.EventTokenReuse, provided.GUID, client, nil)
+		return nil, e.ErrTokenAlreadyUsed
+	}
+
+	// Check that retry comes from the same session.
+	if err := a.checkSession(ctx, provided, access, cnf); err != nil {
+		return nil, err
+	}
+
+	// Return the pair the token was rotated to.
+	slog.InfoContext(ctx, "rotated token retried within grace window")
+	a.record(ctx, audit.EventTokenRefreshed, provided.GUID, client, map[string]string{"retry": "true"})
+	return map[string]any{
+		"access_token": pair["access_token"],
+		"refresh_token": models.RefreshToken{
+			GUID:        provided.GUID,
+			TokenString: pair["refresh_token"],
+		},
+	}, nil
+}
+
 // Validate GUID.
-// Generate new token pair.
+// Start a new session before the pair is issued, so its tokens are never older than the session.
+// Generate new token pair (bound to the client key if it was provided).
 // Check if there is old refresh token
-// Hash refresh token
+// Hash refresh token.
 // Save hash of refresh token in mongo if there was no old token.
-// Update hash of refresh token if there was an old token.
+// Update hash of refresh token if there was an old token (the old token is revoked).
 // Return token pair.
-func (a *authUsecase) GetNewTokenPair(ctx context.Context, id string) (map[string]any, error) {
-	slog.Debug("getnewtokenpair service called")
+func (a *authUsecase) GetNewTokenPair(ctx context.Context, id string, cnf models.Confirmation, client models.ClientInfo) (pair map[string]any, err error) {
+	slog.DebugContext(ctx, "getnewtokenpair service called")
+	ctx, span := tracing.Start(ctx, "usecase.GetNewTokenPair")
+	defer func() { tracing.End(span, err) }()
+
 	// Validate GUID.
-	if !a.validateID(id) {
-		slog.Error(e.ErrInvalidGUID.Error())
+	if !a.validateID(ctx, id) {
+		slog.ErrorContext(ctx, e.ErrInvalidGUID.Error())
 		return nil, e.ErrInvalidGUID
 	}
 
-	// Generate new token pair.
-	tokens := a.tokenManager.GenerateTokenPair(id)
+	// Start a new session before the pair is issued, so its tokens are never older than the session.
+	session := newSession(client)
+
+	// Generate new token pair (bound to the client key if it was provided).
+	tokens, err := a.tokenManager.GenerateBoundTokenPair(ctx, id, cnf)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, e.ErrInternal
+	}
 	refresh := models.RefreshToken{
 		GUID:        id,
 		TokenString: tokens["refresh_token"],
@@ -144,24 +257,28 @@ func (a *authUsecase) GetNewTokenPair(ctx context.Context, id string) (map[strin
 	// Check if there is old refresh token
 	token, err := a.repository.GetToken(ctx, models.RefreshToken{GUID: id})
 	if err != nil && err != e.ErrTokenNotFound {
-		slog.Error(err.Error())
+		slog.ErrorContext(ctx, err.Error())
 		return nil, err
 	}
 
-	// Hash refresh token
+	// Hash refresh token.
 	hash := hasher.Hshr.Encrypt(refresh.TokenString)
 	toStoreToken := models.RefreshToken{
 		GUID:        refresh.GUID,
 		TokenString: hash,
+		Session:     session,
 	}
 
 	// Save refresh token in mongo if there was no old token.
 	if token == nil {
 		// Save refresh token in mongo.
 		if err := a.repository.StoreToken(ctx, toStoreToken); err != nil {
-			slog.Error(err.Error())
+			slog.ErrorContext(ctx, err.Error())
 			return nil, err
 		}
+		logSession(ctx, "session started", id, toStoreToken.Session)
+		metrics.TokensIssued.WithLabelValues(metrics.GrantTokenPair).Inc()
+		a.record(ctx, audit.EventTokenIssued, id, client, map[string]string{"grant": metrics.GrantTokenPair})
 
 		// Return token pair.
 		return map[string]any{
@@ -173,9 +290,13 @@ func (a *authUsecase) GetNewTokenPair(ctx context.Context, id string) (map[strin
 	} else {
 		// Update token in mongo -> it will replace used tokenstring with new tokenstring.
 		if err := a.repository.UpdateToken(ctx, toStoreToken); err != nil {
-			slog.Error(err.Error())
+			slog.ErrorContext(ctx, err.Error())
 			return nil, err
 		}
+		logSession(ctx, "session started", id, toStoreToken.Session)
+		metrics.TokensIssued.WithLabelValues(metrics.GrantTokenPair).Inc()
+		a.record(ctx, audit.EventTokenRevoked, id, client, map[string]string{"reason": "replaced"})
+		a.record(ctx, audit.EventTokenIssued, id, client, map[string]string{"grant": metrics.GrantTokenPair})
 
 		// Return the pair.
 		return map[string]any{
@@ -185,7 +306,7 @@ func (a *authUsecase) GetNewTokenPair(ctx context.Context, id string) (map[strin
 	}
 }
 
-func (a *authUsecase) validateID(id string) bool {
-	slog.Debug("validateid service called")
+func (a *authUsecase) validateID(ctx context.Context, id string) bool {
+	slog.DebugContext(ctx, "validateid service called")
 	return guid.IsGuid(id)
 }
@@ -7,6 +7,7 @@ import (
 
 	auth_repo_mocks "github.com/VanLavr/auth/internal/mocks/auht/repo"
 	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
 	"github.com/VanLavr/auth/internal/pkg/config"
 	e "github.com/VanLavr/auth/internal/pkg/errors"
 	"github.com/VanLavr/auth/internal/pkg/hasher"
@@ -59,17 +60,17 @@ func TestGetNewTokenPair(t *testing.T) {
 		},
 	}
 
-	service := New(repo, &config.Config{
+	service := newService(t, repo, &config.Config{
 		Secret:         "asdf",
 		AccessExpTime:  3 * time.Second,
 		RefreshExpTime: 5 * time.Second,
-	})
+	}, audit.New(audit.Discard{}))
 
 	for _, tc := range testcases {
 		t.Log(tc.name)
 		assert := assert.New(t)
 
-		tokens, err := service.GetNewTokenPair(context.Background(), tc.providedID)
+		tokens, err := service.GetNewTokenPair(context.Background(), tc.providedID, models.Confirmation{}, models.ClientInfo{})
 		assert.Equal(tc.expectedError, err)
 		for k := range tokens {
 			if !(k == tc.expectedResultKeys[0] || k == tc.expectedResultKeys[1]) {
@@ -85,14 +86,14 @@ func TestGetNewTokenPair(t *testing.T) {
 // 3) provide invalid refresh token
 // 4) provide used and not expired refresh token
 func TestRefreshTokenPair(t *testing.T) {
-	tokenMngr := newTokenManager(&config.Config{
+	tokenMngr := newTestTokenManager(t, &config.Config{
 		Secret:         "ggg",
 		AccessExpTime:  3 * time.Second,
 		RefreshExpTime: 5 * time.Second,
 	})
 
 	// 1) provide valid refresh and valid access tokens
-	tokens := tokenMngr.GenerateTokenPair("67a23ff3-20be-4420-9274-d16f2833d595")
+	tokens := newTestPair(t, tokenMngr, "67a23ff3-20be-4420-9274-d16f2833d595")
 	actk, ok := tokens["access_token"]
 	if !ok {
 		t.Fatal("can not generate")
@@ -105,7 +106,7 @@ func TestRefreshTokenPair(t *testing.T) {
 	hashedRefreshToken595 := hasher.Hshr.Encrypt(reftk)
 
 	// 2) provide expired refresh token
-	secondTokens := tokenMngr.GenerateTokenPair("67a23ff3-20be-4420-9274-d16f2833d656")
+	secondTokens := newTestPair(t, tokenMngr, "67a23ff3-20be-4420-9274-d16f2833d656")
 	actk2, ok := secondTokens["access_token"]
 	if !ok {
 		t.Fatal("can not generate")
@@ -122,7 +123,7 @@ func TestRefreshTokenPair(t *testing.T) {
 	invalidTokenHash := hasher.Hshr.Encrypt(invalidRefreshToken)
 
 	// 4) provide used and not expired refresh token
-	thirdTokens := tokenMngr.GenerateTokenPair("67a23ff3-20be-4420-9274-d16f2833d656")
+	thirdTokens := newTestPair(t, tokenMngr, "67a23ff3-20be-4420-9274-d16f2833d656")
 	actk3, ok := thirdTokens["access_token"]
 	if !ok {
 		t.Fatal("can not generate")
@@ -172,13 +173,13 @@ func TestRefreshTokenPair(t *testing.T) {
 			TokenString: hashedRefreshTokenUsed,
 		}, nil).Once()
 
-	repo.On("UpdateToken", context.Background(), mock.AnythingOfType("models.RefreshToken")).Return(nil).Twice()
+	repo.On("RotateToken", context.Background(), hashedRefreshToken595, mock.AnythingOfType("models.RefreshToken")).Return(nil).Once()
 
-	service := New(repo, &config.Config{
+	service := newService(t, repo, &config.Config{
 		Secret:         "ggg",
 		AccessExpTime:  3 * time.Second,
 		RefreshExpTime: 5 * time.Second,
-	})
+	}, audit.New(audit.Discard{}))
 
 	testcases := []struct {
 		providedContext           context.Context
@@ -244,7 +245,7 @@ func TestRefreshTokenPair(t *testing.T) {
 		assert := assert.New(t)
 		<-time.After(testcases[i].timeToWaitTillExpires)
 
-		tokens, err := service.RefreshTokenPair(testcases[i].providedContext, testcases[i].providedRefreshToken, testcases[i].providedAccessTokenString)
+		tokens, err := service.RefreshTokenPair(testcases[i].providedContext, testcases[i].providedRefreshToken, testcases[i].providedAccessTokenString, models.Confirmation{}, models.ClientInfo{})
 
 		assert.Equal(testcases[i].expectedError, err)
 		for k := range tokens {
@@ -254,3 +255,32 @@ func TestRefreshTokenPair(t *testing.T) {
 		}
 	}
 }
+
+// newService() creates the service or fails the test if the config is rejected.
+func newService(t *testing.T, r Repository, cfg *config.Config, auditor *audit.Logger) *authUsecase {
+	t.Helper()
+	service, err := New(r, cfg, auditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service.(*authUsecase)
+}
+
+// newTestTokenManager() creates the token manager or fails the test if the config is rejected.
+func newTestTokenManager(t *testing.T, cfg *config.Config) *tokenManager {
+	t.Helper()
+	manager, err := newTokenManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
+
+func newTestPair(t *testing.T, manager *tokenManager, id string) map[string]string {
+	t.Helper()
+	pair, err := manager.GenerateTokenPair(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
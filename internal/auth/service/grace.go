@@ -0,0 +1,100 @@
+package usecase
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	"github.com/VanLavr/auth/internal/pkg/hasher"
+)
+
+// Keeps the pair a refresh token was rotated to, so a client that lost the response
+// can retry with the rotated token within the window and get the same pair.
+// Pairs are stored sealed with AES-GCM under a key derived from the secret,
+// ciphertext is bound to the guid and the hash of the rotated token.
+type graceCache struct {
+	window time.Duration
+	aead   cipher.AEAD
+}
+
+func newGraceCache(cfg *config.Config) *graceCache {
+	key := sha256.Sum256([]byte("refresh grace " + cfg.Secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	return &graceCache{window: cfg.RefreshGrace, aead: aead}
+}
+
+// Enabled() reports if rotated tokens may be retried.
+func (g *graceCache) Enabled() bool {
+	return g.window > 0
+}
+
+// Encode the pair.
+// Seal it with a random nonce, guid and rotated hash are the additional data.
+func (g *graceCache) Seal(guid, previous string, pair map[string]string) (string, error) {
+	// Encode the pair.
+	plain, err := json.Marshal(pair)
+	if err != nil {
+		return "", err
+	}
+
+	// Seal it with a random nonce, guid and rotated hash are the additional data.
+	nonce := make([]byte, g.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := g.aead.Seal(nonce, nonce, plain, []byte(guid+previous))
+
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Check that provided token is the one that was rotated and the window is not over.
+// Open the pair.
+func (g *graceCache) Open(ctx context.Context, stored *models.RefreshToken, provided string) (map[string]string, bool) {
+	// Check that provided token is the one that was rotated and the window is not over.
+	if !g.Enabled() || stored.Previous == "" || stored.Pair == "" {
+		return nil, false
+	}
+	if time.Since(stored.RotatedAt) > g.window {
+		return nil, false
+	}
+	if !hasher.Hshr.Validate(stored.Previous, provided) {
+		return nil, false
+	}
+
+	// Open the pair.
+	sealed, err := base64.RawURLEncoding.DecodeString(stored.Pair)
+	if err != nil || len(sealed) < g.aead.NonceSize() {
+		slog.ErrorContext(ctx, "malformed cached pair")
+		return nil, false
+	}
+	nonce, ciphertext := sealed[:g.aead.NonceSize()], sealed[g.aead.NonceSize():]
+	plain, err := g.aead.Open(nil, nonce, ciphertext, []byte(stored.GUID+stored.Previous))
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, false
+	}
+
+	var pair map[string]string
+	if err := json.Unmarshal(plain, &pair); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, false
+	}
+
+	return pair, true
+}
@@ -0,0 +1,83 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	auth_repo_mocks "github.com/VanLavr/auth/internal/mocks/auht/repo"
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/hasher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Testcases:
+// 1) retry within the window gets the same pair
+// 2) retry with another access token is rejected
+// 3) retry after the window is a replay
+// 4) retry without grace window is a replay
+func TestRefreshGrace(t *testing.T) {
+	const id = "67a23ff3-20be-4420-9274-d16f2833d595"
+
+	testcases := []struct {
+		name          string
+		grace         time.Duration
+		rotatedAgo    time.Duration
+		otherAccess   bool
+		expectedError error
+	}{
+		{name: "1", grace: time.Minute},
+		{name: "2", grace: time.Minute, otherAccess: true, expectedError: e.ErrInvalidToken},
+		{name: "3", grace: time.Minute, rotatedAgo: 2 * time.Minute, expectedError: e.ErrTokenAlreadyUsed},
+		{name: "4", expectedError: e.ErrTokenAlreadyUsed},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			cfg := &config.Config{
+				Secret:         "ggg",
+				AccessExpTime:  time.Minute,
+				RefreshExpTime: time.Minute,
+				RefreshGrace:   tc.grace,
+			}
+			tokens := newTestPair(t, newTestTokenManager(t, cfg), id)
+			provided := models.RefreshToken{GUID: id, TokenString: tokens["refresh_token"]}
+
+			// Remember what was stored by the first refresh.
+			var stored models.RefreshToken
+			repo := &auth_repo_mocks.Repository{}
+			repo.On("GetToken", context.Background(), provided).
+				Return(&models.RefreshToken{GUID: id, TokenString: hasher.Hshr.Encrypt(provided.TokenString)}, nil).Once()
+			repo.On("RotateToken", context.Background(), hasher.Hshr.Encrypt(provided.TokenString), mock.AnythingOfType("models.RefreshToken")).
+				Run(func(args mock.Arguments) { stored = args.Get(2).(models.RefreshToken) }).
+				Return(nil).Once()
+
+			service := newService(t, repo, cfg, audit.New(audit.Discard{}))
+			first, err := service.RefreshTokenPair(context.Background(), provided, tokens["access_token"], models.Confirmation{}, models.ClientInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Retry with the same token, the response was lost.
+			stored.RotatedAt = stored.RotatedAt.Add(-tc.rotatedAgo)
+			repo.On("GetToken", context.Background(), provided).Return(&stored, nil).Once()
+
+			access := tokens["access_token"]
+			if tc.otherAccess {
+				access = newTestPair(t, newTestTokenManager(t, cfg), "67a23ff3-20be-4420-9274-d16f2833d656")["access_token"]
+			}
+			second, err := service.RefreshTokenPair(context.Background(), provided, access, models.Confirmation{}, models.ClientInfo{})
+
+			assert.Equal(tc.expectedError, err)
+			if tc.expectedError == nil {
+				assert.Equal(first, second)
+			}
+			repo.AssertExpectations(t)
+		})
+	}
+}
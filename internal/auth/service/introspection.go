@@ -0,0 +1,89 @@
+// Introspect token: find the session in the store -> validate refresh token (./jwt.go) and check its rotation state
+// or check that access token belongs to the live session -> describe token.
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/VanLavr/auth/internal/models"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/hasher"
+	"github.com/VanLavr/auth/internal/pkg/tracing"
+)
+
+// Check token type.
+// Find the session of the user (tokens of ended sessions are inactive).
+// Validate refresh token jwt and compare it with stored hash (rotated tokens are inactive).
+// Check that access token was not issued before the session started (a new pair replaces the session and revokes its tokens).
+// Describe active token.
+func (a *authUsecase) IntrospectToken(ctx context.Context, token string, claims map[string]any) (introspection map[string]any, err error) {
+	slog.DebugContext(ctx, "introspecttoken service called")
+	ctx, span := tracing.Start(ctx, "usecase.IntrospectToken")
+	defer func() { tracing.End(span, err) }()
+
+	guid, ok := claims["guid"].(string)
+	if !ok || guid == "" {
+		slog.ErrorContext(ctx, "token has no guid")
+		return nil, e.ErrInvalidToken
+	}
+
+	// Check token type.
+	tokenType := "access_token"
+	if claims["typ"] == models.TypRefreshToken {
+		tokenType = "refresh_token"
+	}
+
+	// Find the session of the user (tokens of ended sessions are inactive).
+	stored, err := a.repository.GetToken(ctx, models.RefreshToken{GUID: guid})
+	if err == e.ErrTokenNotFound || (err == nil && stored == nil) {
+		slog.ErrorContext(ctx, "session is not found")
+		return nil, e.ErrInvalidToken
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, err
+	}
+
+	if tokenType == "refresh_token" {
+		// Validate refresh token jwt and compare it with stored hash (rotated tokens are inactive).
+		if _, valid := a.tokenManager.ValidateRefreshToken(ctx, token); !valid {
+			slog.ErrorContext(ctx, "token jwt malformed")
+			return nil, e.ErrInvalidToken
+		}
+		if !hasher.Hshr.Validate(stored.TokenString, token) {
+			slog.ErrorContext(ctx, "token is used")
+			return nil, e.ErrInvalidToken
+		}
+	} else {
+		// Check that access token was not issued before the session started (a new pair replaces the session and revokes its tokens).
+		iat, _ := claims["iat"].(float64)
+		if time.Unix(int64(iat), 0).Before(stored.Session.CreatedAt.Truncate(time.Second)) {
+			slog.ErrorContext(ctx, "session of the token is revoked")
+			return nil, e.ErrInvalidToken
+		}
+	}
+
+	// Describe active token.
+	result := map[string]any{
+		"active":     true,
+		"sub":        guid,
+		"token_type": tokenType,
+	}
+	for _, claim := range []string{"exp", "iat"} {
+		if v, ok := claims[claim].(float64); ok {
+			result[claim] = int64(v)
+		}
+	}
+	for _, claim := range []string{"scope", "client_id"} {
+		if v, ok := claims[claim].(string); ok && v != "" {
+			result[claim] = v
+		}
+	}
+	if cnf := models.ConfirmationFromClaims(claims).Claim(); cnf != nil {
+		result["cnf"] = cnf
+	}
+
+	return result, nil
+}
@@ -0,0 +1,121 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	auth_repo_mocks "github.com/VanLavr/auth/internal/mocks/auht/repo"
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/hasher"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testcases:
+// 1) active access token
+// 2) active refresh token
+// 3) rotated refresh token
+// 4) refresh token of unknown user
+// 5) access token of unknown user (session ended)
+// 6) access token issued before the session was replaced
+func TestIntrospectToken(t *testing.T) {
+	cfg := &config.Config{
+		Secret:         "introspect",
+		AccessExpTime:  10 * time.Second,
+		RefreshExpTime: 20 * time.Second,
+	}
+	tokenMngr := newTestTokenManager(t, cfg)
+
+	current := newTestPair(t, tokenMngr, "67a23ff3-20be-4420-9274-d16f2833d595")
+	rotated := newTestPair(t, tokenMngr, "67a23ff3-20be-4420-9274-d16f2833d656")
+	unknown := newTestPair(t, tokenMngr, "67a23ff3-20be-4420-9274-d16f2833d777")
+
+	replaced := newTestPair(t, tokenMngr, "67a23ff3-20be-4420-9274-d16f2833d888")
+
+	repo := &auth_repo_mocks.Repository{}
+	repo.On("GetToken", context.Background(), models.RefreshToken{GUID: "67a23ff3-20be-4420-9274-d16f2833d595"}).Return(&models.RefreshToken{
+		GUID:        "67a23ff3-20be-4420-9274-d16f2833d595",
+		TokenString: hasher.Hshr.Encrypt(current["refresh_token"]),
+		Session:     models.Session{CreatedAt: time.Now().Add(-time.Minute)},
+	}, nil).Twice()
+	repo.On("GetToken", context.Background(), models.RefreshToken{GUID: "67a23ff3-20be-4420-9274-d16f2833d656"}).Return(&models.RefreshToken{
+		GUID:        "67a23ff3-20be-4420-9274-d16f2833d656",
+		TokenString: hasher.Hshr.Encrypt("newer token"),
+	}, nil).Once()
+	repo.On("GetToken", context.Background(), models.RefreshToken{GUID: "67a23ff3-20be-4420-9274-d16f2833d777"}).Return(nil, e.ErrTokenNotFound).Twice()
+	repo.On("GetToken", context.Background(), models.RefreshToken{GUID: "67a23ff3-20be-4420-9274-d16f2833d888"}).Return(&models.RefreshToken{
+		GUID:        "67a23ff3-20be-4420-9274-d16f2833d888",
+		TokenString: hasher.Hshr.Encrypt("token of the new session"),
+		Session:     models.Session{CreatedAt: time.Now().Add(time.Minute)},
+	}, nil).Once()
+
+	service := newService(t, repo, cfg, audit.New(audit.Discard{}))
+
+	testcases := []struct {
+		token             string
+		expectedTokenType string
+		expectedError     error
+		name              string
+	}{
+		{
+			token:             current["access_token"],
+			expectedTokenType: "access_token",
+			expectedError:     nil,
+			name:              "1",
+		},
+		{
+			token:             current["refresh_token"],
+			expectedTokenType: "refresh_token",
+			expectedError:     nil,
+			name:              "2",
+		},
+		{
+			token:         rotated["refresh_token"],
+			expectedError: e.ErrInvalidToken,
+			name:          "3",
+		},
+		{
+			token:         unknown["refresh_token"],
+			expectedError: e.ErrInvalidToken,
+			name:          "4",
+		},
+		{
+			token:         unknown["access_token"],
+			expectedError: e.ErrInvalidToken,
+			name:          "5",
+		},
+		{
+			token:         replaced["access_token"],
+			expectedError: e.ErrInvalidToken,
+			name:          "6",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Log(tc.name)
+		assert := assert.New(t)
+
+		claims := jwt.MapClaims{}
+		_, err := jwt.ParseWithClaims(tc.token, claims, func(t *jwt.Token) (interface{}, error) {
+			return []byte(cfg.Secret), nil
+		})
+		assert.Nil(err)
+
+		data, err := service.IntrospectToken(context.Background(), tc.token, claims)
+		assert.Equal(tc.expectedError, err)
+		if err != nil {
+			continue
+		}
+
+		assert.Equal(true, data["active"])
+		assert.Equal("67a23ff3-20be-4420-9274-d16f2833d595", data["sub"])
+		assert.Equal(tc.expectedTokenType, data["token_type"])
+		assert.Contains(data, "exp")
+		assert.Contains(data, "iat")
+	}
+	repo.AssertExpectations(t)
+}
@@ -1,99 +1,127 @@
 package usecase
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
+	"github.com/VanLavr/auth/internal/models"
 	"github.com/VanLavr/auth/internal/pkg/config"
-	e "github.com/VanLavr/auth/internal/pkg/errors"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/VanLavr/auth/internal/pkg/tokens"
+	"github.com/beevik/guid"
 )
 
 // Stands for generating and token pairs and validating refresh token.
+// Tokens are signed in the formats selected in config and verified in any supported format.
 type tokenManager struct {
-	secret string
-	acExp  time.Duration
-	refExp time.Duration
+	formats *tokens.Formats
+	acExp   time.Duration
+	refExp  time.Duration
 }
 
-func newTokenManager(cfg *config.Config) *tokenManager {
-	return &tokenManager{
-		secret: cfg.Secret,
-		acExp:  cfg.AccessExpTime,
-		refExp: cfg.RefreshExpTime,
+func newTokenManager(cfg *config.Config) (*tokenManager, error) {
+	formats, err := tokens.New(cfg)
+	if err != nil {
+		return nil, err
 	}
+	return &tokenManager{
+		formats: formats,
+		acExp:   cfg.AccessExpTime,
+		refExp:  cfg.RefreshExpTime,
+	}, nil
 }
 
-func (j *tokenManager) GenerateTokenPair(id string) map[string]string {
+func (j *tokenManager) GenerateTokenPair(ctx context.Context, id string) (map[string]string, error) {
+	return j.GenerateBoundTokenPair(ctx, id, models.Confirmation{})
+}
+
+// GenerateBoundTokenPair() generates a pair bound to the client key (both tokens carry cnf claim).
+// It fails if a token can not be signed or encrypted with the configured keys.
+func (j *tokenManager) GenerateBoundTokenPair(ctx context.Context, id string, cnf models.Confirmation) (map[string]string, error) {
 	timeStamp := time.Now().Unix()
-	return map[string]string{
-		"access_token":  j.generateAccessToken(id, timeStamp),
-		"refresh_token": j.generateRefreshToken(id, timeStamp),
+	access, err := j.generateAccessToken(ctx, id, timeStamp, cnf)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := j.generateRefreshToken(ctx, id, timeStamp, cnf)
+	if err != nil {
+		return nil, err
 	}
+	return map[string]string{
+		"access_token":  access,
+		"refresh_token": refresh,
+	}, nil
 }
 
 // Create claims.
 //
 //	coherent field is stand for mark tokens that were created in pair.
+//	jti field makes every refresh token unique, so rotation within the same second changes it.
+//	cnf field binds token to the client key if it was provided.
 //
 // Sign token.
+// Encrypt it if it is configured.
 // Return it.
-func (j *tokenManager) generateRefreshToken(id string, timestamp int64) string {
+func (j *tokenManager) generateRefreshToken(ctx context.Context, id string, timestamp int64, cnf models.Confirmation) (string, error) {
 	// Create claims.
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+	claims := map[string]any{
 		"guid":     id,
 		"exp":      time.Now().Add(j.refExp).Unix(),
+		"iat":      timestamp,
+		"typ":      models.TypRefreshToken,
 		"coherent": fmt.Sprintf("%d%s", timestamp, id),
-	})
+		"jti":      guid.NewString(),
+	}
+	if bound := cnf.Claim(); bound != nil {
+		claims["cnf"] = bound
+	}
 
 	// Sign token.
-	stringToken, err := token.SignedString([]byte(j.secret))
+	stringToken, err := j.formats.Refresh().Sign(claims)
 	if err != nil {
-		slog.Error(err.Error())
+		return "", err
 	}
 
+	// Encrypt it if it is configured.
 	// Return it.
-	return stringToken
+	return j.formats.Encrypt(stringToken, nil)
 }
 
-func (j *tokenManager) generateAccessToken(id string, timestamp int64) string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+func (j *tokenManager) generateAccessToken(ctx context.Context, id string, timestamp int64, cnf models.Confirmation) (string, error) {
+	claims := map[string]any{
 		"guid":     id,
 		"exp":      time.Now().Add(j.acExp).Unix(),
+		"iat":      timestamp,
+		"typ":      models.TypAccessToken,
 		"coherent": fmt.Sprintf("%d%s", timestamp, id),
-	})
+	}
+	if bound := cnf.Claim(); bound != nil {
+		claims["cnf"] = bound
+	}
 
-	stringToken, err := token.SignedString([]byte(j.secret))
+	stringToken, err := j.formats.Access().Sign(claims)
 	if err != nil {
-		slog.Error(err.Error())
+		return "", err
 	}
 
-	return stringToken
+	return j.formats.Encrypt(stringToken, nil)
 }
 
 // Parse token from provided string.
 // Check if it is valid.
 // Extract guid from claims.
-func (j *tokenManager) ValidateRefreshToken(tokenString string) (string, bool) {
+func (j *tokenManager) ValidateRefreshToken(ctx context.Context, tokenString string) (string, bool) {
 	// Parse token from provided string.
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		_, ok := t.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
-			return nil, e.ErrInvalidSigningMethod
-		}
-		return []byte(j.secret), nil
-	})
-
 	// Check if it is valid.
-	if err != nil || !token.Valid {
-		slog.Error(err.Error())
+	claims, err := j.formats.Verify(ctx, tokenString)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
 		return "", false
 	}
 
 	// Extract guid from claims.
-	claims := token.Claims.(jwt.MapClaims)
-
 	id := claims["guid"]
 	guid, ok := id.(string)
 	if !ok {
@@ -103,39 +131,38 @@ func (j *tokenManager) ValidateRefreshToken(tokenString string) (string, bool) {
 	return guid, true
 }
 
-// Parse tokens from provided strings.
+// Parse token from provided string.
+// Extract cnf claim (empty if token is not bound).
+func (j *tokenManager) Confirmation(ctx context.Context, tokenString string) models.Confirmation {
+	// Parse token from provided string.
+	claims, err := j.formats.Verify(ctx, tokenString)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return models.Confirmation{}
+	}
+
+	// Extract cnf claim (empty if token is not bound).
+	return models.ConfirmationFromClaims(claims)
+}
+
+// Parse tokens from provided strings (access token is usually expired by the time of refreshing).
 // Extract timestamp from claims.
 // Compare timestamps.
-func (j *tokenManager) ValidateTokensCoherence(access, refresh string) bool {
-	// Parse tokens from provided strings.
-	accessToken, err := jwt.Parse(access, func(t *jwt.Token) (interface{}, error) {
-		_, ok := t.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
-			return nil, e.ErrInvalidSigningMethod
-		}
-		return []byte(j.secret), nil
-	})
-
+func (j *tokenManager) ValidateTokensCoherence(ctx context.Context, access, refresh string) bool {
+	// Parse tokens from provided strings (access token is usually expired by the time of refreshing).
+	accessClaims, err := j.formats.VerifyIgnoringExpiry(ctx, access)
 	if err != nil {
-		slog.Error(err.Error())
+		slog.ErrorContext(ctx, err.Error())
+		return false
 	}
 
-	refreshToken, err := jwt.Parse(refresh, func(t *jwt.Token) (interface{}, error) {
-		_, ok := t.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
-			return nil, e.ErrInvalidSigningMethod
-		}
-		return []byte(j.secret), nil
-	})
-
+	refreshClaims, err := j.formats.VerifyIgnoringExpiry(ctx, refresh)
 	if err != nil {
-		slog.Error(err.Error())
+		slog.ErrorContext(ctx, err.Error())
+		return false
 	}
 
 	// Extract timestamp from claims.
-	accessClaims := accessToken.Claims.(jwt.MapClaims)
-	refreshClaims := refreshToken.Claims.(jwt.MapClaims)
-
 	// Compare timestamps.
 	accessCoh, ok := accessClaims["coherent"]
 	if !ok {
@@ -149,3 +176,40 @@ func (j *tokenManager) ValidateTokensCoherence(access, refresh string) bool {
 
 	return accessCoh == refreshCoh
 }
+
+// Create claims restricted to the audience and scope.
+//
+//	act field records the delegation chain, it is omitted for impersonation.
+//	cnf field binds token to the client certificate if it was provided.
+//
+// Sign token.
+// Encrypt it for the audience if it is configured.
+// Return it.
+func (j *tokenManager) GenerateExchangedToken(id, clientID string, audience, scope []string, act map[string]any, cnf models.Confirmation, exp time.Time) (string, error) {
+	// Create claims restricted to the audience and scope.
+	claims := map[string]any{
+		"guid":      id,
+		"exp":       exp.Unix(),
+		"iat":       time.Now().Unix(),
+		"typ":       models.TypAccessToken,
+		"aud":       audience,
+		"scope":     strings.Join(scope, " "),
+		"client_id": clientID,
+	}
+	if act != nil {
+		claims["act"] = act
+	}
+	if bound := cnf.Claim(); bound != nil {
+		claims["cnf"] = bound
+	}
+
+	// Sign token.
+	stringToken, err := j.formats.Access().Sign(claims)
+	if err != nil {
+		return "", err
+	}
+
+	// Encrypt it for the audience if it is configured.
+	// Return it.
+	return j.formats.Encrypt(stringToken, audience)
+}
@@ -0,0 +1,71 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/tracing"
+)
+
+// Start the session of a new token pair.
+func newSession(client models.ClientInfo) models.Session {
+	now := time.Now()
+	return models.Session{
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ClientIP:   client.IP,
+		UserAgent:  client.UserAgent,
+		ClientID:   client.ClientID,
+	}
+}
+
+// Carry the session over rotation, client is the one that used the token.
+func rotateSession(session models.Session, client models.ClientInfo) models.Session {
+	session.LastUsedAt = time.Now()
+	session.Rotations++
+	session.ClientIP = client.IP
+	session.UserAgent = client.UserAgent
+	session.ClientID = client.ClientID
+	return session
+}
+
+func logSession(ctx context.Context, msg, guid string, session models.Session) {
+	slog.InfoContext(ctx, msg,
+		slog.String("guid", guid),
+		slog.Time("created_at", session.CreatedAt),
+		slog.Int("rotations", session.Rotations),
+		slog.String("client_ip", session.ClientIP),
+		slog.String("user_agent", session.UserAgent),
+		slog.String("client_id", session.ClientID),
+	)
+}
+
+// Validate GUID.
+// Get stored token of the user.
+// Return its session, the client that viewed it is audited.
+func (a *authUsecase) GetSession(ctx context.Context, id string, client models.ClientInfo) (session *models.Session, err error) {
+	slog.DebugContext(ctx, "getsession service called")
+	ctx, span := tracing.Start(ctx, "usecase.GetSession")
+	defer func() { tracing.End(span, err) }()
+
+	// Validate GUID.
+	if !a.validateID(ctx, id) {
+		slog.ErrorContext(ctx, e.ErrInvalidGUID.Error())
+		return nil, e.ErrInvalidGUID
+	}
+
+	// Get stored token of the user.
+	token, err := a.repository.GetToken(ctx, models.RefreshToken{GUID: id})
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, err
+	}
+
+	// Return its session, the client that viewed it is audited.
+	a.record(ctx, audit.EventSessionViewed, id, client, nil)
+	return &token.Session, nil
+}
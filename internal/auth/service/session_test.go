@@ -0,0 +1,104 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	auth_repo_mocks "github.com/VanLavr/auth/internal/mocks/auht/repo"
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/hasher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Session is started on issuance and carried over by refresh with the client that used the token.
+func TestSessionMetadata(t *testing.T) {
+	const id = "67a23ff3-20be-4420-9274-d16f2833d595"
+	assert := assert.New(t)
+	cfg := &config.Config{
+		Secret:         "session",
+		AccessExpTime:  time.Minute,
+		RefreshExpTime: time.Minute,
+	}
+	issuer := models.ClientInfo{IP: "203.0.113.7", UserAgent: "app/1.0", ClientID: "orders"}
+	refresher := models.ClientInfo{IP: "198.51.100.1", UserAgent: "app/1.1"}
+
+	// Issue a pair.
+	var stored models.RefreshToken
+	repo := &auth_repo_mocks.Repository{}
+	repo.On("GetToken", context.Background(), models.RefreshToken{GUID: id}).Return(nil, e.ErrTokenNotFound).Once()
+	repo.On("StoreToken", context.Background(), mock.AnythingOfType("models.RefreshToken")).
+		Run(func(args mock.Arguments) { stored = args.Get(1).(models.RefreshToken) }).
+		Return(nil).Once()
+
+	service := newService(t, repo, cfg, audit.New(audit.Discard{}))
+	pair, err := service.GetNewTokenPair(context.Background(), id, models.Confirmation{}, issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(0, stored.Session.Rotations)
+	assert.Equal(issuer.IP, stored.Session.ClientIP)
+	assert.Equal(issuer.UserAgent, stored.Session.UserAgent)
+	assert.Equal(issuer.ClientID, stored.Session.ClientID)
+	assert.WithinDuration(time.Now(), stored.Session.CreatedAt, time.Second)
+
+	// Refresh it from another client.
+	refresh := pair["refresh_token"].(models.RefreshToken)
+	var rotated models.RefreshToken
+	repo.On("GetToken", context.Background(), refresh).Return(&stored, nil).Once()
+	repo.On("RotateToken", context.Background(), hasher.Hshr.Encrypt(refresh.TokenString), mock.AnythingOfType("models.RefreshToken")).
+		Run(func(args mock.Arguments) { rotated = args.Get(2).(models.RefreshToken) }).
+		Return(nil).Once()
+
+	_, err = service.RefreshTokenPair(context.Background(), refresh, pair["access_token"].(string), models.Confirmation{}, refresher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(1, rotated.Session.Rotations)
+	assert.Equal(stored.Session.CreatedAt, rotated.Session.CreatedAt)
+	assert.Equal(refresher.IP, rotated.Session.ClientIP)
+	assert.Equal(refresher.UserAgent, rotated.Session.UserAgent)
+	assert.Empty(rotated.Session.ClientID)
+	repo.AssertExpectations(t)
+}
+
+// Testcases:
+// 1) session of the user
+// 2) invalid guid
+// 3) user without token
+func TestGetSession(t *testing.T) {
+	session := models.Session{Rotations: 2, ClientIP: "203.0.113.7"}
+	repo := &auth_repo_mocks.Repository{}
+	repo.On("GetToken", context.Background(), models.RefreshToken{GUID: "67a23ff3-20be-4420-9274-d16f2833d595"}).
+		Return(&models.RefreshToken{GUID: "67a23ff3-20be-4420-9274-d16f2833d595", Session: session}, nil).Once()
+	repo.On("GetToken", context.Background(), models.RefreshToken{GUID: "67a23ff3-20be-4420-9274-d16f2833d656"}).
+		Return(nil, e.ErrTokenNotFound).Once()
+
+	service := newService(t, repo, &config.Config{Secret: "session"}, audit.New(audit.Discard{}))
+
+	testcases := []struct {
+		name           string
+		id             string
+		expectedResult *models.Session
+		expectedError  error
+	}{
+		{name: "1", id: "67a23ff3-20be-4420-9274-d16f2833d595", expectedResult: &session},
+		{name: "2", id: "adsf", expectedError: e.ErrInvalidGUID},
+		{name: "3", id: "67a23ff3-20be-4420-9274-d16f2833d656", expectedError: e.ErrTokenNotFound},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := service.GetSession(context.Background(), tc.id, models.ClientInfo{})
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedResult, result)
+		})
+	}
+}
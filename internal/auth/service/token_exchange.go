@@ -0,0 +1,157 @@
+// Exchange token: check client policy -> down-scope and restrict audience -> record delegation chain -> sign new access token.
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/metrics"
+	"github.com/VanLavr/auth/internal/pkg/tracing"
+)
+
+// Find client policy.
+// Check that bound subject and actor tokens are presented with their key (DPoP proof or client certificate of the request).
+// Check if client may impersonate or delegate.
+// Check requested audience.
+// Down-scope requested scope.
+// Build act claim with the delegation chain.
+// Limit expiration by the subject token.
+// Generate and return the token.
+func (a *authUsecase) ExchangeToken(ctx context.Context, req models.TokenExchange) (exchanged map[string]any, err error) {
+	slog.DebugContext(ctx, "exchangetoken service called")
+	_, span := tracing.Start(ctx, "usecase.ExchangeToken")
+	defer func() { tracing.End(span, err) }()
+
+	// Find client policy.
+	client, ok := a.clients[req.ClientID]
+	if !ok {
+		slog.ErrorContext(ctx, e.ErrUnauthorizedClient.Error())
+		return nil, e.ErrUnauthorizedClient
+	}
+	policy := client.Exchange
+
+	// Check that bound subject and actor tokens are presented with their key (DPoP proof or client certificate of the request).
+	for _, claims := range []map[string]any{req.Subject, req.Actor} {
+		if claims != nil && !models.ConfirmationFromClaims(claims).PresentedWith(req.Confirmation) {
+			slog.ErrorContext(ctx, "exchanged token is bound to another key")
+			return nil, e.ErrInvalidToken
+		}
+	}
+
+	subject, ok := req.Subject["guid"].(string)
+	if !ok || subject == "" {
+		slog.ErrorContext(ctx, "subject token has no guid")
+		return nil, e.ErrInvalidToken
+	}
+
+	// Check if client may impersonate or delegate.
+	var actor string
+	if req.Actor == nil {
+		if !policy.Impersonation {
+			slog.ErrorContext(ctx, "impersonation is not allowed")
+			return nil, e.ErrUnauthorizedClient
+		}
+	} else {
+		actor, ok = req.Actor["guid"].(string)
+		if !ok || actor == "" {
+			slog.ErrorContext(ctx, "actor token has no guid")
+			return nil, e.ErrInvalidToken
+		}
+		if !policy.Delegation || (len(policy.Actors) != 0 && !slices.Contains(policy.Actors, actor)) {
+			slog.ErrorContext(ctx, "delegation is not allowed")
+			return nil, e.ErrUnauthorizedClient
+		}
+	}
+
+	// Check requested audience.
+	if len(req.Audience) == 0 {
+		slog.ErrorContext(ctx, "audience was not provided")
+		return nil, e.ErrInvalidTarget
+	}
+	for _, aud := range req.Audience {
+		if !slices.Contains(policy.Audiences, aud) {
+			slog.ErrorContext(ctx, "audience is not allowed")
+			return nil, e.ErrInvalidTarget
+		}
+	}
+
+	// Down-scope requested scope.
+	scope, err := a.downScope(ctx, req.Subject, req.Scope, policy.Scopes)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, err
+	}
+
+	// Build act claim with the delegation chain.
+	// The current actor is the outermost one, prior actors are taken from the subject token.
+	prior, _ := req.Subject["act"].(map[string]any)
+	act := prior
+	if actor != "" {
+		act = map[string]any{"sub": actor}
+		if prior != nil {
+			act["act"] = prior
+		}
+	}
+
+	// Limit expiration by the subject token.
+	exp := time.Now().Add(a.tokenManager.acExp)
+	if subjectExp, ok := req.Subject["exp"].(float64); ok && time.Unix(int64(subjectExp), 0).Before(exp) {
+		exp = time.Unix(int64(subjectExp), 0)
+	}
+
+	// Generate and return the token.
+	token, err := a.tokenManager.GenerateExchangedToken(subject, client.ID, req.Audience, scope, act, req.Confirmation, exp)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return nil, err
+	}
+	metrics.TokensIssued.WithLabelValues(metrics.GrantTokenExchange).Inc()
+	a.record(ctx, audit.EventTokenExchanged, subject, models.ClientInfo{ClientID: client.ID}, map[string]string{
+		"actor":    actor,
+		"audience": strings.Join(req.Audience, " "),
+		"scope":    strings.Join(scope, " "),
+	})
+
+	return map[string]any{
+		"access_token":      token,
+		"issued_token_type": models.TokenTypeAccessToken,
+		"token_type":        "Bearer",
+		"expires_in":        int64(time.Until(exp).Seconds()),
+		"scope":             strings.Join(scope, " "),
+	}, nil
+}
+
+// Take allowed scopes from client policy.
+// Narrow them with subject token scope if it has one.
+// Check that requested scopes are allowed, grant all allowed ones if nothing was requested.
+func (a *authUsecase) downScope(ctx context.Context, subject map[string]any, requested, policy []string) ([]string, error) {
+	slog.DebugContext(ctx, "downscope service called")
+	// Take allowed scopes from client policy.
+	allowed := slices.Clone(policy)
+
+	// Narrow them with subject token scope if it has one.
+	if subjectScope, ok := subject["scope"].(string); ok {
+		held := strings.Fields(subjectScope)
+		allowed = slices.DeleteFunc(allowed, func(s string) bool {
+			return !slices.Contains(held, s)
+		})
+	}
+
+	// Check that requested scopes are allowed, grant all allowed ones if nothing was requested.
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+	for _, s := range requested {
+		if !slices.Contains(allowed, s) {
+			return nil, e.ErrInvalidScope
+		}
+	}
+
+	return requested, nil
+}
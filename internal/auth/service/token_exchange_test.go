@@ -0,0 +1,153 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	auth_repo_mocks "github.com/VanLavr/auth/internal/mocks/auht/repo"
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testcases:
+// 1) impersonation by a client allowed to impersonate
+// 2) impersonation by a client allowed only to delegate
+// 3) delegation by an allowed actor of an already delegated token
+// 4) delegation by an actor that is not allowed
+// 5) audience that is not allowed
+// 6) scope wider than the subject token scope
+// 7) unknown client
+// 8) DPoP bound subject token without the proof of its key
+// 9) DPoP bound subject token with the proof of its key
+// 10) certificate bound actor token presented with another certificate
+func TestExchangeToken(t *testing.T) {
+	cfg := &config.Config{
+		Secret:         "exchange",
+		AccessExpTime:  10 * time.Second,
+		RefreshExpTime: 20 * time.Second,
+		Clients: map[string]config.Client{
+			"gateway": {
+				ID: "gateway",
+				Exchange: config.ExchangePolicy{
+					Impersonation: true,
+					Audiences:     []string{"orders"},
+					Scopes:        []string{"read", "write"},
+				},
+			},
+			"orders": {
+				ID: "orders",
+				Exchange: config.ExchangePolicy{
+					Delegation: true,
+					Actors:     []string{"orders-service"},
+					Audiences:  []string{"billing"},
+					Scopes:     []string{"read", "write"},
+				},
+			},
+		},
+	}
+	service := newService(t, &auth_repo_mocks.Repository{}, cfg, audit.New(audit.Discard{}))
+
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	subject := map[string]any{"guid": "user", "exp": exp}
+	delegated := map[string]any{"guid": "user", "exp": exp, "scope": "read", "act": map[string]any{"sub": "gateway-service"}}
+	actor := map[string]any{"guid": "orders-service", "exp": exp}
+	stranger := map[string]any{"guid": "stranger", "exp": exp}
+	dpopBound := map[string]any{"guid": "user", "exp": exp, "cnf": map[string]any{"jkt": "dpop-key"}}
+	certBound := map[string]any{"guid": "orders-service", "exp": exp, "cnf": map[string]any{"x5t#S256": "orders-cert"}}
+
+	testcases := []struct {
+		req           models.TokenExchange
+		expectedScope string
+		expectedAct   any
+		expectedError error
+		name          string
+	}{
+		{
+			req:           models.TokenExchange{ClientID: "gateway", Subject: subject, Audience: []string{"orders"}, Scope: []string{"read"}},
+			expectedScope: "read",
+			expectedAct:   nil,
+			expectedError: nil,
+			name:          "1",
+		},
+		{
+			req:           models.TokenExchange{ClientID: "orders", Subject: subject, Audience: []string{"billing"}},
+			expectedError: e.ErrUnauthorizedClient,
+			name:          "2",
+		},
+		{
+			req:           models.TokenExchange{ClientID: "orders", Subject: delegated, Actor: actor, Audience: []string{"billing"}},
+			expectedScope: "read",
+			expectedAct: map[string]any{
+				"sub": "orders-service",
+				"act": map[string]any{"sub": "gateway-service"},
+			},
+			expectedError: nil,
+			name:          "3",
+		},
+		{
+			req:           models.TokenExchange{ClientID: "orders", Subject: subject, Actor: stranger, Audience: []string{"billing"}},
+			expectedError: e.ErrUnauthorizedClient,
+			name:          "4",
+		},
+		{
+			req:           models.TokenExchange{ClientID: "gateway", Subject: subject, Audience: []string{"billing"}},
+			expectedError: e.ErrInvalidTarget,
+			name:          "5",
+		},
+		{
+			req:           models.TokenExchange{ClientID: "orders", Subject: delegated, Actor: actor, Audience: []string{"billing"}, Scope: []string{"write"}},
+			expectedError: e.ErrInvalidScope,
+			name:          "6",
+		},
+		{
+			req:           models.TokenExchange{ClientID: "unknown", Subject: subject, Audience: []string{"orders"}},
+			expectedError: e.ErrUnauthorizedClient,
+			name:          "7",
+		},
+		{
+			req:           models.TokenExchange{ClientID: "gateway", Subject: dpopBound, Audience: []string{"orders"}},
+			expectedError: e.ErrInvalidToken,
+			name:          "8",
+		},
+		{
+			req:           models.TokenExchange{ClientID: "gateway", Subject: dpopBound, Audience: []string{"orders"}, Confirmation: models.Confirmation{JKT: "dpop-key"}},
+			expectedScope: "read write",
+			expectedError: nil,
+			name:          "9",
+		},
+		{
+			req:           models.TokenExchange{ClientID: "orders", Subject: subject, Actor: certBound, Audience: []string{"billing"}, Confirmation: models.Confirmation{X5T: "gateway-cert"}},
+			expectedError: e.ErrInvalidToken,
+			name:          "10",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Log(tc.name)
+		assert := assert.New(t)
+
+		data, err := service.ExchangeToken(context.Background(), tc.req)
+		assert.Equal(tc.expectedError, err)
+		if err != nil {
+			continue
+		}
+
+		claims := jwt.MapClaims{}
+		_, err = jwt.ParseWithClaims(data["access_token"].(string), claims, func(t *jwt.Token) (interface{}, error) {
+			return []byte(cfg.Secret), nil
+		})
+		assert.Nil(err)
+		assert.Equal(tc.expectedScope, claims["scope"])
+		assert.Equal(tc.expectedAct, claims["act"])
+		assert.Equal(tc.req.ClientID, claims["client_id"])
+		assert.Equal(models.TokenTypeAccessToken, data["issued_token_type"])
+		if cnf := tc.req.Confirmation.Claim(); cnf != nil {
+			assert.Equal(cnf, claims["cnf"])
+		}
+	}
+}
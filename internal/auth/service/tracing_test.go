@@ -0,0 +1,57 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	auth_repo_mocks "github.com/VanLavr/auth/internal/mocks/auht/repo"
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Testcases:
+// 1) repository is called within the span of the usecase, which is a child of the caller span
+// 2) failed call marks the span as failed
+func TestTracing(t *testing.T) {
+	const id = "67a23ff3-20be-4420-9274-d16f2833d595"
+	assert := assert.New(t)
+	exporter, restore := tracing.SetupInMemory()
+	defer restore()
+
+	var repoSpan trace.SpanContext
+	repo := &auth_repo_mocks.Repository{}
+	repo.On("GetToken", mock.Anything, models.RefreshToken{GUID: id}).
+		Run(func(args mock.Arguments) { repoSpan = trace.SpanContextFromContext(args.Get(0).(context.Context)) }).
+		Return(nil, e.ErrTokenNotFound).Once()
+	repo.On("StoreToken", mock.Anything, mock.AnythingOfType("models.RefreshToken")).Return(nil).Once()
+	service := newService(t, repo, &config.Config{Secret: "tracing", AccessExpTime: time.Minute, RefreshExpTime: time.Minute}, audit.New(audit.Discard{}))
+
+	// 1
+	ctx, parent := tracing.Start(context.Background(), "caller")
+	_, err := service.GetNewTokenPair(ctx, id, models.Confirmation{}, models.ClientInfo{})
+	assert.Nil(err)
+	parent.End()
+
+	// 2
+	_, err = service.GetSession(context.Background(), "invalid", models.ClientInfo{})
+	assert.Equal(e.ErrInvalidGUID, err)
+
+	spans := exporter.GetSpans()
+	if !assert.Len(spans, 3) {
+		return
+	}
+	assert.Equal("usecase.GetNewTokenPair", spans[0].Name)
+	assert.Equal(parent.SpanContext().SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(spans[0].SpanContext.SpanID(), repoSpan.SpanID())
+	assert.Equal("usecase.GetSession", spans[2].Name)
+	assert.Equal(codes.Error, spans[2].Status.Code)
+	repo.AssertExpectations(t)
+}
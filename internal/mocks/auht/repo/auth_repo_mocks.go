@@ -1,4 +1,4 @@
-// Code generated by mockery v2.42.3. DO NOT EDIT.
+// Code generated by mockery v2.53.7. DO NOT EDIT.
 
 package auth_repo_mocks
 
@@ -83,6 +83,24 @@ func (_m *Repository) GetToken(_a0 context.Context, _a1 models.RefreshToken) (*m
 	return r0, r1
 }
 
+// RotateToken provides a mock function with given fields: _a0, _a1, _a2
+func (_m *Repository) RotateToken(_a0 context.Context, _a1 string, _a2 models.RefreshToken) error {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RotateToken")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, models.RefreshToken) error); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // StoreToken provides a mock function with given fields: _a0, _a1
 func (_m *Repository) StoreToken(_a0 context.Context, _a1 models.RefreshToken) error {
 	ret := _m.Called(_a0, _a1)
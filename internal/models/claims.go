@@ -0,0 +1,44 @@
+package models
+
+// Values of the "typ" claim that tell access and refresh tokens apart.
+const (
+	TypAccessToken  = "access"
+	TypRefreshToken = "refresh"
+)
+
+// Confirmation is the "cnf" claim that binds a token to a client key.
+// JKT is the SHA-256 thumbprint of the DPoP key (RFC 9449),
+// X5T is the SHA-256 thumbprint of the client certificate (RFC 8705).
+type Confirmation struct {
+	JKT string `json:"jkt,omitempty"`
+	X5T string `json:"x5t#S256,omitempty"`
+}
+
+// Claim() returns the claim value or nil if the token is not bound.
+func (c Confirmation) Claim() map[string]any {
+	claim := map[string]any{}
+	if c.JKT != "" {
+		claim["jkt"] = c.JKT
+	}
+	if c.X5T != "" {
+		claim["x5t#S256"] = c.X5T
+	}
+	if len(claim) == 0 {
+		return nil
+	}
+	return claim
+}
+
+// PresentedWith() reports if the token bound by the confirmation is presented with the key it is bound to.
+// Presented is the key proven by the request: its DPoP proof and client certificate.
+func (c Confirmation) PresentedWith(presented Confirmation) bool {
+	return (c.JKT == "" || c.JKT == presented.JKT) && (c.X5T == "" || c.X5T == presented.X5T)
+}
+
+// ConfirmationFromClaims() extracts the "cnf" claim from token claims.
+func ConfirmationFromClaims(claims map[string]any) Confirmation {
+	cnf, _ := claims["cnf"].(map[string]any)
+	jkt, _ := cnf["jkt"].(string)
+	x5t, _ := cnf["x5t#S256"].(string)
+	return Confirmation{JKT: jkt, X5T: x5t}
+}
@@ -1,6 +1,16 @@
 package models
 
+import "time"
+
+// RefreshToken is a refresh token provided by the user or its hash stored in the database.
+// Previous, Pair and RotatedAt are kept only in the database: the hash of the rotated token
+// and the encrypted pair it was rotated to, so retries within the grace window get the same pair.
+// Session is the metadata of the session the token belongs to.
 type RefreshToken struct {
-	GUID        string `json:"guid"`
-	TokenString string `json:"refresh_token"`
+	GUID        string    `json:"guid"`
+	TokenString string    `json:"refresh_token"`
+	Previous    string    `json:"-"`
+	Pair        string    `json:"-"`
+	RotatedAt   time.Time `json:"-"`
+	Session     Session   `json:"-"`
 }
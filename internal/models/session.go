@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Session describes when and where the refresh token of a user was issued and last used.
+// It is stored next to the token hash and carried over by rotation.
+type Session struct {
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Rotations  int       `json:"rotations"`
+	ClientIP   string    `json:"client_ip"`
+	UserAgent  string    `json:"user_agent"`
+	ClientID   string    `json:"client_id,omitempty"`
+}
+
+// ClientInfo describes the client of the current request.
+// ClientID is the id of the authenticated client, it is empty if the client did not authenticate.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	ClientID  string
+}
@@ -0,0 +1,19 @@
+package models
+
+// Token type identifiers of RFC 8693.
+const (
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// TokenExchange is a token exchange request (RFC 8693) with already validated tokens.
+// Subject and Actor hold claims of the subject and actor tokens, Actor is nil for impersonation.
+// Confirmation binds the issued token to the client certificate.
+type TokenExchange struct {
+	ClientID     string
+	Subject      map[string]any
+	Actor        map[string]any
+	Audience     []string
+	Scope        []string
+	Confirmation Confirmation
+}
@@ -0,0 +1,310 @@
+// Package audit keeps the security audit trail: token issuance, refresh, reuse detection,
+// revocation and admin actions.
+// Entries are hash-chained (every entry contains the hash of the previous one and its own hash
+// covers all of its fields), so any modified, removed or reordered entry breaks the chain and is
+// found by Verify(). Entries never contain tokens.
+package audit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/logging"
+	"github.com/VanLavr/auth/internal/pkg/metrics"
+)
+
+// Audited events.
+const (
+	EventTokenIssued    = "token_issued"
+	EventTokenRefreshed = "token_refreshed"
+	EventTokenReuse     = "token_reuse_detected"
+	EventTokenRevoked   = "token_revoked"
+	EventTokenExchanged = "token_exchanged"
+	EventSessionViewed  = "session_viewed"
+	EventAuditQueried   = "audit_queried"
+)
+
+// Entry is a record of the audit trail.
+// Seq numbers entries from 1 without gaps, PrevHash is the hash of the previous entry (empty for the first one).
+// Actor is the authenticated client that caused the event.
+type Entry struct {
+	Seq       int64             `json:"seq" bson:"seq"`
+	Time      time.Time         `json:"time" bson:"time"`
+	Event     string            `json:"event" bson:"event"`
+	GUID      string            `json:"guid,omitempty" bson:"guid,omitempty"`
+	Actor     string            `json:"actor,omitempty" bson:"actor,omitempty"`
+	ClientIP  string            `json:"client_ip,omitempty" bson:"client_ip,omitempty"`
+	RequestID string            `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty" bson:"details,omitempty"`
+	PrevHash  string            `json:"prev_hash" bson:"prev_hash"`
+	Hash      string            `json:"hash" bson:"hash"`
+}
+
+// Query selects entries of the GUID (any if empty) recorded in [From, To) (unbounded if zero).
+// Limit is the max number of returned entries (unlimited if zero).
+type Query struct {
+	GUID  string
+	From  time.Time
+	To    time.Time
+	Limit int
+}
+
+// Match() reports if the entry is selected by the query.
+func (q Query) Match(entry Entry) bool {
+	return (q.GUID == "" || entry.GUID == q.GUID) &&
+		(q.From.IsZero() || !entry.Time.Before(q.From)) &&
+		(q.To.IsZero() || entry.Time.Before(q.To))
+}
+
+// Sink stores the chain.
+type Sink interface {
+	// Append() stores consecutive entries in order and returns how many were stored,
+	// it stops with ErrAuditConflict at an entry whose Seq is already stored.
+	Append(context.Context, []Entry) (int, error)
+	// Last() returns the last entry or nil if the chain is empty.
+	Last(context.Context) (*Entry, error)
+	// Scan() calls fn for the entries selected by the query in chain order and stops on its error.
+	Scan(context.Context, Query, func(Entry) error) error
+	Close(context.Context) error
+}
+
+// Open() opens the sink selected in config, the discarding one if audit is disabled.
+func Open(ctx context.Context, cfg *config.Config) (Sink, error) {
+	switch cfg.AuditSink {
+	case config.AuditSinkMemory:
+		return NewMemorySink(), nil
+	case config.AuditSinkFile:
+		return OpenFileSink(cfg.AuditFile)
+	case config.AuditSinkMongo:
+		return OpenMongoSink(ctx, cfg.Mongo, cfg.DBName, cfg.AuditCollection)
+	default:
+		return Discard{}, nil
+	}
+}
+
+// Attempts to append entries when other replicas append to the same chain.
+const appendAttempts = 5
+
+const (
+	// Entries waiting to be written, recording blocks when the buffer is full.
+	bufferSize = 1024
+	// Entries appended to the sink at once (a single fsync or round trip).
+	batchSize = 128
+)
+
+// Logger appends entries to the chain of the sink.
+// Entries are written in background by a single writer in the order they were recorded,
+// entries recorded meanwhile are appended together. Listeners are called by the writer as well,
+// so they do not hold up recording.
+type Logger struct {
+	sink      Sink
+	listeners []func(context.Context, Entry)
+
+	// mu guards closing of the queue.
+	mu     sync.RWMutex
+	closed bool
+	queue  chan queued
+	done   chan struct{}
+	// Last appended entry, loaded from the sink on first use and after conflicts (used by the writer only).
+	head *Entry
+}
+
+// queued is a recorded entry or a flush waiting for the entries recorded before it.
+type queued struct {
+	ctx     context.Context
+	entry   Entry
+	flushed chan struct{}
+}
+
+// New() creates the logger and starts its writer, Close() stops it.
+func New(sink Sink) *Logger {
+	l := &Logger{sink: sink, queue: make(chan queued, bufferSize), done: make(chan struct{})}
+	go l.write()
+	return l
+}
+
+// Subscribe() makes the listener to be called with every recorded entry after it is appended, even if it could not be written.
+// Listeners are called by the writer in the order entries were recorded and have to be subscribed before entries are recorded.
+func (l *Logger) Subscribe(listener func(context.Context, Entry)) {
+	l.listeners = append(l.listeners, listener)
+}
+
+// Record() queues the entry to be chained and appended, time and request id are taken from the moment and the context.
+// Failures do not fail the audited action: they are logged and counted.
+func (l *Logger) Record(ctx context.Context, entry Entry) {
+	entry.Time = time.Now()
+	entry.RequestID = logging.RequestID(ctx)
+
+	// The entry is written even if the request is cancelled meanwhile.
+	ctx = context.WithoutCancel(ctx)
+	if !l.enqueue(queued{ctx: ctx, entry: entry}) {
+		slog.ErrorContext(ctx, "audit entry is lost: audit log is closed", "event", entry.Event, "guid", entry.GUID)
+		metrics.AuditFailures.Inc()
+	}
+}
+
+// Flush() waits until the entries recorded before it are written (or lost).
+func (l *Logger) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	if !l.enqueue(queued{flushed: flushed}) {
+		return nil
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close() writes the queued entries and stops the writer, the sink is left open.
+func (l *Logger) Close(ctx context.Context) error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Logger) enqueue(item queued) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return false
+	}
+	l.queue <- item
+	return true
+}
+
+// Take the queued entries (at most a batch).
+// Append them, the ones that could not be written are lost.
+// Notify listeners.
+// Release flushes waiting for them.
+func (l *Logger) write() {
+	defer close(l.done)
+	for item := range l.queue {
+		// Take the queued entries (at most a batch).
+		items := []queued{item}
+	collect:
+		for len(items) < batchSize {
+			select {
+			case next, ok := <-l.queue:
+				if !ok {
+					break collect
+				}
+				items = append(items, next)
+			default:
+				break collect
+			}
+		}
+
+		// Append them, the ones that could not be written are lost.
+		var (
+			pending []queued
+			entries []Entry
+		)
+		for _, item := range items {
+			if item.flushed == nil {
+				pending = append(pending, item)
+				entries = append(entries, item.entry)
+			}
+		}
+		if stored, err := l.append(context.Background(), entries); err != nil {
+			for _, item := range pending[stored:] {
+				slog.ErrorContext(item.ctx, "audit entry is lost: "+err.Error(), "event", item.entry.Event, "guid", item.entry.GUID)
+				metrics.AuditFailures.Inc()
+			}
+		}
+
+		// Notify listeners.
+		for _, item := range pending {
+			for _, listener := range l.listeners {
+				listener(item.ctx, item.entry)
+			}
+		}
+
+		// Release flushes waiting for them.
+		for _, item := range items {
+			if item.flushed != nil {
+				close(item.flushed)
+			}
+		}
+	}
+}
+
+// Load the head if it is unknown.
+// Chain the entries to the head and append them.
+// Reload the head and retry the rest if another replica appended first.
+func (l *Logger) append(ctx context.Context, entries []Entry) (int, error) {
+	stored := 0
+	for attempt := 1; stored < len(entries); attempt++ {
+		// Load the head if it is unknown.
+		if l.head == nil {
+			head, err := l.sink.Last(ctx)
+			if err != nil {
+				return stored, err
+			}
+			if head == nil {
+				head = &Entry{}
+			}
+			l.head = head
+		}
+
+		// Chain the entries to the head and append them.
+		chained := make([]Entry, 0, len(entries)-stored)
+		prev := *l.head
+		for _, entry := range entries[stored:] {
+			prev = chain(prev, entry)
+			chained = append(chained, prev)
+		}
+		n, err := l.sink.Append(ctx, chained)
+		if n > 0 {
+			stored += n
+			l.head = &chained[n-1]
+		}
+		if err == nil {
+			continue
+		}
+
+		// Reload the head and retry the rest if another replica appended first.
+		l.head = nil
+		if !errors.Is(err, e.ErrAuditConflict) || attempt == appendAttempts {
+			return stored, err
+		}
+	}
+	return stored, nil
+}
+
+// Query() returns the entries selected by the query in chain order,
+// entries recorded before it are written first.
+func (l *Logger) Query(ctx context.Context, q Query) ([]Entry, error) {
+	if err := l.Flush(ctx); err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	errLimit := errors.New("limit is reached")
+	err := l.sink.Scan(ctx, q, func(entry Entry) error {
+		entries = append(entries, entry)
+		if q.Limit > 0 && len(entries) == q.Limit {
+			return errLimit
+		}
+		return nil
+	})
+	if err != nil && err != errLimit {
+		return nil, err
+	}
+	return entries, nil
+}
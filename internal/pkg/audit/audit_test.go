@@ -0,0 +1,252 @@
+package audit_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemorySink(t *testing.T) {
+	testSink(t, audit.NewMemorySink())
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := audit.OpenFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSink(t, sink)
+	assert.Nil(t, sink.Close(context.Background()))
+
+	// Chain is continued after reopening.
+	sink, err = audit.OpenFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close(context.Background())
+	logger := audit.New(sink)
+	logger.Record(context.Background(), audit.Entry{Event: audit.EventTokenIssued})
+	assert.Nil(t, logger.Close(context.Background()))
+	head, err := audit.Verify(context.Background(), sink)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 6, head.Seq)
+}
+
+func TestMongoSink(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("set MONGO_TEST_URI to test against running mongo")
+	}
+	ctx := context.Background()
+	collection := "audit_" + strings.ReplaceAll(time.Now().Format("150405.000000"), ".", "")
+	sink, err := audit.OpenMongoSink(ctx, uri, "auth_test", collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close(ctx)
+	testSink(t, sink)
+}
+
+// Testcases:
+// 1) recorded entries form a valid chain
+// 2) entries are queried by GUID and time range with limit
+// 3) loggers of replicas sharing the sink append to the same chain
+func testSink(t *testing.T, sink audit.Sink) {
+	assert := assert.New(t)
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	logger := audit.New(sink)
+
+	// 1
+	start := time.Now().Add(-time.Second)
+	logger.Record(ctx, audit.Entry{Event: audit.EventTokenIssued, GUID: "a", Actor: "client", Details: map[string]string{"grant": "token_pair"}})
+	logger.Record(ctx, audit.Entry{Event: audit.EventTokenRefreshed, GUID: "b"})
+	logger.Record(ctx, audit.Entry{Event: audit.EventTokenReuse, GUID: "a"})
+
+	assert.Nil(logger.Flush(ctx))
+	head, err := audit.Verify(ctx, sink)
+	assert.Nil(err)
+	assert.EqualValues(3, head.Seq)
+
+	// 2
+	entries, err := logger.Query(ctx, audit.Query{GUID: "a"})
+	assert.Nil(err)
+	if assert.Len(entries, 2) {
+		assert.Equal(audit.EventTokenIssued, entries[0].Event)
+		assert.Equal("req-1", entries[0].RequestID)
+		assert.Equal("token_pair", entries[0].Details["grant"])
+		assert.Equal(audit.EventTokenReuse, entries[1].Event)
+	}
+	entries, err = logger.Query(ctx, audit.Query{From: start, To: time.Now().Add(time.Second), Limit: 1})
+	assert.Nil(err)
+	assert.Len(entries, 1)
+	entries, err = logger.Query(ctx, audit.Query{To: start})
+	assert.Nil(err)
+	assert.Empty(entries)
+
+	// 3
+	replica := audit.New(sink)
+	replica.Record(ctx, audit.Entry{Event: audit.EventAuditQueried})
+	assert.Nil(replica.Flush(ctx))
+	logger.Record(ctx, audit.Entry{Event: audit.EventSessionViewed})
+	assert.Nil(logger.Flush(ctx))
+	head, err = audit.Verify(ctx, sink)
+	assert.Nil(err)
+	assert.EqualValues(5, head.Seq)
+	assert.Equal(audit.EventSessionViewed, head.Event)
+}
+
+// Testcases:
+// 1) modified entry is detected
+// 2) removed entry is detected
+// 3) entry rehashed after modification does not link to the next one
+func TestVerifyTampering(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	record := func() *audit.MemorySink {
+		sink := audit.NewMemorySink()
+		logger := audit.New(sink)
+		for _, guid := range []string{"a", "b", "c"} {
+			logger.Record(ctx, audit.Entry{Event: audit.EventTokenIssued, GUID: guid})
+		}
+		assert.Nil(logger.Close(ctx))
+		return sink
+	}
+
+	// 1
+	sink := record()
+	sink.Entries()[1].GUID = "x"
+	_, err := audit.Verify(ctx, sink)
+	assert.ErrorIs(err, e.ErrAuditChainBroken)
+	assert.ErrorContains(err, "entry 2 was modified")
+
+	// 2
+	sink = record()
+	tampered := audit.NewMemorySink()
+	for _, entry := range sink.Entries() {
+		if entry.Seq != 2 {
+			entry.Seq = int64(len(tampered.Entries())) + 1
+			_, err := tampered.Append(ctx, []audit.Entry{entry})
+			assert.Nil(err)
+		}
+	}
+	_, err = audit.Verify(ctx, tampered)
+	assert.ErrorIs(err, e.ErrAuditChainBroken)
+
+	// 3
+	sink = record()
+	modified := sink.Entries()[0]
+	modified.GUID = "x"
+	rehashed := audit.NewMemorySink()
+	logger := audit.New(rehashed)
+	logger.Record(ctx, modified)
+	assert.Nil(logger.Close(ctx))
+	_, err = rehashed.Append(ctx, sink.Entries()[1:])
+	assert.Nil(err)
+	_, err = audit.Verify(ctx, rehashed)
+	assert.ErrorContains(err, "entry 2 does not link to the previous entry")
+}
+
+// Sink blocking appends until it is released and counting them.
+type slowSink struct {
+	*audit.MemorySink
+	release chan struct{}
+	appends atomic.Int32
+}
+
+func (s *slowSink) Append(ctx context.Context, entries []audit.Entry) (int, error) {
+	<-s.release
+	s.appends.Add(1)
+	return s.MemorySink.Append(ctx, entries)
+}
+
+// Testcases:
+// 1) recording does not wait for the sink
+// 2) entries recorded while the sink is busy are appended together in the recorded order
+// 3) entries recorded after close are not written
+func TestLoggerBatching(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	sink := &slowSink{MemorySink: audit.NewMemorySink(), release: make(chan struct{})}
+	logger := audit.New(sink)
+
+	// 1
+	recorded := make(chan struct{})
+	go func() {
+		defer close(recorded)
+		for i := 0; i < 10; i++ {
+			logger.Record(ctx, audit.Entry{Event: audit.EventTokenIssued, GUID: strconv.Itoa(i)})
+		}
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(time.Second):
+		t.Fatal("recording waits for the sink")
+	}
+
+	// 2
+	close(sink.release)
+	assert.Nil(logger.Close(ctx))
+	assert.LessOrEqual(sink.appends.Load(), int32(2))
+	head, err := audit.Verify(ctx, sink)
+	assert.Nil(err)
+	assert.EqualValues(10, head.Seq)
+	for i, entry := range sink.Entries() {
+		assert.Equal(strconv.Itoa(i), entry.GUID)
+	}
+
+	// 3
+	logger.Record(ctx, audit.Entry{Event: audit.EventTokenIssued})
+	assert.Len(sink.Entries(), 10)
+}
+
+// Testcases:
+// 1) recording does not wait for listeners
+// 2) listeners get every entry in the recorded order once it is appended
+func TestLoggerListeners(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	sink := audit.NewMemorySink()
+	logger := audit.New(sink)
+	release := make(chan struct{})
+	var notified []string
+	logger.Subscribe(func(ctx context.Context, entry audit.Entry) {
+		<-release
+		notified = append(notified, entry.GUID)
+	})
+
+	// 1
+	recorded := make(chan struct{})
+	go func() {
+		defer close(recorded)
+		for i := 0; i < 10; i++ {
+			logger.Record(ctx, audit.Entry{Event: audit.EventTokenIssued, GUID: strconv.Itoa(i)})
+		}
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(time.Second):
+		t.Fatal("recording waits for listeners")
+	}
+
+	// 2
+	close(release)
+	assert.Nil(logger.Close(ctx))
+	assert.Len(sink.Entries(), 10)
+	if assert.Len(notified, 10) {
+		for i, guid := range notified {
+			assert.Equal(strconv.Itoa(i), guid)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+)
+
+// Link the entry to the previous one and hash it.
+// Time is kept in UTC with millisecond precision, so it is the same after a round trip through any sink.
+func chain(prev, entry Entry) Entry {
+	entry.Seq = prev.Seq + 1
+	entry.Time = entry.Time.UTC().Truncate(time.Millisecond)
+	entry.PrevHash = prev.Hash
+	entry.Hash = hash(entry)
+	return entry
+}
+
+// SHA-256 of the JSON encoding of all fields but the hash (fields are encoded in declaration order, map keys sorted).
+func hash(entry Entry) string {
+	entry.Hash = ""
+	entry.Time = entry.Time.UTC()
+	encoded, _ := json.Marshal(entry)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify() checks the whole chain of the sink and returns its last entry (nil if the chain is empty).
+// Every entry has to follow the previous one, link to its hash and match its own hash.
+// Removing entries from the end can't be detected this way: compare the returned head with a previously noted one.
+func Verify(ctx context.Context, sink Sink) (*Entry, error) {
+	prev := Entry{}
+	var head *Entry
+	err := sink.Scan(ctx, Query{}, func(entry Entry) error {
+		switch {
+		case entry.Seq != prev.Seq+1:
+			return fmt.Errorf("%w: entry %d follows entry %d", e.ErrAuditChainBroken, entry.Seq, prev.Seq)
+		case entry.PrevHash != prev.Hash:
+			return fmt.Errorf("%w: entry %d does not link to the previous entry", e.ErrAuditChainBroken, entry.Seq)
+		case entry.Hash != hash(entry):
+			return fmt.Errorf("%w: entry %d was modified", e.ErrAuditChainBroken, entry.Seq)
+		}
+		prev = entry
+		head = &entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return head, nil
+}
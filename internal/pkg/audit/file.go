@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+)
+
+// Lines longer than this are not valid entries.
+const maxLineSize = 1 << 20
+
+// FileSink appends entries to a file as JSON lines, every appended batch is fsynced.
+// The file is opened in append-only mode and must have a single writer.
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+	last *Entry
+}
+
+// OpenFileSink() opens (or creates) the file and reads its last entry.
+func OpenFileSink(path string) (*FileSink, error) {
+	f := &FileSink{path: path}
+	err := f.Scan(context.Background(), Query{}, func(entry Entry) error {
+		f.last = &entry
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Append() writes the entries following the last one with a single write and fsync.
+func (f *FileSink) Append(ctx context.Context, entries []Entry) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var lastSeq int64
+	if f.last != nil {
+		lastSeq = f.last.Seq
+	}
+	var lines []byte
+	for i, entry := range entries {
+		if entry.Seq != lastSeq+int64(i)+1 {
+			return 0, e.ErrAuditConflict
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return 0, err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	if _, err := f.file.Write(lines); err != nil {
+		return 0, err
+	}
+	if err := f.file.Sync(); err != nil {
+		return 0, err
+	}
+	last := entries[len(entries)-1]
+	f.last = &last
+	return len(entries), nil
+}
+
+func (f *FileSink) Last(ctx context.Context) (*Entry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.last == nil {
+		return nil, nil
+	}
+	last := *f.last
+	return &last, nil
+}
+
+// Scan() reads the file from the beginning, a line which is not an entry breaks the scan.
+func (f *FileSink) Scan(ctx context.Context, q Query, fn func(Entry) error) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("%w: line %d is not an entry: %v", e.ErrAuditChainBroken, line, err)
+		}
+		if !q.Match(entry) {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (f *FileSink) Close(ctx context.Context) error {
+	return f.file.Close()
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"context"
+	"sync"
+
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+)
+
+// MemorySink keeps the chain in memory (for development and tests).
+type MemorySink struct {
+	mu      sync.RWMutex
+	entries []Entry
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (m *MemorySink) Append(ctx context.Context, entries []Entry) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, entry := range entries {
+		if entry.Seq != int64(len(m.entries))+1 {
+			return i, e.ErrAuditConflict
+		}
+		m.entries = append(m.entries, entry)
+	}
+	return len(entries), nil
+}
+
+func (m *MemorySink) Last(ctx context.Context) (*Entry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.entries) == 0 {
+		return nil, nil
+	}
+	last := m.entries[len(m.entries)-1]
+	return &last, nil
+}
+
+func (m *MemorySink) Scan(ctx context.Context, q Query, fn func(Entry) error) error {
+	m.mu.RLock()
+	entries := append([]Entry(nil), m.entries...)
+	m.mu.RUnlock()
+
+	for _, entry := range entries {
+		if !q.Match(entry) {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemorySink) Close(ctx context.Context) error {
+	return nil
+}
+
+// Entries() returns the stored entries, tests modify them to tamper with the chain.
+func (m *MemorySink) Entries() []Entry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.entries
+}
+
+// Discard drops entries, it is used when audit is disabled.
+type Discard struct{}
+
+func (Discard) Append(_ context.Context, entries []Entry) (int, error) { return len(entries), nil }
+func (Discard) Last(context.Context) (*Entry, error)                   { return nil, nil }
+func (Discard) Scan(context.Context, Query, func(Entry) error) error   { return nil }
+func (Discard) Close(context.Context) error                            { return nil }
@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"context"
+	"errors"
+
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoSink keeps entries in a collection, the unique index on seq makes concurrent replicas
+// append to the same chain one after another.
+type MongoSink struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+// Connect.
+// Ensure unique index on seq and index for queries by GUID and time.
+func OpenMongoSink(ctx context.Context, uri, database, collection string) (*MongoSink, error) {
+	// Connect.
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.WithoutCancel(ctx))
+		return nil, err
+	}
+
+	// Ensure unique index on seq and index for queries by GUID and time.
+	m := &MongoSink{client: client, collection: client.Database(database).Collection(collection)}
+	_, err = m.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "seq", Value: 1}}, Options: options.Index().SetName("seq_unique").SetUnique(true)},
+		{Keys: bson.D{{Key: "guid", Value: 1}, {Key: "time", Value: 1}}, Options: options.Index().SetName("guid_time")},
+	})
+	if err != nil {
+		client.Disconnect(context.WithoutCancel(ctx))
+		return nil, err
+	}
+	return m, nil
+}
+
+// Append() inserts the entries in order with a single round trip,
+// entries before the first failed one are stored.
+func (m *MongoSink) Append(ctx context.Context, entries []Entry) (int, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	documents := make([]any, len(entries))
+	for i, entry := range entries {
+		documents[i] = entry
+	}
+
+	_, err := m.collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(true))
+	if err == nil {
+		return len(entries), nil
+	}
+	stored := 0
+	var bulk mongo.BulkWriteException
+	if errors.As(err, &bulk) && len(bulk.WriteErrors) > 0 {
+		stored = bulk.WriteErrors[0].Index
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return stored, e.ErrAuditConflict
+	}
+	return stored, err
+}
+
+func (m *MongoSink) Last(ctx context.Context) (*Entry, error) {
+	var last Entry
+	err := m.collection.FindOne(ctx, bson.D{}, options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})).Decode(&last)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &last, nil
+}
+
+func (m *MongoSink) Scan(ctx context.Context, q Query, fn func(Entry) error) error {
+	filter := bson.M{}
+	if q.GUID != "" {
+		filter["guid"] = q.GUID
+	}
+	if !q.From.IsZero() || !q.To.IsZero() {
+		period := bson.M{}
+		if !q.From.IsZero() {
+			period["$gte"] = q.From
+		}
+		if !q.To.IsZero() {
+			period["$lt"] = q.To
+		}
+		filter["time"] = period
+	}
+
+	cursor, err := m.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}).SetProjection(bson.M{"_id": 0}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry Entry
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (m *MongoSink) Close(ctx context.Context) error {
+	return m.client.Disconnect(ctx)
+}
@@ -0,0 +1,154 @@
+// Package certs serves TLS certificates and client CA bundles that can be replaced on disk without restart.
+package certs
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often files are checked for changes.
+const checkInterval = 10 * time.Second
+
+var ErrNoCertificates = errors.New("client CA bundle contains no certificates")
+
+// Reloader keeps the server certificate and client CA pool loaded from the files
+// and reloads them when the files change. caFile is optional, client certificates are not requested without it.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time
+	checked time.Time
+}
+
+func New(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Load certificate and key.
+// Load client CA bundle if it is configured.
+// Replace current ones.
+func (r *Reloader) Reload() error {
+	slog.Debug("reload certs called")
+	modTime := r.latestModTime()
+
+	// Load certificate and key.
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	// Load client CA bundle if it is configured.
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		bundle, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return ErrNoCertificates
+		}
+	}
+
+	// Replace current ones.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.pool = pool
+	r.modTime = modTime
+	r.checked = time.Now()
+
+	return nil
+}
+
+// TLSConfig() returns a server config that picks up the current certificate and CA pool on every handshake.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			r.reloadIfChanged(hello.Context())
+
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if r.pool != nil {
+				cfg.ClientCAs = r.pool
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// Check files not more often than checkInterval.
+// Reload them if they were modified, keep the old ones if new can not be loaded.
+func (r *Reloader) reloadIfChanged(ctx context.Context) {
+	// Check files not more often than checkInterval.
+	r.mu.Lock()
+	if time.Since(r.checked) < checkInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.checked = time.Now()
+	loaded := r.modTime
+	r.mu.Unlock()
+
+	// Reload them if they were modified, keep the old ones if new can not be loaded.
+	if !r.latestModTime().After(loaded) {
+		return
+	}
+	if err := r.Reload(); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return
+	}
+	slog.InfoContext(ctx, "tls certificates reloaded")
+}
+
+func (r *Reloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// Thumbprint() returns base64url encoded SHA-256 hash of the DER certificate (x5t#S256 of RFC 8705).
+func Thumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
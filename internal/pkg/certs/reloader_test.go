@@ -0,0 +1,96 @@
+package certs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/certs"
+	"github.com/stretchr/testify/assert"
+)
+
+// Write a self-signed certificate with provided common name and its key.
+func writeCert(t *testing.T, dir, cn string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ca.pem"), certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedName(t *testing.T, cfg *tls.Config) string {
+	served, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(served.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestReload(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	writeCert(t, dir, "first")
+
+	reloader, err := certs.New(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem"))
+	assert.Nil(err)
+
+	cfg := reloader.TLSConfig()
+	assert.Equal("first", servedName(t, cfg))
+
+	served, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+	assert.Nil(err)
+	assert.Equal(tls.VerifyClientCertIfGiven, served.ClientAuth)
+	assert.NotNil(served.ClientCAs)
+
+	// Replaced files are picked up after explicit reload.
+	writeCert(t, dir, "second")
+	assert.Nil(reloader.Reload())
+	assert.Equal("second", servedName(t, cfg))
+
+	// Broken files are rejected and the current certificate is kept.
+	assert.Nil(os.WriteFile(filepath.Join(dir, "key.pem"), []byte("broken"), 0o600))
+	assert.NotNil(reloader.Reload())
+	assert.Equal("second", servedName(t, cfg))
+}
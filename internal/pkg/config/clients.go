@@ -0,0 +1,87 @@
+package config
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+)
+
+// Client is an OAuth client registered with the service.
+// SecretHash is a SHA512 hash of the client secret (same as refresh tokens are stored).
+// TLS fields let the client authenticate with a certificate instead of the secret (RFC 8705),
+// the certificate has to match the subject DN or one of the SAN values.
+// Admin clients may query the audit log.
+type Client struct {
+	ID         string         `json:"client_id"`
+	SecretHash string         `json:"client_secret_hash"`
+	TLSSubject string         `json:"tls_client_auth_subject_dn"`
+	TLSDNS     string         `json:"tls_client_auth_san_dns"`
+	TLSURI     string         `json:"tls_client_auth_san_uri"`
+	TLSIP      string         `json:"tls_client_auth_san_ip"`
+	TLSEmail   string         `json:"tls_client_auth_san_email"`
+	Exchange   ExchangePolicy `json:"token_exchange"`
+	Admin      bool           `json:"admin"`
+}
+
+// ExchangePolicy describes which tokens a client may obtain via token exchange.
+// Impersonation allows exchanging a subject token without an actor token,
+// Delegation allows exchanging with an actor token (only for listed Actors if any).
+// Issued tokens are restricted to the listed Audiences and Scopes.
+type ExchangePolicy struct {
+	Impersonation bool     `json:"impersonation"`
+	Delegation    bool     `json:"delegation"`
+	Actors        []string `json:"actors"`
+	Audiences     []string `json:"audiences"`
+	Scopes        []string `json:"scopes"`
+}
+
+// Read clients file.
+// Decode a list of clients.
+// Index them by id.
+func loadClients(path string) (map[string]Client, error) {
+	clients := make(map[string]Client)
+	if path == "" {
+		return clients, nil
+	}
+
+	// Read clients file.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode a list of clients.
+	var list []Client
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+
+	// Index them by id.
+	for _, c := range list {
+		clients[c.ID] = c
+	}
+
+	return clients, nil
+}
+
+// MatchCertificate() checks if the verified client certificate belongs to the client.
+func (c Client) MatchCertificate(cert *x509.Certificate) bool {
+	switch {
+	case c.TLSSubject != "":
+		return cert.Subject.String() == c.TLSSubject
+	case c.TLSDNS != "":
+		return slices.Contains(cert.DNSNames, c.TLSDNS)
+	case c.TLSURI != "":
+		return slices.ContainsFunc(cert.URIs, func(u *url.URL) bool { return u.String() == c.TLSURI })
+	case c.TLSIP != "":
+		ip := net.ParseIP(c.TLSIP)
+		return ip != nil && slices.ContainsFunc(cert.IPAddresses, ip.Equal)
+	case c.TLSEmail != "":
+		return slices.Contains(cert.EmailAddresses, c.TLSEmail)
+	default:
+		return false
+	}
+}
@@ -2,24 +2,100 @@ package config
 
 import (
 	"log"
+	"log/slog"
+	"net/netip"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/VanLavr/auth/internal/pkg/realip"
 	"github.com/joho/godotenv"
 )
 
+// Supported token storages.
+const (
+	StoreMongo    = "mongo"
+	StoreMemory   = "memory"
+	StorePostgres = "postgres"
+	StoreBolt     = "bolt"
+	StoreRedis    = "redis"
+)
+
+// Supported trace exporters (tracing is a no-op without exporter).
+const (
+	TracesExporterNone = "none"
+	TracesExporterOTLP = "otlp"
+)
+
+// Supported audit sinks (audit is disabled without sink).
+const (
+	AuditSinkNone   = "none"
+	AuditSinkMemory = "memory"
+	AuditSinkFile   = "file"
+	AuditSinkMongo  = "mongo"
+)
+
+// Supported log formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
 type Config struct {
-	Addr           string
-	ReadTimeout    time.Duration
-	WriteTimeout   time.Duration
-	MaxHeaderBytes int
-	Secret         string
-	AccessExpTime  time.Duration
-	RefreshExpTime time.Duration
-	DBName         string
-	CollectionName string
-	Mongo          string
+	Addr                 string
+	LogLevel             slog.Level
+	LogFormat            string
+	MetricsAddr          string
+	TracesExporter       string
+	ReadTimeout          time.Duration
+	WriteTimeout         time.Duration
+	MaxHeaderBytes       int
+	Secret               string
+	AccessExpTime        time.Duration
+	RefreshExpTime       time.Duration
+	RefreshGrace         time.Duration
+	DBName               string
+	CollectionName       string
+	Mongo                string
+	Store                string
+	Postgres             string
+	PostgresMaxConns     int
+	PostgresMinConns     int
+	PostgresConnLifetime time.Duration
+	PostgresConnIdleTime time.Duration
+	BoltFile             string
+	BoltSweepInterval    time.Duration
+	BoltCompactInterval  time.Duration
+	Redis                string
+	SkipMigrations       bool
+	ConnectTimeout       time.Duration
+	OperationTimeout     time.Duration
+	PingInterval         time.Duration
+	ShutdownDelay        time.Duration
+	ShutdownTimeout      time.Duration
+	JanitorInterval      time.Duration
+	JanitorBatch         int
+	RevokedRetention     time.Duration
+	TrustedProxies       []netip.Prefix
+	Clients              map[string]Client
+	DPoPNonce            bool
+	DPoPWindow           time.Duration
+	TLSCert              string
+	TLSKey               string
+	TLSClientCA          string
+	AccessFormat         string
+	RefreshFormat        string
+	PasetoSecretKey      string
+	PasetoLocalKey       string
+	JWE                  *JWE
+	AuditSink            string
+	AuditFile            string
+	AuditCollection      string
+	Webhooks             []Webhook
+	WebhookQueue         string
+	WebhookAttempts      int
+	WebhookBackoff       time.Duration
+	WebhookTimeout       time.Duration
 }
 
 func New() *Config {
@@ -57,16 +133,246 @@ func New() *Config {
 		log.Fatal(err)
 	}
 
+	grace, err := intEnv("REFRESH_GRACE", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	clients, err := loadClients(os.Getenv("CLIENTS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dpopNonce, err := boolEnv("DPOP_NONCE", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dpopWindow, err := intEnv("DPOP_WINDOW", 60)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	jwe, err := loadJWE(os.Getenv("JWE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	webhooks, err := loadWebhooks(os.Getenv("WEBHOOKS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, format := range []string{os.Getenv("ACCESS_FORMAT"), os.Getenv("REFRESH_FORMAT")} {
+		if format != "" && format != "jwt" && format != "paseto" {
+			log.Fatalf("unknown token format %q", format)
+		}
+	}
+
+	var logLevel slog.Level
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+			log.Fatalf("unknown log level %q", level)
+		}
+	}
+
+	logFormat := os.Getenv("LOG_FORMAT")
+	if logFormat != "" && logFormat != LogFormatText && logFormat != LogFormatJSON {
+		log.Fatalf("unknown log format %q", logFormat)
+	}
+
+	auditSink := os.Getenv("AUDIT_SINK")
+	if auditSink != "" && auditSink != AuditSinkNone && auditSink != AuditSinkMemory && auditSink != AuditSinkFile && auditSink != AuditSinkMongo {
+		log.Fatalf("unknown audit sink %q", auditSink)
+	}
+	if auditSink == AuditSinkFile && os.Getenv("AUDIT_FILE") == "" {
+		log.Fatal("AUDIT_FILE must be set for file audit sink")
+	}
+	auditCollection := os.Getenv("AUDIT_COLLECTION")
+	if auditCollection == "" {
+		auditCollection = os.Getenv("COLLNAME") + "_audit"
+	}
+
+	tracesExporter := os.Getenv("OTEL_TRACES_EXPORTER")
+	if tracesExporter != "" && tracesExporter != TracesExporterNone && tracesExporter != TracesExporterOTLP {
+		log.Fatalf("unknown traces exporter %q", tracesExporter)
+	}
+
+	store := os.Getenv("STORE")
+	if store != "" && store != StoreMongo && store != StoreMemory && store != StorePostgres && store != StoreBolt && store != StoreRedis {
+		log.Fatalf("unknown store %q", store)
+	}
+
+	pgMaxConns, err := intEnv("PG_MAX_CONNS", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pgMinConns, err := intEnv("PG_MIN_CONNS", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pgLifetime, err := intEnv("PG_CONN_LIFETIME", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pgIdleTime, err := intEnv("PG_CONN_IDLE_TIME", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	boltSweep, err := intEnv("BOLT_SWEEP_INTERVAL", 60)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	boltCompact, err := intEnv("BOLT_COMPACT_INTERVAL", 3600)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	migrate, err := boolEnv("MIGRATE", true)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	connectTimeout, err := intEnv("CONNECT_TIMEOUT", 60)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	operationTimeout, err := intEnv("OPERATION_TIMEOUT", 5)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pingInterval, err := intEnv("PING_INTERVAL", 10)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	shutdownDelay, err := intEnv("SHUTDOWN_DELAY", 5)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	shutdownTimeout, err := intEnv("SHUTDOWN_TIMEOUT", 30)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	janitorInterval, err := intEnv("JANITOR_INTERVAL", 300)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	janitorBatch, err := intEnv("JANITOR_BATCH", 1000)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	webhookAttempts, err := intEnv("WEBHOOK_ATTEMPTS", 8)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	webhookBackoff, err := intEnv("WEBHOOK_BACKOFF", 10)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	webhookTimeout, err := intEnv("WEBHOOK_TIMEOUT", 5)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	retention, err := intEnv("REVOKED_RETENTION", 86400)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if retention < grace {
+		log.Fatalf("REVOKED_RETENTION (%d) must cover REFRESH_GRACE (%d)", retention, grace)
+	}
+
+	trustedProxies, err := realip.ParsePrefixes(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	return &Config{
-		Addr:           os.Getenv("ADDR"),
-		Secret:         os.Getenv("SECRET"),
-		DBName:         os.Getenv("DBNAME"),
-		CollectionName: os.Getenv("COLLNAME"),
-		ReadTimeout:    time.Second * time.Duration(readtimeout),
-		WriteTimeout:   time.Second * time.Duration(writetimeout),
-		MaxHeaderBytes: maxheaderbytes,
-		AccessExpTime:  time.Second * time.Duration(access),
-		RefreshExpTime: time.Second * time.Duration(refresh),
-		Mongo:          os.Getenv("MONGO"),
+		Addr:                 os.Getenv("ADDR"),
+		LogLevel:             logLevel,
+		LogFormat:            logFormat,
+		MetricsAddr:          os.Getenv("METRICS_ADDR"),
+		TracesExporter:       tracesExporter,
+		Secret:               os.Getenv("SECRET"),
+		DBName:               os.Getenv("DBNAME"),
+		CollectionName:       os.Getenv("COLLNAME"),
+		ReadTimeout:          time.Second * time.Duration(readtimeout),
+		WriteTimeout:         time.Second * time.Duration(writetimeout),
+		MaxHeaderBytes:       maxheaderbytes,
+		AccessExpTime:        time.Second * time.Duration(access),
+		RefreshExpTime:       time.Second * time.Duration(refresh),
+		RefreshGrace:         time.Second * time.Duration(grace),
+		Mongo:                os.Getenv("MONGO"),
+		Store:                store,
+		Postgres:             os.Getenv("POSTGRES"),
+		PostgresMaxConns:     pgMaxConns,
+		PostgresMinConns:     pgMinConns,
+		PostgresConnLifetime: time.Second * time.Duration(pgLifetime),
+		PostgresConnIdleTime: time.Second * time.Duration(pgIdleTime),
+		BoltFile:             os.Getenv("BOLT_FILE"),
+		BoltSweepInterval:    time.Second * time.Duration(boltSweep),
+		BoltCompactInterval:  time.Second * time.Duration(boltCompact),
+		Redis:                os.Getenv("REDIS"),
+		SkipMigrations:       !migrate,
+		ConnectTimeout:       time.Second * time.Duration(connectTimeout),
+		OperationTimeout:     time.Second * time.Duration(operationTimeout),
+		PingInterval:         time.Second * time.Duration(pingInterval),
+		ShutdownDelay:        time.Second * time.Duration(shutdownDelay),
+		ShutdownTimeout:      time.Second * time.Duration(shutdownTimeout),
+		JanitorInterval:      time.Second * time.Duration(janitorInterval),
+		JanitorBatch:         janitorBatch,
+		RevokedRetention:     time.Second * time.Duration(retention),
+		TrustedProxies:       trustedProxies,
+		Clients:              clients,
+		DPoPNonce:            dpopNonce,
+		DPoPWindow:           time.Second * time.Duration(dpopWindow),
+		TLSCert:              os.Getenv("TLS_CERT"),
+		TLSKey:               os.Getenv("TLS_KEY"),
+		TLSClientCA:          os.Getenv("TLS_CLIENT_CA"),
+		AccessFormat:         os.Getenv("ACCESS_FORMAT"),
+		RefreshFormat:        os.Getenv("REFRESH_FORMAT"),
+		PasetoSecretKey:      os.Getenv("PASETO_SECRET_KEY"),
+		PasetoLocalKey:       os.Getenv("PASETO_LOCAL_KEY"),
+		JWE:                  jwe,
+		AuditSink:            auditSink,
+		AuditFile:            os.Getenv("AUDIT_FILE"),
+		AuditCollection:      auditCollection,
+		Webhooks:             webhooks,
+		WebhookQueue:         os.Getenv("WEBHOOK_QUEUE"),
+		WebhookAttempts:      webhookAttempts,
+		WebhookBackoff:       time.Second * time.Duration(webhookBackoff),
+		WebhookTimeout:       time.Second * time.Duration(webhookTimeout),
+	}
+}
+
+// Optional int variable, def is used if it is not set.
+func intEnv(key string, def int) (int, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// Optional bool variable, def is used if it is not set.
+func boolEnv(key string, def bool) (bool, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return def, nil
 	}
+	return strconv.ParseBool(value)
 }
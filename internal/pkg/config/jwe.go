@@ -0,0 +1,43 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// JWE describes which tokens are encrypted and which keys the service decrypts them with.
+// Tokens for the listed Audiences are encrypted to their recipients, tokens without audience
+// (token pairs) are encrypted to Default if it is set. DecryptionKeys are PEM private key files.
+type JWE struct {
+	DecryptionKeys []string                `json:"decryption_keys"`
+	Default        *JWERecipient           `json:"default"`
+	Audiences      map[string]JWERecipient `json:"audiences"`
+}
+
+// JWERecipient is a PEM public key file and key management algorithm (RSA-OAEP-256 or ECDH-ES).
+type JWERecipient struct {
+	Algorithm string `json:"alg"`
+	KeyFile   string `json:"key"`
+}
+
+// Read jwe config file.
+// Decode it.
+func loadJWE(path string) (*JWE, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	// Read jwe config file.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode it.
+	var jwe JWE
+	if err := json.Unmarshal(data, &jwe); err != nil {
+		return nil, err
+	}
+
+	return &jwe, nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+)
+
+// Webhook is a subscription of an endpoint to authentication events.
+// Payloads are signed with HMAC-SHA256 of the Secret, Events filters delivered events (all if empty).
+type Webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// Read webhooks file.
+// Decode a list of subscriptions.
+// Check that every subscription has an id, an absolute http(s) url and a secret.
+func loadWebhooks(path string) ([]Webhook, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	// Read webhooks file.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode a list of subscriptions.
+	var list []Webhook
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+
+	// Check that every subscription has an id, an absolute http(s) url and a secret.
+	ids := make(map[string]bool)
+	for _, w := range list {
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook %q: invalid url %q", w.ID, w.URL)
+		}
+		if w.ID == "" || ids[w.ID] {
+			return nil, fmt.Errorf("webhook id %q is empty or not unique", w.ID)
+		}
+		if w.Secret == "" {
+			return nil, fmt.Errorf("webhook %q: secret is empty", w.ID)
+		}
+		ids[w.ID] = true
+	}
+
+	return list, nil
+}
+
+// Subscribed() reports if the event is delivered to the endpoint.
+func (w Webhook) Subscribed(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
@@ -0,0 +1,143 @@
+package dpop
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+)
+
+// RSA keys shorter than this are rejected (RFC 7518 section 3.3 requires at least 2048 bits).
+const minRSABits = 2048
+
+// Public JWK as it is provided in the proof header.
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+// Decode jwk from header value.
+// Reject private keys.
+func parseJWK(raw any) (*jwk, error) {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode jwk from header value.
+	var key jwk
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+
+	// Reject private keys.
+	if key.D != "" {
+		return nil, e.ErrInvalidDPoPProof
+	}
+
+	return &key, nil
+}
+
+// PublicKey() converts jwk to a key usable for signature verification, short RSA keys are not supported.
+func (k *jwk) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, e.ErrInvalidDPoPProof
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, e.ErrInvalidDPoPProof
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		if n.BitLen() < minRSABits {
+			return nil, e.ErrUnsupportedKey
+		}
+		exp, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !exp.IsInt64() {
+			return nil, e.ErrInvalidDPoPProof
+		}
+		return &rsa.PublicKey{N: n, E: int(exp.Int64())}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, e.ErrInvalidDPoPProof
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, e.ErrInvalidDPoPProof
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, e.ErrInvalidDPoPProof
+	}
+}
+
+// Thumbprint() computes RFC 7638 SHA-256 thumbprint of the key.
+// Only required members are hashed, json.Marshal sorts map keys lexicographically as the RFC requires.
+func (k *jwk) Thumbprint() (string, error) {
+	var members map[string]string
+	switch k.Kty {
+	case "EC":
+		members = map[string]string{"crv": k.Crv, "kty": k.Kty, "x": k.X, "y": k.Y}
+	case "RSA":
+		members = map[string]string{"e": k.E, "kty": k.Kty, "n": k.N}
+	case "OKP":
+		members = map[string]string{"crv": k.Crv, "kty": k.Kty, "x": k.X}
+	default:
+		return "", e.ErrInvalidDPoPProof
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, e.ErrInvalidDPoPProof
+	}
+	return new(big.Int).SetBytes(b), nil
+}
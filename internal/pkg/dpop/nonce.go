@@ -0,0 +1,60 @@
+package dpop
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"time"
+)
+
+// Server supplied nonces are stateless: issue time signed with the server secret.
+// Nonce is valid for lifetime after it was issued.
+type nonceSource struct {
+	secret   []byte
+	lifetime time.Duration
+}
+
+func newNonceSource(secret string, lifetime time.Duration) *nonceSource {
+	return &nonceSource{secret: []byte(secret), lifetime: lifetime}
+}
+
+// Put issue time.
+// Sign it.
+// Encode both.
+func (n *nonceSource) Issue() string {
+	// Put issue time.
+	data := binary.BigEndian.AppendUint64(nil, uint64(time.Now().Unix()))
+
+	// Sign it.
+	// Encode both.
+	return base64.RawURLEncoding.EncodeToString(append(data, n.sign(data)...))
+}
+
+// Decode nonce.
+// Check signature.
+// Check if it is not expired.
+func (n *nonceSource) Valid(nonce string) bool {
+	// Decode nonce.
+	raw, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(raw) <= 8 {
+		return false
+	}
+	data, sig := raw[:8], raw[8:]
+
+	// Check signature.
+	if !hmac.Equal(sig, n.sign(data)) {
+		return false
+	}
+
+	// Check if it is not expired.
+	issued := time.Unix(int64(binary.BigEndian.Uint64(data)), 0)
+	return time.Since(issued) <= n.lifetime
+}
+
+func (n *nonceSource) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write([]byte("dpop-nonce"))
+	mac.Write(data)
+	return mac.Sum(nil)[:16]
+}
@@ -0,0 +1,144 @@
+// Package dpop verifies DPoP proofs (RFC 9449) that bind tokens to a client key.
+package dpop
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const proofType = "dpop+jwt"
+
+// Asymmetric algorithms allowed for proofs.
+var algorithms = []string{"ES256", "ES384", "ES512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "EdDSA"}
+
+// Window is the allowed difference between proof iat and server time.
+// Nonces are nil if server supplied nonces are not required.
+type Verifier struct {
+	window time.Duration
+	nonces *nonceSource
+	seen   *replayCache
+}
+
+func New(secret string, window time.Duration, requireNonce bool) *Verifier {
+	v := &Verifier{
+		window: window,
+		seen:   newReplayCache(),
+	}
+	if requireNonce {
+		v.nonces = newNonceSource(secret, window)
+	}
+
+	return v
+}
+
+// Nonce() returns a fresh server nonce or an empty string if nonces are not required.
+func (v *Verifier) Nonce() string {
+	if v.nonces == nil {
+		return ""
+	}
+	return v.nonces.Issue()
+}
+
+// Parse proof with the key from its header (short RSA keys are not supported).
+// Check htm and htu.
+// Check iat window.
+// Check access token hash if proof is presented with an access token.
+// Check server nonce.
+// Check jti replay.
+// Return key thumbprint.
+func (v *Verifier) Verify(ctx context.Context, proof, method, uri, accessToken string) (string, error) {
+	slog.DebugContext(ctx, "verify dpop called")
+	// Parse proof with the key from its header (short RSA keys are not supported).
+	var key *jwk
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(proof, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Header["typ"] != proofType {
+			return nil, e.ErrInvalidDPoPProof
+		}
+
+		var err error
+		key, err = parseJWK(t.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		return key.PublicKey()
+	}, jwt.WithValidMethods(algorithms))
+	if errors.Is(err, e.ErrUnsupportedKey) {
+		return "", e.ErrUnsupportedKey
+	}
+	if err != nil || !token.Valid {
+		return "", e.ErrInvalidDPoPProof
+	}
+
+	// Check htm and htu.
+	htm, _ := claims["htm"].(string)
+	htu, _ := claims["htu"].(string)
+	if htm != method || !sameURI(htu, uri) {
+		return "", e.ErrInvalidDPoPProof
+	}
+
+	// Check iat window.
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return "", e.ErrInvalidDPoPProof
+	}
+	issued := time.Unix(int64(iat), 0)
+	if time.Since(issued) > v.window || time.Until(issued) > v.window {
+		return "", e.ErrInvalidDPoPProof
+	}
+
+	// Check access token hash if proof is presented with an access token.
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if claims["ath"] != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return "", e.ErrInvalidDPoPProof
+		}
+	}
+
+	// Check server nonce.
+	if v.nonces != nil {
+		nonce, _ := claims["nonce"].(string)
+		if !v.nonces.Valid(nonce) {
+			return "", e.ErrUseDPoPNonce
+		}
+	}
+
+	thumbprint, err := key.Thumbprint()
+	if err != nil {
+		return "", err
+	}
+
+	// Check jti replay.
+	jti, _ := claims["jti"].(string)
+	if jti == "" || v.seen.Seen(thumbprint+jti, issued.Add(v.window)) {
+		return "", e.ErrInvalidDPoPProof
+	}
+
+	// Return key thumbprint.
+	return thumbprint, nil
+}
+
+// Compare uris without query and fragment, scheme and host are case insensitive.
+func sameURI(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(ua.Scheme, ub.Scheme) &&
+		strings.EqualFold(ua.Host, ub.Host) &&
+		ua.EscapedPath() == ub.EscapedPath()
+}
@@ -0,0 +1,171 @@
+package dpop_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/dpop"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func newProof(t *testing.T, key *ecdsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.PublicKey.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.PublicKey.Y.FillBytes(make([]byte, 32))),
+	}
+
+	proof, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+// Testcases:
+// 1) valid proof
+// 2) replayed proof
+// 3) proof for another method
+// 4) proof for another uri
+// 5) stale proof
+// 6) proof without access token hash
+// 7) proof with access token hash
+func TestVerify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri := "http://localhost:8080/restricted"
+	now := time.Now().Unix()
+	sum := sha256.Sum256([]byte("access"))
+	ath := base64.RawURLEncoding.EncodeToString(sum[:])
+	valid := newProof(t, key, jwt.MapClaims{"jti": "1", "htm": "GET", "htu": uri, "iat": now})
+
+	testcases := []struct {
+		proof         string
+		method        string
+		accessToken   string
+		expectedError error
+		name          string
+	}{
+		{proof: valid, method: "GET", expectedError: nil, name: "1"},
+		{proof: valid, method: "GET", expectedError: e.ErrInvalidDPoPProof, name: "2"},
+		{proof: newProof(t, key, jwt.MapClaims{"jti": "3", "htm": "POST", "htu": uri, "iat": now}), method: "GET", expectedError: e.ErrInvalidDPoPProof, name: "3"},
+		{proof: newProof(t, key, jwt.MapClaims{"jti": "4", "htm": "GET", "htu": "http://localhost:8080/other", "iat": now}), method: "GET", expectedError: e.ErrInvalidDPoPProof, name: "4"},
+		{proof: newProof(t, key, jwt.MapClaims{"jti": "5", "htm": "GET", "htu": uri, "iat": now - 3600}), method: "GET", expectedError: e.ErrInvalidDPoPProof, name: "5"},
+		{proof: newProof(t, key, jwt.MapClaims{"jti": "6", "htm": "GET", "htu": uri, "iat": now}), method: "GET", accessToken: "access", expectedError: e.ErrInvalidDPoPProof, name: "6"},
+		{proof: newProof(t, key, jwt.MapClaims{"jti": "7", "htm": "GET", "htu": uri, "iat": now, "ath": ath}), method: "GET", accessToken: "access", expectedError: nil, name: "7"},
+	}
+
+	verifier := dpop.New("secret", time.Minute, false)
+	var thumbprint string
+	for _, tc := range testcases {
+		t.Log(tc.name)
+		assert := assert.New(t)
+
+		jkt, err := verifier.Verify(context.Background(), tc.proof, tc.method, uri+"?query=ignored", tc.accessToken)
+		assert.Equal(tc.expectedError, err)
+		if err != nil {
+			continue
+		}
+
+		if thumbprint == "" {
+			thumbprint = jkt
+		}
+		assert.Equal(thumbprint, jkt)
+	}
+}
+
+// Testcases:
+// 1) proof without nonce
+// 2) proof with server nonce
+// 3) proof with forged nonce
+func TestVerifyNonce(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri := "http://localhost:8080/refreshToken"
+	verifier := dpop.New("secret", time.Minute, true)
+	nonce := verifier.Nonce()
+	forged := dpop.New("another secret", time.Minute, true).Nonce()
+
+	testcases := []struct {
+		nonce         any
+		expectedError error
+		name          string
+	}{
+		{nonce: nil, expectedError: e.ErrUseDPoPNonce, name: "1"},
+		{nonce: nonce, expectedError: nil, name: "2"},
+		{nonce: forged, expectedError: e.ErrUseDPoPNonce, name: "3"},
+	}
+
+	for _, tc := range testcases {
+		t.Log(tc.name)
+		claims := jwt.MapClaims{"jti": tc.name, "htm": "POST", "htu": uri, "iat": time.Now().Unix()}
+		if tc.nonce != nil {
+			claims["nonce"] = tc.nonce
+		}
+
+		_, err := verifier.Verify(context.Background(), newProof(t, key, claims), "POST", uri, "")
+		assert.Equal(t, tc.expectedError, err)
+	}
+}
+
+func newRSAProof(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = map[string]string{
+		"kty": "RSA",
+		"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+	}
+
+	proof, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+// Testcases:
+// 1) proof signed with a 2048 bit RSA key
+// 2) proof signed with an RSA key shorter than 2048 bits
+func TestVerifyRSAKeySize(t *testing.T) {
+	assert := assert.New(t)
+	uri := "http://localhost:8080/restricted"
+	now := time.Now().Unix()
+	verifier := dpop.New("secret", time.Minute, false)
+
+	// 1
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jkt, err := verifier.Verify(context.Background(), newRSAProof(t, key, jwt.MapClaims{"jti": "1", "htm": "GET", "htu": uri, "iat": now}), "GET", uri, "")
+	assert.Nil(err)
+	assert.NotEmpty(jkt)
+
+	// 2
+	key, err = rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = verifier.Verify(context.Background(), newRSAProof(t, key, jwt.MapClaims{"jti": "2", "htm": "GET", "htu": uri, "iat": now}), "GET", uri, "")
+	assert.Equal(e.ErrUnsupportedKey, err)
+}
@@ -0,0 +1,45 @@
+package dpop
+
+import (
+	"sync"
+	"time"
+)
+
+// Remembers seen proof identifiers until proofs with them could not be accepted anyway.
+type replayCache struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	purged time.Time
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{seen: make(map[string]time.Time)}
+}
+
+// Drop expired entries.
+// Check if id was seen.
+// Remember it.
+func (c *replayCache) Seen(id string, until time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Drop expired entries (at most once a second).
+	now := time.Now()
+	if now.Sub(c.purged) > time.Second {
+		for k, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.purged = now
+	}
+
+	// Check if id was seen.
+	if _, ok := c.seen[id]; ok {
+		return true
+	}
+
+	// Remember it.
+	c.seen[id] = until
+	return false
+}
@@ -13,4 +13,20 @@ var (
 	ErrTokenNotFound        = errors.New("provided token does not exists")
 	ErrBadRequest           = errors.New("provided request data is not valid")
 	ErrUserNotFound         = errors.New("provided GUID to update was not found")
+	ErrUnsupportedGrantType = errors.New("provided grant type is not supported")
+	ErrInvalidClient        = errors.New("client authentication failed")
+	ErrUnauthorizedClient   = errors.New("client is not allowed to use this grant")
+	ErrInvalidScope         = errors.New("requested scope is not allowed")
+	ErrInvalidTarget        = errors.New("requested audience is not allowed")
+	ErrInvalidDPoPProof     = errors.New("provided DPoP proof is invalid")
+	ErrUseDPoPNonce         = errors.New("use_dpop_nonce: DPoP proof must contain the server provided nonce")
+	ErrUnknownTokenFormat   = errors.New("configured token format is unknown")
+	ErrUnsupportedKey       = errors.New("provided key is not supported")
+	ErrAmbiguousEncryption  = errors.New("token audiences require different encryption keys")
+	ErrKeysNotLoaded        = errors.New("signing keys are not loaded")
+	ErrShuttingDown         = errors.New("service is shutting down")
+	ErrForbidden            = errors.New("client is not allowed to access this resource")
+	ErrAuditChainBroken     = errors.New("audit chain is broken")
+	ErrAuditConflict        = errors.New("audit entry was appended concurrently")
+	ErrInvalidSignature     = errors.New("webhook signature is invalid")
 )
@@ -4,9 +4,12 @@ import (
 	"crypto/sha512"
 	"encoding/hex"
 	"hash"
+	"sync"
 )
 
+// Hasher is shared between concurrent requests, so the hash state is guarded.
 type Hasher struct {
+	mu     sync.Mutex
 	hasher hash.Hash
 }
 
@@ -17,6 +20,8 @@ func NewHasher() *Hasher {
 }
 
 func (h *Hasher) Encrypt(data string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	defer h.hasher.Reset()
 	h.hasher.Write([]byte(data))
 
@@ -24,6 +29,8 @@ func (h *Hasher) Encrypt(data string) string {
 }
 
 func (h *Hasher) Validate(origin string, data string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	defer h.hasher.Reset()
 
 	h.hasher.Write([]byte(data))
@@ -0,0 +1,94 @@
+// Package janitor removes expired and revoked refresh tokens from stores that keep them until they are deleted.
+package janitor
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/metrics"
+)
+
+// Purger is implemented by stores that don't remove dead tokens by themselves (mongo, postgres and bolt).
+type Purger interface {
+	// PurgeExpired() removes at most limit tokens that expired before the time and returns how many were removed.
+	PurgeExpired(ctx context.Context, before time.Time, limit int) (int, error)
+	// PurgeRotated() drops at most limit revoked hashes (and grace pairs) of tokens rotated before the time
+	// and returns how many were dropped.
+	PurgeRotated(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// Janitor purges the store every interval in batches of at most batch tokens.
+// Revoked hashes are kept for retention after rotation (it has to cover the refresh grace window).
+type Janitor struct {
+	purger    Purger
+	interval  time.Duration
+	batch     int
+	retention time.Duration
+}
+
+func New(purger Purger, interval time.Duration, batch int, retention time.Duration) *Janitor {
+	if batch <= 0 {
+		batch = 1
+	}
+	return &Janitor{purger: purger, interval: interval, batch: batch, retention: retention}
+}
+
+// Run() purges the store every interval until the context is done.
+func (j *Janitor) Run(ctx context.Context) {
+	slog.InfoContext(ctx, "janitor started", "interval", j.interval, "batch", j.batch, "retention", j.retention)
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "janitor stopped")
+			return
+		case now := <-ticker.C:
+			j.Purge(ctx, now)
+		}
+	}
+}
+
+// Purge expired tokens batch by batch.
+// Purge revoked hashes older than retention batch by batch.
+// Count purged tokens.
+func (j *Janitor) Purge(ctx context.Context, now time.Time) {
+	// Purge expired tokens batch by batch.
+	expired, err := j.drain(ctx, func(ctx context.Context) (int, error) {
+		return j.purger.PurgeExpired(ctx, now, j.batch)
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "purge expired tokens: "+err.Error())
+	}
+
+	// Purge revoked hashes older than retention batch by batch.
+	rotated, err := j.drain(ctx, func(ctx context.Context) (int, error) {
+		return j.purger.PurgeRotated(ctx, now.Add(-j.retention), j.batch)
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "purge revoked tokens: "+err.Error())
+	}
+
+	// Count purged tokens.
+	metrics.Purged.WithLabelValues("expired").Add(float64(expired))
+	metrics.Purged.WithLabelValues("revoked").Add(float64(rotated))
+	slog.InfoContext(ctx, "janitor purged tokens", "expired", expired, "revoked", rotated)
+}
+
+// Call purge until a batch is not full (nothing is left) or the context is done.
+func (j *Janitor) drain(ctx context.Context, purge func(context.Context) (int, error)) (int, error) {
+	total := 0
+	for ctx.Err() == nil {
+		n, err := purge(ctx)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < j.batch {
+			break
+		}
+	}
+	return total, nil
+}
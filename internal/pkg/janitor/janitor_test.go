@@ -0,0 +1,126 @@
+package janitor_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/janitor"
+	"github.com/stretchr/testify/assert"
+)
+
+// Purger with expired and rotated tokens given as their times.
+type purger struct {
+	mu      sync.Mutex
+	expired []time.Time
+	rotated []time.Time
+	calls   int
+	err     error
+}
+
+func (p *purger) PurgeExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	return p.purge(&p.expired, before, limit)
+}
+
+func (p *purger) PurgeRotated(ctx context.Context, before time.Time, limit int) (int, error) {
+	return p.purge(&p.rotated, before, limit)
+}
+
+func (p *purger) purge(list *[]time.Time, before time.Time, limit int) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.err != nil {
+		return 0, p.err
+	}
+
+	var kept []time.Time
+	removed := 0
+	for _, t := range *list {
+		if removed < limit && t.Before(before) {
+			removed++
+			continue
+		}
+		kept = append(kept, t)
+	}
+	*list = kept
+	return removed, nil
+}
+
+func (p *purger) left() (expired, rotated, calls int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.expired), len(p.rotated), p.calls
+}
+
+func times(now time.Time, ages ...time.Duration) []time.Time {
+	var list []time.Time
+	for _, age := range ages {
+		list = append(list, now.Add(-age))
+	}
+	return list
+}
+
+// Testcases:
+// 1) everything dead is purged in several batches
+// 2) revoked hashes within retention are kept
+// 3) failure of one purge doesn't stop the other one
+func TestPurge(t *testing.T) {
+	now := time.Now()
+
+	// 1
+	p := &purger{
+		expired: times(now, time.Second, time.Minute, time.Hour, 2*time.Hour, 3*time.Hour),
+		rotated: times(now, 2*time.Hour, 3*time.Hour),
+	}
+	j := janitor.New(p, time.Hour, 2, time.Hour)
+	j.Purge(context.Background(), now)
+	assert.Empty(t, p.expired)
+	assert.Empty(t, p.rotated)
+	assert.Equal(t, 5, p.calls)
+
+	// 2
+	p = &purger{rotated: times(now, time.Minute, 2*time.Hour)}
+	j = janitor.New(p, time.Hour, 10, time.Hour)
+	j.Purge(context.Background(), now)
+	if assert.Len(t, p.rotated, 1) {
+		assert.Equal(t, now.Add(-time.Minute), p.rotated[0])
+	}
+
+	// 3
+	p = &purger{err: errors.New("store is down")}
+	j = janitor.New(p, time.Hour, 10, time.Hour)
+	j.Purge(context.Background(), now)
+	assert.Equal(t, 2, p.calls)
+}
+
+// Testcases:
+// 1) run purges on every tick and stops when context is done
+func TestRun(t *testing.T) {
+	p := &purger{expired: times(time.Now(), time.Minute, time.Hour)}
+	j := janitor.New(p, 10*time.Millisecond, 10, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		j.Run(ctx)
+		close(done)
+	}()
+
+	// Every run calls both purges once (nothing is left after the first batch).
+	assert.Eventually(t, func() bool {
+		_, _, calls := p.left()
+		return calls >= 4
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("janitor did not stop")
+	}
+	expired, _, _ := p.left()
+	assert.Equal(t, 0, expired)
+}
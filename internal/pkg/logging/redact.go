@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces values that must not be logged.
+const Redacted = "[REDACTED]"
+
+// Attributes whose keys contain these words are never logged.
+var sensitiveKeys = []string{"token", "secret", "password", "authorization", "cookie", "dpop", "key", "signature"}
+
+// Credentials that may appear in messages and values:
+// JWS/JWE and PASETO (and their base64 encoding as refresh tokens are sent), Authorization header values.
+// Base64 of "v1."-"v4." is "djEu"-"djQu", of "local." is "bG9jYWwu" and of "public" is "cHVibGlj".
+var sensitiveValues = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]*){1,4}` +
+	`|ZXlK[A-Za-z0-9+/=]{16,}` +
+	`|v[1-4]\.(local|public)\.[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)?` +
+	`|dj[EIMQ]u(bG9jYWwu|cHVibGlj)[A-Za-z0-9+/=]{16,}` +
+	`|(?i:bearer|dpop|basic)\s+[A-Za-z0-9._~+/=-]{16,}`)
+
+// Secrets shorter than this are not searched for, they would match innocent text.
+const minSecretLength = 8
+
+// Handler replacing credentials in messages and attributes (groups included) before they are formatted.
+type redactor struct {
+	next    slog.Handler
+	secrets []string
+}
+
+func newRedactor(next slog.Handler, secrets []string) *redactor {
+	r := &redactor{next: next}
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+	return r
+}
+
+func (r *redactor) Enabled(ctx context.Context, level slog.Level) bool {
+	return r.next.Enabled(ctx, level)
+}
+
+func (r *redactor) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, r.redactString(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(r.redact(attr))
+		return true
+	})
+	return r.next.Handle(ctx, redacted)
+}
+
+func (r *redactor) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = r.redact(attr)
+	}
+	return &redactor{next: r.next.WithAttrs(redacted), secrets: r.secrets}
+}
+
+func (r *redactor) WithGroup(name string) slog.Handler {
+	return &redactor{next: r.next.WithGroup(name), secrets: r.secrets}
+}
+
+// Hide the whole value of a sensitive key, otherwise hide credentials inside of it.
+func (r *redactor) redact(attr slog.Attr) slog.Attr {
+	if isSensitiveKey(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]any, len(group))
+		for i, a := range group {
+			redacted[i] = r.redact(a)
+		}
+		return slog.Group(attr.Key, redacted...)
+	case slog.KindString:
+		return slog.String(attr.Key, r.redactString(value.String()))
+	case slog.KindAny:
+		// Errors, structs, maps and so on are formatted here, so nothing escapes through their String().
+		return slog.String(attr.Key, r.redactString(value.String()))
+	default:
+		return slog.Attr{Key: attr.Key, Value: value}
+	}
+}
+
+func (r *redactor) redactString(s string) string {
+	s = sensitiveValues.ReplaceAllString(s, Redacted)
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	return s
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
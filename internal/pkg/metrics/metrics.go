@@ -0,0 +1,171 @@
+// Package metrics defines prometheus metrics of the service and the handler exposing them.
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Grants tokens are issued by.
+const (
+	GrantTokenPair     = "token_pair"
+	GrantRefresh       = "refresh"
+	GrantTokenExchange = "token_exchange"
+)
+
+// Registry holds every metric of the service (and go runtime and process metrics).
+var Registry = prometheus.NewRegistry()
+
+var (
+	TokensIssued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_tokens_issued_total",
+		Help: "Token pairs and exchanged tokens issued, by grant.",
+	}, []string{"grant"})
+
+	Refreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_refreshes_total",
+		Help: "Refresh attempts by result (success or failure) and failure reason.",
+	}, []string{"result", "reason"})
+
+	Validations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_middleware_validations_total",
+		Help: "Access token validations of the middleware by outcome (valid or failure reason).",
+	}, []string{"outcome"})
+
+	RepositoryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "auth_repository_operation_duration_seconds",
+		Help:    "Duration of token store operations by operation and result.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"operation", "result"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "auth_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	Purged = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_janitor_purged_total",
+		Help: "Tokens purged by the janitor, by kind (expired tokens or revoked hashes).",
+	}, []string{"kind"})
+
+	AuditFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "auth_audit_write_failures_total",
+		Help: "Audit entries which could not be written to the sink.",
+	})
+
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_webhook_deliveries_total",
+		Help: "Webhook delivery attempts by subscription and result (delivered, retry or dead).",
+	}, []string{"subscription", "result"})
+
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auth_build_info",
+		Help: "Build of the running binary, always 1.",
+	}, []string{"version", "revision", "go_version"})
+)
+
+// Reasons of the sentinel errors, errors which are not listed are reported as "other".
+var reasons = []struct {
+	err    error
+	reason string
+}{
+	{e.ErrTokenWasNotProvided, "token_not_provided"},
+	{e.ErrInvalidSigningMethod, "invalid_signing_method"},
+	{e.ErrInternal, "internal"},
+	{e.ErrInvalidToken, "invalid_token"},
+	{e.ErrTokenExpired, "token_expired"},
+	{e.ErrTokenAlreadyUsed, "token_already_used"},
+	{e.ErrInvalidGUID, "invalid_guid"},
+	{e.ErrTokenNotFound, "token_not_found"},
+	{e.ErrBadRequest, "bad_request"},
+	{e.ErrUserNotFound, "user_not_found"},
+	{e.ErrUnsupportedGrantType, "unsupported_grant_type"},
+	{e.ErrInvalidClient, "invalid_client"},
+	{e.ErrUnauthorizedClient, "unauthorized_client"},
+	{e.ErrInvalidScope, "invalid_scope"},
+	{e.ErrInvalidTarget, "invalid_target"},
+	{e.ErrInvalidDPoPProof, "invalid_dpop_proof"},
+	{e.ErrUseDPoPNonce, "use_dpop_nonce"},
+	{e.ErrUnknownTokenFormat, "unknown_token_format"},
+	{e.ErrUnsupportedKey, "unsupported_key"},
+	{e.ErrAmbiguousEncryption, "ambiguous_encryption"},
+	{e.ErrKeysNotLoaded, "keys_not_loaded"},
+	{e.ErrShuttingDown, "shutting_down"},
+	{e.ErrForbidden, "forbidden"},
+	{e.ErrAuditChainBroken, "audit_chain_broken"},
+	{e.ErrAuditConflict, "audit_conflict"},
+	{e.ErrInvalidSignature, "invalid_signature"},
+}
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		TokensIssued, Refreshes, Validations, RepositoryDuration, HTTPDuration, Purged, AuditFailures, WebhookDeliveries, buildInfo,
+	)
+
+	version, revision, goVersion := "unknown", "unknown", "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version, goVersion = info.Main.Version, info.GoVersion
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+	buildInfo.WithLabelValues(version, revision, goVersion).Set(1)
+}
+
+// Handler() serves metrics of the registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Reason() maps the error to a label value, nil is "none".
+func Reason(err error) string {
+	if err == nil {
+		return "none"
+	}
+	for _, r := range reasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return "other"
+}
+
+// ObserveRefresh() counts the refresh attempt by its error.
+func ObserveRefresh(err error) {
+	if err != nil {
+		Refreshes.WithLabelValues("failure", Reason(err)).Inc()
+		return
+	}
+	Refreshes.WithLabelValues("success", "none").Inc()
+	TokensIssued.WithLabelValues(GrantRefresh).Inc()
+}
+
+// ObserveValidation() counts the middleware validation by its error.
+func ObserveValidation(err error) {
+	if err != nil {
+		Validations.WithLabelValues(Reason(err)).Inc()
+		return
+	}
+	Validations.WithLabelValues("valid").Inc()
+}
+
+// ObserveRepository() records duration of the store operation started at start.
+func ObserveRepository(operation string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = Reason(err)
+	}
+	RepositoryDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
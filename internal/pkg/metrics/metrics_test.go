@@ -0,0 +1,67 @@
+package metrics_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testcases:
+// 1) no error
+// 2) sentinel error
+// 3) wrapped sentinel error
+// 4) unknown error
+func TestReason(t *testing.T) {
+	testcases := []struct {
+		err            error
+		expectedReason string
+		name           string
+	}{
+		{err: nil, expectedReason: "none", name: "1"},
+		{err: e.ErrTokenAlreadyUsed, expectedReason: "token_already_used", name: "2"},
+		{err: fmt.Errorf("rotate: %w", e.ErrUseDPoPNonce), expectedReason: "use_dpop_nonce", name: "3"},
+		{err: errors.New("connection refused"), expectedReason: "other", name: "4"},
+	}
+
+	for _, tc := range testcases {
+		t.Log(tc.name)
+		assert.Equal(t, tc.expectedReason, metrics.Reason(tc.err))
+	}
+}
+
+// Refreshes are counted by result and reason, successful ones are issued tokens.
+func TestObserveRefresh(t *testing.T) {
+	assert := assert.New(t)
+	success := testutil.ToFloat64(metrics.Refreshes.WithLabelValues("success", "none"))
+	reused := testutil.ToFloat64(metrics.Refreshes.WithLabelValues("failure", "token_already_used"))
+	issued := testutil.ToFloat64(metrics.TokensIssued.WithLabelValues(metrics.GrantRefresh))
+
+	metrics.ObserveRefresh(nil)
+	metrics.ObserveRefresh(e.ErrTokenAlreadyUsed)
+	metrics.ObserveRefresh(e.ErrTokenAlreadyUsed)
+
+	assert.Equal(success+1, testutil.ToFloat64(metrics.Refreshes.WithLabelValues("success", "none")))
+	assert.Equal(reused+2, testutil.ToFloat64(metrics.Refreshes.WithLabelValues("failure", "token_already_used")))
+	assert.Equal(issued+1, testutil.ToFloat64(metrics.TokensIssued.WithLabelValues(metrics.GrantRefresh)))
+}
+
+// Handler exposes metrics of the service and build info.
+func TestHandler(t *testing.T) {
+	metrics.ObserveValidation(nil)
+	w := httptest.NewRecorder()
+
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+	assert.Equal(t, 200, w.Code)
+	for _, name := range []string{"auth_build_info", `auth_middleware_validations_total{outcome="valid"}`, "go_goroutines"} {
+		assert.True(t, strings.Contains(body, name), name)
+	}
+}
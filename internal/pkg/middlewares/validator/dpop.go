@@ -0,0 +1,129 @@
+package jwt
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/certs"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+)
+
+const (
+	schemeBearer = "Bearer"
+	schemeDPoP   = "DPoP"
+)
+
+// Check DPoP proof of the request.
+// Return thumbprint of the proof key.
+func (j *JwtMiddleware) ValidateProof(w http.ResponseWriter, r *http.Request, accessToken string) (string, error) {
+	slog.DebugContext(r.Context(), "validateproof middleware called")
+	j.setNonce(w)
+
+	// Check DPoP proof of the request.
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) != 1 {
+		return "", e.ErrInvalidDPoPProof
+	}
+
+	// Return thumbprint of the proof key.
+	return j.dpop.Verify(r.Context(), proofs[0], r.Method, j.requestURI(r), accessToken)
+}
+
+// Bind the tokens to the verified client certificate if it was presented.
+// Check if the request has a DPoP proof.
+// Validate it and bind the tokens to its key.
+func (j *JwtMiddleware) Confirmation(w http.ResponseWriter, r *http.Request) (models.Confirmation, error) {
+	slog.DebugContext(r.Context(), "confirmation middleware called")
+	// Bind the tokens to the verified client certificate if it was presented.
+	var cnf models.Confirmation
+	if cert := ClientCertificate(r); cert != nil {
+		cnf.X5T = certs.Thumbprint(cert)
+	}
+
+	// Check if the request has a DPoP proof.
+	if r.Header.Get("DPoP") == "" {
+		return cnf, nil
+	}
+
+	// Validate it and bind the tokens to its key.
+	jkt, err := j.ValidateProof(w, r, "")
+	if err != nil {
+		return models.Confirmation{}, err
+	}
+	cnf.JKT = jkt
+
+	return cnf, nil
+}
+
+// ClientCertificate() returns the client certificate verified during TLS handshake or nil.
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// Certificate bound tokens must be presented over TLS with the same client certificate.
+// Bearer tokens must not be presented with DPoP scheme and vice versa.
+// DPoP bound tokens require a proof signed by the key they are bound to.
+func (j *JwtMiddleware) checkConfirmation(w http.ResponseWriter, r *http.Request, scheme, tokenString string, claims map[string]any) error {
+	cnf := models.ConfirmationFromClaims(claims)
+
+	// Certificate bound tokens must be presented over TLS with the same client certificate.
+	if cnf.X5T != "" {
+		cert := ClientCertificate(r)
+		if cert == nil || certs.Thumbprint(cert) != cnf.X5T {
+			return e.ErrInvalidToken
+		}
+	}
+
+	// Bearer tokens must not be presented with DPoP scheme and vice versa.
+	if cnf.JKT == "" {
+		if scheme == schemeDPoP {
+			return e.ErrInvalidToken
+		}
+		return nil
+	}
+	if scheme != schemeDPoP {
+		return e.ErrInvalidToken
+	}
+
+	// DPoP bound tokens require a proof signed by the key they are bound to.
+	jkt, err := j.ValidateProof(w, r, tokenString)
+	if err != nil {
+		return err
+	}
+	if jkt != cnf.JKT {
+		return e.ErrInvalidDPoPProof
+	}
+
+	return nil
+}
+
+// WWW-Authenticate challenge for the failed validation.
+func (j *JwtMiddleware) challenge(err error) string {
+	switch {
+	case errors.Is(err, e.ErrUseDPoPNonce):
+		return fmt.Sprintf(`%s error="use_dpop_nonce", algs="%s"`, schemeDPoP, "ES256 RS256 EdDSA")
+	case errors.Is(err, e.ErrInvalidDPoPProof):
+		return fmt.Sprintf(`%s error="invalid_dpop_proof", algs="%s"`, schemeDPoP, "ES256 RS256 EdDSA")
+	default:
+		return fmt.Sprintf(`%s error="invalid_token"`, schemeDPoP)
+	}
+}
+
+// Provide a fresh server nonce if they are required.
+func (j *JwtMiddleware) setNonce(w http.ResponseWriter) {
+	if nonce := j.dpop.Nonce(); nonce != "" {
+		w.Header().Set("DPoP-Nonce", nonce)
+	}
+}
+
+// Reconstruct the URI the client has sent the request to (scheme and host are taken from trusted proxies).
+func (j *JwtMiddleware) requestURI(r *http.Request) string {
+	return j.realIP.Origin(r) + r.URL.Path
+}
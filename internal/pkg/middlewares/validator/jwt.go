@@ -1,80 +1,130 @@
 package jwt
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/VanLavr/auth/internal/models"
 	"github.com/VanLavr/auth/internal/pkg/config"
+	"github.com/VanLavr/auth/internal/pkg/dpop"
 	e "github.com/VanLavr/auth/internal/pkg/errors"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/VanLavr/auth/internal/pkg/metrics"
+	"github.com/VanLavr/auth/internal/pkg/realip"
+	"github.com/VanLavr/auth/internal/pkg/tokens"
 )
 
-// Secret is a secret string for token encryption. acExp - access token exparation time,
-// refExp - refresh token exparation time.
+// Formats verify tokens in any supported format (JWT or PASETO). acExp - access token exparation time,
+// refExp - refresh token exparation time. dpop verifies proofs of sender-constrained tokens,
+// realIP resolves the URI proofs are signed for behind trusted proxies.
 type JwtMiddleware struct {
-	secret string
-	acExp  time.Duration
-	refExp time.Duration
+	formats *tokens.Formats
+	acExp   time.Duration
+	refExp  time.Duration
+	dpop    *dpop.Verifier
+	realIP  *realip.Resolver
 }
 
-func New(cfg *config.Config) *JwtMiddleware {
-	return &JwtMiddleware{
-		secret: cfg.Secret,
-		acExp:  cfg.AccessExpTime,
-		refExp: cfg.RefreshExpTime,
+// New() fails if token formats can not be created with the configured keys.
+func New(cfg *config.Config) (*JwtMiddleware, error) {
+	formats, err := tokens.New(cfg)
+	if err != nil {
+		return nil, err
 	}
+	return &JwtMiddleware{
+		formats: formats,
+		acExp:   cfg.AccessExpTime,
+		refExp:  cfg.RefreshExpTime,
+		dpop:    dpop.New(cfg.Secret, cfg.DPoPWindow, cfg.DPoPNonce),
+		realIP:  realip.New(cfg.TrustedProxies),
+	}, nil
 }
 
 // Extract token string from request.
 // Parse it.
 // Check if it valid or not.
+// Check that it is not a refresh token.
+// Check that it is presented by the key it is bound to.
 // Call the handler if it's allright.
 func (j *JwtMiddleware) ValidateAccessToken(next func(w http.ResponseWriter, r *http.Request)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Extract token string from request.
-		tokenString, err := j.ExtractTokenString(r)
+		scheme, tokenString, err := j.extractAuthorization(r)
 		if err != nil {
+			metrics.ObserveValidation(err)
 			w.WriteHeader(http.StatusUnauthorized)
 			fmt.Fprint(w, err.Error())
-			slog.Error(err.Error())
+			slog.ErrorContext(r.Context(), err.Error())
 			return
 		}
 
 		// Parse it.
-		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-			_, ok := t.Method.(*jwt.SigningMethodHMAC)
-			if !ok {
-				return nil, e.ErrInvalidSigningMethod
-			}
-			return []byte(j.secret), nil
-		})
-
 		// Check if it valid or not.
-		if err != nil || !token.Valid {
+		claims, err := j.ParseToken(r.Context(), tokenString)
+		if err != nil {
+			metrics.ObserveValidation(e.ErrInvalidToken)
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, e.ErrInvalidToken.Error())
+			slog.ErrorContext(r.Context(), err.Error())
+			return
+		}
+
+		// Check that it is not a refresh token.
+		if claims["typ"] == models.TypRefreshToken {
+			metrics.ObserveValidation(e.ErrInvalidToken)
 			w.WriteHeader(http.StatusUnauthorized)
 			fmt.Fprint(w, e.ErrInvalidToken.Error())
-			slog.Error(err.Error())
+			slog.ErrorContext(r.Context(), "refresh token used as access token")
+			return
+		}
+
+		// Check that it is presented by the key it is bound to.
+		if err := j.checkConfirmation(w, r, scheme, tokenString, claims); err != nil {
+			metrics.ObserveValidation(err)
+			w.Header().Set("WWW-Authenticate", j.challenge(err))
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, err.Error())
+			slog.ErrorContext(r.Context(), err.Error())
 			return
 		}
 
 		// Call the handler if it's allright.
+		metrics.ObserveValidation(nil)
 		next(w, r)
 	})
 }
 
+// ParseToken() verifies token in any supported format (JWT or PASETO) and returns its claims.
+func (j *JwtMiddleware) ParseToken(ctx context.Context, tokenString string) (map[string]any, error) {
+	return j.formats.Verify(ctx, tokenString)
+}
+
 func (j *JwtMiddleware) ExtractTokenString(r *http.Request) (string, error) {
+	_, tokenString, err := j.extractAuthorization(r)
+	return tokenString, err
+}
+
+// Split authorization header into scheme (Bearer or DPoP) and token string.
+func (j *JwtMiddleware) extractAuthorization(r *http.Request) (string, string, error) {
 	authHeaders := r.Header.Values("Authorization")
 	if len(authHeaders) == 0 {
-		return "", e.ErrTokenWasNotProvided
+		return "", "", e.ErrTokenWasNotProvided
 	}
 
-	jwtHeader := authHeaders[0]
-	tokenString := jwtHeader[len("Bearer "):]
-	if len(tokenString) == 0 {
-		return "", e.ErrTokenWasNotProvided
+	scheme, tokenString, ok := strings.Cut(authHeaders[0], " ")
+	if !ok || len(tokenString) == 0 {
+		return "", "", e.ErrTokenWasNotProvided
 	}
 
-	return tokenString, nil
+	switch {
+	case strings.EqualFold(scheme, schemeBearer):
+		return schemeBearer, tokenString, nil
+	case strings.EqualFold(scheme, schemeDPoP):
+		return schemeDPoP, tokenString, nil
+	default:
+		return "", "", e.ErrTokenWasNotProvided
+	}
 }
@@ -0,0 +1,141 @@
+// Package migrate applies ordered schema migrations of a storage.
+// Storage records the applied version and provides a lock, so replicas starting together
+// apply every migration once. Migrations should be idempotent: a migration that was applied
+// but not recorded (crash in between) runs again on the next start.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+)
+
+// Migration is one ordered schema change.
+type Migration struct {
+	Version int
+	Name    string
+	// Plan describes what Up would change without changing anything (printed on dry run).
+	Plan func(ctx context.Context) (string, error)
+	Up   func(ctx context.Context) error
+}
+
+// ErrLockLost is the cause of the locked context of a store that lost the lock while it was held.
+var ErrLockLost = errors.New("migration lock was lost")
+
+// Store is a storage the migrations are applied to.
+type Store interface {
+	// Lock() blocks until the migration lock is acquired or ctx is done.
+	// Migrations run with the returned context, stores with expiring locks cancel it with ErrLockLost
+	// if the lock could not be kept.
+	Lock(ctx context.Context) (locked context.Context, unlock func(context.Context) error, err error)
+	// Version() returns the last applied version, 0 if nothing was applied.
+	Version(ctx context.Context) (int, error)
+	// Record() saves the migration as applied.
+	Record(ctx context.Context, m Migration) error
+}
+
+// Check migrations order.
+// Lock the store.
+// Apply (or only describe on dry run) migrations newer than the store version in order,
+// every step checks that the lock is still held.
+func Run(ctx context.Context, store Store, migrations []Migration, dryRun bool, out io.Writer) (err error) {
+	// Check migrations order.
+	if err := validate(migrations); err != nil {
+		return err
+	}
+
+	// Lock the store.
+	locked, unlock, err := store.Lock(ctx)
+	if err != nil {
+		return fmt.Errorf("lock migrations: %w", err)
+	}
+	defer func() {
+		if unlockErr := unlock(context.WithoutCancel(ctx)); unlockErr != nil && err == nil {
+			err = fmt.Errorf("unlock migrations: %w", unlockErr)
+		}
+	}()
+
+	ctx = locked
+
+	current, err := store.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+
+	// Apply (or only describe on dry run) migrations newer than the store version in order,
+	// every step checks that the lock is still held.
+	pending := 0
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		pending++
+		if err := context.Cause(ctx); err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+
+		if dryRun {
+			plan := "no changes described"
+			if m.Plan != nil {
+				if plan, err = m.Plan(ctx); err != nil {
+					return fmt.Errorf("plan migration %d %s: %w", m.Version, m.Name, err)
+				}
+			}
+			fmt.Fprintf(out, "would apply %d %s: %s\n", m.Version, m.Name, plan)
+			continue
+		}
+
+		if err := m.Up(ctx); err != nil {
+			return fmt.Errorf("apply migration %d %s: %w", m.Version, m.Name, cause(ctx, err))
+		}
+		if err := store.Record(ctx, m); err != nil {
+			return fmt.Errorf("record migration %d %s: %w", m.Version, m.Name, cause(ctx, err))
+		}
+		slog.InfoContext(ctx, "applied migration", "version", m.Version, "name", m.Name)
+		fmt.Fprintf(out, "applied %d %s\n", m.Version, m.Name)
+	}
+
+	if pending == 0 {
+		fmt.Fprintf(out, "schema is up to date (version %d)\n", current)
+	}
+	return nil
+}
+
+// Error of a step interrupted because the lock was lost is reported as ErrLockLost.
+func cause(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), ErrLockLost) {
+		return fmt.Errorf("%w: %w", ErrLockLost, err)
+	}
+	return err
+}
+
+// Latest() returns the version of the last migration.
+func Latest(migrations []Migration) int {
+	latest := 0
+	for _, m := range migrations {
+		latest = max(latest, m.Version)
+	}
+	return latest
+}
+
+// Versions must be positive, unique and sorted.
+func validate(migrations []Migration) error {
+	if !sort.SliceIsSorted(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version }) {
+		return fmt.Errorf("migrations are not ordered by version")
+	}
+	for i, m := range migrations {
+		if m.Version <= 0 {
+			return fmt.Errorf("migration %s has non positive version %d", m.Name, m.Version)
+		}
+		if i > 0 && migrations[i-1].Version == m.Version {
+			return fmt.Errorf("migrations %s and %s have the same version %d", migrations[i-1].Name, m.Name, m.Version)
+		}
+		if m.Up == nil {
+			return fmt.Errorf("migration %d %s has no up", m.Version, m.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package migrate_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/VanLavr/auth/internal/pkg/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+// Store keeping the version in memory.
+type store struct {
+	lock    sync.Mutex
+	locked  bool
+	version int
+	// lose cancels the locked context as if the lock was taken over.
+	lose context.CancelCauseFunc
+}
+
+func (s *store) Lock(ctx context.Context) (context.Context, func(context.Context) error, error) {
+	s.lock.Lock()
+	s.locked = true
+	locked, cancel := context.WithCancelCause(ctx)
+	s.lose = cancel
+	return locked, func(context.Context) error {
+		cancel(nil)
+		s.locked = false
+		s.lock.Unlock()
+		return nil
+	}, nil
+}
+
+func (s *store) Version(ctx context.Context) (int, error) {
+	return s.version, nil
+}
+
+func (s *store) Record(ctx context.Context, m migrate.Migration) error {
+	s.version = m.Version
+	return nil
+}
+
+// Migrations appending their versions to applied.
+func migrations(applied *[]int, versions ...int) []migrate.Migration {
+	var list []migrate.Migration
+	for _, v := range versions {
+		list = append(list, migrate.Migration{
+			Version: v,
+			Name:    "test",
+			Plan:    func(context.Context) (string, error) { return "change", nil },
+			Up: func(context.Context) error {
+				*applied = append(*applied, v)
+				return nil
+			},
+		})
+	}
+	return list
+}
+
+// Testcases:
+// 1) apply everything to empty store
+// 2) apply only newer migrations
+// 3) dry run applies nothing and prints plans
+// 4) up to date store
+// 5) unordered migrations are rejected
+// 6) duplicate versions are rejected
+func TestRun(t *testing.T) {
+	testcases := []struct {
+		name            string
+		version         int
+		versions        []int
+		dryRun          bool
+		expectedApplied []int
+		expectedVersion int
+		expectedOutput  string
+		expectedError   bool
+	}{
+		{name: "1", versions: []int{1, 2, 3}, expectedApplied: []int{1, 2, 3}, expectedVersion: 3, expectedOutput: "applied 1 test\napplied 2 test\napplied 3 test\n"},
+		{name: "2", version: 2, versions: []int{1, 2, 3}, expectedApplied: []int{3}, expectedVersion: 3, expectedOutput: "applied 3 test\n"},
+		{name: "3", version: 1, versions: []int{1, 2, 3}, dryRun: true, expectedVersion: 1, expectedOutput: "would apply 2 test: change\nwould apply 3 test: change\n"},
+		{name: "4", version: 3, versions: []int{1, 2, 3}, expectedVersion: 3, expectedOutput: "schema is up to date (version 3)\n"},
+		{name: "5", versions: []int{2, 1}, expectedError: true},
+		{name: "6", versions: []int{1, 1}, expectedError: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			s := &store{version: tc.version}
+			var (
+				applied []int
+				out     bytes.Buffer
+			)
+
+			err := migrate.Run(context.Background(), s, migrations(&applied, tc.versions...), tc.dryRun, &out)
+
+			assert.Equal(tc.expectedError, err != nil)
+			assert.Equal(tc.expectedApplied, applied)
+			assert.Equal(tc.expectedVersion, s.version)
+			assert.Equal(tc.expectedOutput, out.String())
+			assert.False(s.locked)
+		})
+	}
+}
+
+// Failed migration stops the run, previous ones stay recorded.
+func TestRunFailure(t *testing.T) {
+	assert := assert.New(t)
+	s := &store{}
+	var applied []int
+	list := migrations(&applied, 1, 2, 3)
+	list[1].Up = func(context.Context) error { return errors.New("failed") }
+
+	err := migrate.Run(context.Background(), s, list, false, &bytes.Buffer{})
+
+	assert.NotNil(err)
+	assert.Equal([]int{1}, applied)
+	assert.Equal(1, s.version)
+	assert.False(s.locked)
+}
+
+// Concurrent runs apply every migration once.
+func TestRunConcurrent(t *testing.T) {
+	s := &store{}
+	var (
+		mu      sync.Mutex
+		applied []int
+		wg      sync.WaitGroup
+	)
+	list := migrations(&applied, 1, 2)
+	for i := range list {
+		up := list[i].Up
+		list[i].Up = func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return up(ctx)
+		}
+	}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := migrate.Run(context.Background(), s, list, false, &bytes.Buffer{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, []int{1, 2}, applied)
+}
+
+// Testcases:
+// 1) next migration is not applied after the lock was lost
+// 2) migration interrupted by the lost lock reports it
+func TestRunLockLost(t *testing.T) {
+	assert := assert.New(t)
+
+	// 1
+	s := &store{}
+	var applied []int
+	list := migrations(&applied, 1, 2)
+	up := list[0].Up
+	list[0].Up = func(ctx context.Context) error {
+		s.lose(migrate.ErrLockLost)
+		return up(ctx)
+	}
+
+	err := migrate.Run(context.Background(), s, list, false, &bytes.Buffer{})
+
+	assert.ErrorIs(err, migrate.ErrLockLost)
+	assert.Equal([]int{1}, applied)
+	assert.False(s.locked)
+
+	// 2
+	s = &store{}
+	list = migrations(&applied, 1)
+	list[0].Up = func(ctx context.Context) error {
+		s.lose(migrate.ErrLockLost)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	err = migrate.Run(context.Background(), s, list, false, &bytes.Buffer{})
+
+	assert.ErrorIs(err, migrate.ErrLockLost)
+	assert.ErrorIs(err, context.Canceled)
+	assert.Zero(s.version)
+}
@@ -0,0 +1,131 @@
+// Package realip resolves the client address of a request behind reverse proxies.
+package realip
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Resolver trusts X-Forwarded-For only when it is set by one of the trusted proxies.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+func New(trusted []netip.Prefix) *Resolver {
+	return &Resolver{trusted: trusted}
+}
+
+// Take the peer address, it is the client if it is not a trusted proxy.
+// Walk X-Forwarded-For from the right (nearest hop) skipping trusted proxies.
+// The first untrusted address is the client.
+func (r *Resolver) ClientIP(req *http.Request) string {
+	// Take the peer address, it is the client if it is not a trusted proxy.
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !r.isTrusted(peer) {
+		return host
+	}
+
+	// Walk X-Forwarded-For from the right (nearest hop) skipping trusted proxies.
+	var hops []string
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = hop
+
+		// The first untrusted address is the client.
+		if !r.isTrusted(hop) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// Take the scheme and host the server got the request with.
+// Replace them with X-Forwarded-Proto and X-Forwarded-Host if the peer is a trusted proxy
+// (the leftmost values are the ones the outermost proxy got, proxies have to overwrite them).
+// Return the origin the client sent the request to.
+func (r *Resolver) Origin(req *http.Request) string {
+	// Take the scheme and host the server got the request with.
+	scheme, host := "http", req.Host
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	// Replace them with X-Forwarded-Proto and X-Forwarded-Host if the peer is a trusted proxy.
+	if r.trustedPeer(req) {
+		if proto := firstValue(req.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwarded := firstValue(req.Header.Get("X-Forwarded-Host")); forwarded != "" {
+			host = forwarded
+		}
+	}
+
+	// Return the origin the client sent the request to.
+	return scheme + "://" + host
+}
+
+func (r *Resolver) trustedPeer(req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	return err == nil && r.isTrusted(peer)
+}
+
+func firstValue(header string) string {
+	value, _, _ := strings.Cut(header, ",")
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+func (r *Resolver) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePrefixes() parses comma separated addresses and CIDR ranges.
+func ParsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,91 @@
+package realip_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VanLavr/auth/internal/pkg/realip"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testcases:
+// 1) direct client, forwarded header is ignored
+// 2) client behind trusted proxy
+// 3) client behind chain of trusted proxies
+// 4) spoofed hops left of the first untrusted address are ignored
+// 5) every hop is trusted
+// 6) malformed hop stops the walk
+func TestClientIP(t *testing.T) {
+	trusted, err := realip.ParsePrefixes("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := realip.New(trusted)
+
+	testcases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		expectedIP string
+	}{
+		{name: "1", remoteAddr: "203.0.113.7:5555", forwarded: []string{"1.1.1.1"}, expectedIP: "203.0.113.7"},
+		{name: "2", remoteAddr: "10.0.0.2:5555", forwarded: []string{"203.0.113.7"}, expectedIP: "203.0.113.7"},
+		{name: "3", remoteAddr: "10.0.0.2:5555", forwarded: []string{"203.0.113.7, 192.168.1.1", "10.1.1.1"}, expectedIP: "203.0.113.7"},
+		{name: "4", remoteAddr: "10.0.0.2:5555", forwarded: []string{"1.1.1.1, 203.0.113.7"}, expectedIP: "203.0.113.7"},
+		{name: "5", remoteAddr: "10.0.0.2:5555", forwarded: []string{"10.0.0.3"}, expectedIP: "10.0.0.3"},
+		{name: "6", remoteAddr: "10.0.0.2:5555", forwarded: []string{"203.0.113.7, garbage"}, expectedIP: "10.0.0.2"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, header := range tc.forwarded {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+
+			assert.Equal(t, tc.expectedIP, resolver.ClientIP(r))
+		})
+	}
+}
+
+// Testcases:
+// 1) direct plain request
+// 2) forwarded headers of an untrusted peer are ignored
+// 3) scheme and host forwarded by a trusted TLS-terminating proxy
+// 4) leftmost forwarded values are taken, unknown scheme is ignored
+func TestOrigin(t *testing.T) {
+	trusted, err := realip.ParsePrefixes("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := realip.New(trusted)
+
+	testcases := []struct {
+		name           string
+		remoteAddr     string
+		proto          string
+		host           string
+		expectedOrigin string
+	}{
+		{name: "1", remoteAddr: "203.0.113.7:5555", expectedOrigin: "http://auth.internal:8080"},
+		{name: "2", remoteAddr: "203.0.113.7:5555", proto: "https", host: "auth.example.com", expectedOrigin: "http://auth.internal:8080"},
+		{name: "3", remoteAddr: "10.0.0.2:5555", proto: "https", host: "auth.example.com", expectedOrigin: "https://auth.example.com"},
+		{name: "4", remoteAddr: "10.0.0.2:5555", proto: "ftp", host: "Auth.example.com, auth.internal", expectedOrigin: "http://auth.example.com"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://auth.internal:8080/restricted", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tc.proto)
+			}
+			if tc.host != "" {
+				r.Header.Set("X-Forwarded-Host", tc.host)
+			}
+
+			assert.Equal(t, tc.expectedOrigin, resolver.Origin(r))
+		})
+	}
+}
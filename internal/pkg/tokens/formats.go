@@ -0,0 +1,223 @@
+// Package tokens signs and verifies token claims in one of the supported formats (JWT or PASETO v4).
+package tokens
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"aidanwoods.dev/go-paseto"
+	"github.com/VanLavr/auth/internal/models"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+)
+
+// Names of the formats in config.
+const (
+	FormatJWT    = "jwt"
+	FormatPASETO = "paseto"
+)
+
+// Format signs claims into a token string and verifies token strings back into claims.
+// Claims have the same semantics in every format: exp and iat are unix seconds.
+// Expiry is not checked if checkExpiry is false (signature still is).
+type Format interface {
+	Sign(claims map[string]any) (string, error)
+	Verify(token string, checkExpiry bool) (map[string]any, error)
+}
+
+// Formats holds every supported format and the ones selected for access and refresh tokens.
+// Access tokens use v4.public and refresh tokens use v4.local if PASETO is selected.
+// Tokens are verified only in the format selected for their type (typ claim).
+// Signed tokens of any format can be additionally encrypted (JWE).
+type Formats struct {
+	jwt        Format
+	public     Format
+	local      Format
+	access     Format
+	refresh    Format
+	encryption *encryption
+}
+
+// Create JWT format with the secret.
+// Create PASETO formats with configured keys (derived from the secret if not provided).
+// Select formats for access and refresh tokens.
+// Warn if a selected PASETO format uses the key derived from the secret (it is not separated from JWT one).
+// Load JWE keys.
+func New(cfg *config.Config) (*Formats, error) {
+	// Create JWT format with the secret.
+	f := &Formats{jwt: newJWT(cfg.Secret)}
+
+	// Create PASETO formats with configured keys (derived from the secret if not provided).
+	secretKey, err := pasetoSecretKey(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("PASETO_SECRET_KEY: %w", err)
+	}
+	f.public = newPasetoPublic(secretKey)
+
+	localKey, err := pasetoLocalKey(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("PASETO_LOCAL_KEY: %w", err)
+	}
+	f.local = newPasetoLocal(localKey)
+
+	// Select formats for access and refresh tokens.
+	if f.access, err = f.selectFormat(cfg.AccessFormat, f.public); err != nil {
+		return nil, err
+	}
+	if f.refresh, err = f.selectFormat(cfg.RefreshFormat, f.local); err != nil {
+		return nil, err
+	}
+
+	// Warn if a selected PASETO format uses the key derived from the secret (it is not separated from JWT one).
+	if f.access == f.public && cfg.PasetoSecretKey == "" {
+		slog.Warn("PASETO_SECRET_KEY is not set, access tokens are signed with a key derived from SECRET: anyone who knows SECRET can forge them, set a separate key")
+	}
+	if f.refresh == f.local && cfg.PasetoLocalKey == "" {
+		slog.Warn("PASETO_LOCAL_KEY is not set, refresh tokens are encrypted with a key derived from SECRET: anyone who knows SECRET can forge them, set a separate key")
+	}
+
+	// Load JWE keys.
+	if f.encryption, err = newEncryption(cfg.JWE); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Access() returns the format selected for access tokens.
+func (f *Formats) Access() Format {
+	return f.access
+}
+
+// Refresh() returns the format selected for refresh tokens.
+func (f *Formats) Refresh() Format {
+	return f.refresh
+}
+
+// Encrypt() wraps signed token into JWE if encryption is configured for its audience
+// (or for tokens without audience if audience is empty). Otherwise token is returned as is.
+func (f *Formats) Encrypt(token string, audience []string) (string, error) {
+	return f.encryption.Encrypt(token, audience)
+}
+
+// Verify() detects format of the token and verifies it including expiry (only selected formats are accepted).
+func (f *Formats) Verify(ctx context.Context, token string) (map[string]any, error) {
+	return f.verify(ctx, token, true)
+}
+
+// VerifyIgnoringExpiry() detects format of the token and verifies it, expired tokens are accepted (only selected formats are accepted).
+func (f *Formats) VerifyIgnoringExpiry(ctx context.Context, token string) (map[string]any, error) {
+	return f.verify(ctx, token, false)
+}
+
+// Check JWT secret is set if JWT is selected.
+// Sign probe claims in selected formats, encrypt them if it is configured and verify them back.
+func (f *Formats) Check(ctx context.Context) error {
+	// Check JWT secret is set if JWT is selected.
+	if (f.access == f.jwt || f.refresh == f.jwt) && len(f.jwt.(*jwtFormat).secret) == 0 {
+		return e.ErrKeysNotLoaded
+	}
+
+	// Sign probe claims in selected formats, encrypt them if it is configured and verify them back.
+	exp := time.Now().Add(time.Minute).Unix()
+	probes := map[string]Format{models.TypAccessToken: f.access, models.TypRefreshToken: f.refresh}
+	for typ, format := range probes {
+		token, err := format.Sign(map[string]any{"exp": exp, "typ": typ})
+		if err != nil {
+			return err
+		}
+		if token, err = f.Encrypt(token, nil); err != nil {
+			return err
+		}
+		if _, err := f.Verify(ctx, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decrypt token if it is encrypted.
+// Detect format of the token by its header.
+// Reject formats that are not selected.
+// Verify it accordingly.
+// Check that it is in the format selected for its type.
+func (f *Formats) verify(ctx context.Context, token string, checkExpiry bool) (map[string]any, error) {
+	// Decrypt token if it is encrypted.
+	if isEncrypted(token) {
+		decrypted, err := f.encryption.Decrypt(token)
+		if err != nil {
+			return nil, err
+		}
+		token = decrypted
+	}
+
+	// Detect format of the token by its header.
+	var format Format
+	switch {
+	case strings.HasPrefix(token, pasetoPublicHeader):
+		format = f.public
+	case strings.HasPrefix(token, pasetoLocalHeader):
+		format = f.local
+	case strings.Count(token, ".") == 2:
+		format = f.jwt
+	default:
+		slog.ErrorContext(ctx, "unknown token format")
+		return nil, e.ErrInvalidToken
+	}
+
+	// Reject formats that are not selected.
+	if format != f.access && format != f.refresh {
+		slog.ErrorContext(ctx, "token format is not selected")
+		return nil, e.ErrInvalidToken
+	}
+
+	// Verify it accordingly.
+	claims, err := format.Verify(token, checkExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check that it is in the format selected for its type.
+	expected := f.access
+	if claims["typ"] == models.TypRefreshToken {
+		expected = f.refresh
+	}
+	if format != expected {
+		slog.ErrorContext(ctx, "token is not in the format selected for its type")
+		return nil, e.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+func (f *Formats) selectFormat(name string, paseto Format) (Format, error) {
+	switch name {
+	case "", FormatJWT:
+		return f.jwt, nil
+	case FormatPASETO:
+		return paseto, nil
+	default:
+		return nil, e.ErrUnknownTokenFormat
+	}
+}
+
+func pasetoSecretKey(cfg *config.Config) (paseto.V4AsymmetricSecretKey, error) {
+	if cfg.PasetoSecretKey != "" {
+		return paseto.NewV4AsymmetricSecretKeyFromSeed(cfg.PasetoSecretKey)
+	}
+	seed := sha256.Sum256([]byte("paseto v4.public " + cfg.Secret))
+	return paseto.NewV4AsymmetricSecretKeyFromEd25519(ed25519.NewKeyFromSeed(seed[:]))
+}
+
+func pasetoLocalKey(cfg *config.Config) (paseto.V4SymmetricKey, error) {
+	if cfg.PasetoLocalKey != "" {
+		return paseto.V4SymmetricKeyFromHex(cfg.PasetoLocalKey)
+	}
+	key := sha256.Sum256([]byte("paseto v4.local " + cfg.Secret))
+	return paseto.V4SymmetricKeyFromBytes(key[:])
+}
@@ -0,0 +1,178 @@
+package tokens_test
+
+import (
+	"context"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/tokens"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testcases:
+// 1) jwt access and refresh tokens
+// 2) paseto access (v4.public) and refresh (v4.local) tokens
+// 3) paseto access and jwt refresh tokens
+func TestSignVerify(t *testing.T) {
+	testcases := []struct {
+		accessFormat          string
+		refreshFormat         string
+		expectedAccessPrefix  string
+		expectedRefreshPrefix string
+		name                  string
+	}{
+		{accessFormat: "jwt", refreshFormat: "jwt", expectedAccessPrefix: "eyJ", expectedRefreshPrefix: "eyJ", name: "1"},
+		{accessFormat: "paseto", refreshFormat: "paseto", expectedAccessPrefix: "v4.public.", expectedRefreshPrefix: "v4.local.", name: "2"},
+		{accessFormat: "paseto", refreshFormat: "", expectedAccessPrefix: "v4.public.", expectedRefreshPrefix: "eyJ", name: "3"},
+	}
+
+	for _, tc := range testcases {
+		t.Log(tc.name)
+		assert := assert.New(t)
+
+		formats, err := tokens.New(&config.Config{Secret: "secret", AccessFormat: tc.accessFormat, RefreshFormat: tc.refreshFormat})
+		assert.Nil(err)
+
+		exp := time.Now().Add(time.Minute).Unix()
+		claims := map[string]any{"guid": "user", "exp": exp, "coherent": "pair", "cnf": map[string]any{"jkt": "thumbprint"}}
+
+		access, err := formats.Access().Sign(claims)
+		assert.Nil(err)
+		assert.True(strings.HasPrefix(access, tc.expectedAccessPrefix))
+
+		refresh, err := formats.Refresh().Sign(withTyp(claims, "refresh"))
+		assert.Nil(err)
+		assert.True(strings.HasPrefix(refresh, tc.expectedRefreshPrefix))
+
+		for _, token := range []string{access, refresh} {
+			verified, err := formats.Verify(context.Background(), token)
+			assert.Nil(err)
+			assert.Equal("user", verified["guid"])
+			assert.Equal("pair", verified["coherent"])
+			assert.Equal(float64(exp), verified["exp"])
+			assert.Equal(map[string]any{"jkt": "thumbprint"}, verified["cnf"])
+		}
+	}
+}
+
+// Testcases:
+// 1) expired tokens are rejected unless expiry is ignored
+// 2) tokens signed with another secret are rejected
+// 3) malformed tokens are rejected
+func TestVerifyInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	formats := newFormats(t, &config.Config{Secret: "secret", AccessFormat: "paseto", RefreshFormat: "paseto"})
+	another := newFormats(t, &config.Config{Secret: "another", AccessFormat: "paseto", RefreshFormat: "paseto"})
+	expired := map[string]any{"guid": "user", "exp": time.Now().Add(-time.Minute).Unix()}
+	valid := map[string]any{"guid": "user", "exp": time.Now().Add(time.Minute).Unix()}
+
+	// 1) expired tokens are rejected unless expiry is ignored
+	for typ, format := range map[string]tokens.Format{"access": formats.Access(), "refresh": formats.Refresh()} {
+		token, err := format.Sign(withTyp(expired, typ))
+		assert.Nil(err)
+
+		_, err = formats.Verify(context.Background(), token)
+		assert.NotNil(err)
+
+		claims, err := formats.VerifyIgnoringExpiry(context.Background(), token)
+		assert.Nil(err)
+		assert.Equal("user", claims["guid"])
+	}
+
+	// 2) tokens signed with another secret are rejected
+	for typ, format := range map[string]tokens.Format{"access": another.Access(), "refresh": another.Refresh()} {
+		token, err := format.Sign(withTyp(valid, typ))
+		assert.Nil(err)
+
+		_, err = formats.VerifyIgnoringExpiry(context.Background(), token)
+		assert.NotNil(err)
+	}
+
+	// 3) malformed tokens are rejected
+	_, err := formats.Verify(context.Background(), "v4.public.garbage")
+	assert.NotNil(err)
+	_, err = formats.Verify(context.Background(), "garbage")
+	assert.NotNil(err)
+}
+
+// Testcases:
+// 1) jwt with the secret is ready
+// 2) jwt without the secret is not ready
+// 3) paseto keys are ready
+func TestCheck(t *testing.T) {
+	testcases := []struct {
+		cfg         config.Config
+		expectedErr error
+		name        string
+	}{
+		{cfg: config.Config{Secret: "secret"}, expectedErr: nil, name: "1"},
+		{cfg: config.Config{}, expectedErr: e.ErrKeysNotLoaded, name: "2"},
+		{cfg: config.Config{AccessFormat: "paseto", RefreshFormat: "paseto"}, expectedErr: nil, name: "3"},
+	}
+
+	for _, tc := range testcases {
+		t.Log(tc.name)
+		assert.Equal(t, tc.expectedErr, newFormats(t, &tc.cfg).Check(context.Background()))
+	}
+}
+
+// Testcases:
+// 1) jwt signed with the secret is rejected if paseto is selected
+// 2) refresh token in the access token format is rejected
+// 3) jwt signed with another algorithm is rejected
+// 4) malformed paseto keys are reported
+func TestVerifySelectedFormats(t *testing.T) {
+	assert := assert.New(t)
+	valid := map[string]any{"guid": "user", "exp": time.Now().Add(time.Minute).Unix()}
+
+	// 1
+	jwtFormats := newFormats(t, &config.Config{Secret: "secret"})
+	pasetoFormats := newFormats(t, &config.Config{Secret: "secret", AccessFormat: "paseto", RefreshFormat: "paseto"})
+	token, err := jwtFormats.Access().Sign(valid)
+	assert.Nil(err)
+	_, err = pasetoFormats.Verify(context.Background(), token)
+	assert.Equal(e.ErrInvalidToken, err)
+
+	// 2
+	mixed := newFormats(t, &config.Config{Secret: "secret", AccessFormat: "paseto"})
+	token, err = mixed.Access().Sign(withTyp(valid, "refresh"))
+	assert.Nil(err)
+	_, err = mixed.Verify(context.Background(), token)
+	assert.Equal(e.ErrInvalidToken, err)
+	token, err = mixed.Refresh().Sign(withTyp(valid, "refresh"))
+	assert.Nil(err)
+	_, err = mixed.Verify(context.Background(), token)
+	assert.Nil(err)
+
+	// 3
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims(valid)).SignedString([]byte("secret"))
+	assert.Nil(err)
+	_, err = jwtFormats.Verify(context.Background(), token)
+	assert.NotNil(err)
+
+	// 4
+	_, err = tokens.New(&config.Config{Secret: "secret", AccessFormat: "paseto", PasetoSecretKey: "not hex"})
+	assert.ErrorContains(err, "PASETO_SECRET_KEY")
+	_, err = tokens.New(&config.Config{Secret: "secret", RefreshFormat: "paseto", PasetoLocalKey: "abcd"})
+	assert.ErrorContains(err, "PASETO_LOCAL_KEY")
+}
+
+func newFormats(t *testing.T, cfg *config.Config) *tokens.Formats {
+	formats, err := tokens.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return formats
+}
+
+func withTyp(claims map[string]any, typ string) map[string]any {
+	typed := maps.Clone(claims)
+	typed["typ"] = typ
+	return typed
+}
@@ -0,0 +1,202 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"strings"
+
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/go-jose/go-jose/v4"
+)
+
+// Content encryption of every JWE token.
+const contentEncryption = jose.A256GCM
+
+var keyAlgorithms = []jose.KeyAlgorithm{jose.RSA_OAEP_256, jose.ECDH_ES}
+
+// JWE wrapping of signed tokens (nested JWT, or PASETO inside JWE).
+// Encrypters are selected by token audience, keys decrypt tokens addressed to the service itself.
+type encryption struct {
+	def       jose.Encrypter
+	audiences map[string]jose.Encrypter
+	recipient map[string]config.JWERecipient
+	keys      []any
+}
+
+// Load decryption keys.
+// Create encrypter for tokens without audience.
+// Create encrypters for the audiences.
+func newEncryption(cfg *config.JWE) (*encryption, error) {
+	enc := &encryption{
+		audiences: make(map[string]jose.Encrypter),
+		recipient: make(map[string]config.JWERecipient),
+	}
+	if cfg == nil {
+		return enc, nil
+	}
+
+	// Load decryption keys.
+	for _, file := range cfg.DecryptionKeys {
+		key, err := loadPrivateKey(file)
+		if err != nil {
+			return nil, err
+		}
+		enc.keys = append(enc.keys, key)
+	}
+
+	// Create encrypter for tokens without audience.
+	if cfg.Default != nil {
+		encrypter, err := newEncrypter(*cfg.Default)
+		if err != nil {
+			return nil, err
+		}
+		enc.def = encrypter
+	}
+
+	// Create encrypters for the audiences.
+	for aud, recipient := range cfg.Audiences {
+		encrypter, err := newEncrypter(recipient)
+		if err != nil {
+			return nil, err
+		}
+		enc.audiences[aud] = encrypter
+		enc.recipient[aud] = recipient
+	}
+
+	return enc, nil
+}
+
+// Select encrypter by audience (every encrypted audience must share the same recipient).
+// Return token as is if it should not be encrypted.
+// Encrypt it.
+func (c *encryption) Encrypt(token string, audience []string) (string, error) {
+	// Select encrypter by audience (every encrypted audience must share the same recipient).
+	encrypter := c.def
+	if len(audience) != 0 {
+		encrypter = nil
+		var selected *config.JWERecipient
+		for _, aud := range audience {
+			recipient, ok := c.recipient[aud]
+			if !ok {
+				continue
+			}
+			if selected != nil && *selected != recipient {
+				return "", e.ErrAmbiguousEncryption
+			}
+			selected = &recipient
+			encrypter = c.audiences[aud]
+		}
+	}
+
+	// Return token as is if it should not be encrypted.
+	if encrypter == nil {
+		return token, nil
+	}
+
+	// Encrypt it.
+	object, err := encrypter.Encrypt([]byte(token))
+	if err != nil {
+		return "", err
+	}
+	return object.CompactSerialize()
+}
+
+// Parse compact JWE.
+// Try to decrypt it with every service key.
+func (c *encryption) Decrypt(token string) (string, error) {
+	// Parse compact JWE.
+	object, err := jose.ParseEncryptedCompact(token, keyAlgorithms, []jose.ContentEncryption{contentEncryption})
+	if err != nil {
+		return "", err
+	}
+
+	// Try to decrypt it with every service key.
+	for _, key := range c.keys {
+		plain, err := object.Decrypt(key)
+		if err == nil {
+			return string(plain), nil
+		}
+	}
+
+	return "", e.ErrInvalidToken
+}
+
+// Compact JWE has five segments.
+func isEncrypted(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+func newEncrypter(recipient config.JWERecipient) (jose.Encrypter, error) {
+	key, err := loadPublicKey(recipient.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := jose.KeyAlgorithm(recipient.Algorithm)
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if alg != jose.RSA_OAEP_256 {
+			return nil, e.ErrUnsupportedKey
+		}
+	case *ecdsa.PublicKey:
+		if alg != jose.ECDH_ES {
+			return nil, e.ErrUnsupportedKey
+		}
+	default:
+		return nil, e.ErrUnsupportedKey
+	}
+
+	return jose.NewEncrypter(contentEncryption, jose.Recipient{Algorithm: alg, Key: key},
+		(&jose.EncrypterOptions{}).WithContentType("JWT").WithType("JWT"))
+}
+
+// PEM "PUBLIC KEY" (PKIX) or certificate.
+func loadPublicKey(file string) (any, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// PEM "PRIVATE KEY" (PKCS8), "RSA PRIVATE KEY" or "EC PRIVATE KEY".
+func loadPrivateKey(file string) (any, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, e.ErrUnsupportedKey
+	}
+	return block, nil
+}
@@ -0,0 +1,117 @@
+package tokens_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/tokens"
+	"github.com/stretchr/testify/assert"
+)
+
+// Write PKCS8 private and PKIX public keys, return their paths.
+func writeKeys(t *testing.T, dir, name string, private any, public any) (string, string) {
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateFile := filepath.Join(dir, name+".key")
+	publicFile := filepath.Join(dir, name+".pub")
+	if err := os.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return privateFile, publicFile
+}
+
+// Testcases:
+// 1) token without audience is encrypted to the default recipient and decrypted by the service
+// 2) token for an encrypted audience is encrypted to its recipient and not readable by the service
+// 3) token for an audience without encryption is left as is
+// 4) token for audiences with different recipients is rejected
+func TestEncrypt(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPrivate, rsaPublic := writeKeys(t, dir, "service", rsaKey, &rsaKey.PublicKey)
+	_, ecPublic := writeKeys(t, dir, "billing", ecKey, &ecKey.PublicKey)
+
+	formats, err := tokens.New(&config.Config{
+		Secret:       "secret",
+		AccessFormat: "paseto",
+		JWE: &config.JWE{
+			DecryptionKeys: []string{rsaPrivate},
+			Default:        &config.JWERecipient{Algorithm: "RSA-OAEP-256", KeyFile: rsaPublic},
+			Audiences: map[string]config.JWERecipient{
+				"billing": {Algorithm: "ECDH-ES", KeyFile: ecPublic},
+				"reports": {Algorithm: "RSA-OAEP-256", KeyFile: rsaPublic},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := map[string]any{"guid": "user", "exp": time.Now().Add(time.Minute).Unix()}
+	signed, err := formats.Access().Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		audience          []string
+		expectedEncrypted bool
+		expectedReadable  bool
+		expectedError     error
+		name              string
+	}{
+		{audience: nil, expectedEncrypted: true, expectedReadable: true, expectedError: nil, name: "1"},
+		{audience: []string{"billing"}, expectedEncrypted: true, expectedReadable: false, expectedError: nil, name: "2"},
+		{audience: []string{"orders"}, expectedEncrypted: false, expectedReadable: true, expectedError: nil, name: "3"},
+		{audience: []string{"billing", "reports"}, expectedError: e.ErrAmbiguousEncryption, name: "4"},
+	}
+
+	for _, tc := range testcases {
+		t.Log(tc.name)
+		assert := assert.New(t)
+
+		token, err := formats.Encrypt(signed, tc.audience)
+		assert.Equal(tc.expectedError, err)
+		if err != nil {
+			continue
+		}
+
+		assert.Equal(tc.expectedEncrypted, strings.Count(token, ".") == 4)
+		verified, err := formats.Verify(context.Background(), token)
+		if !tc.expectedReadable {
+			assert.NotNil(err)
+			continue
+		}
+		assert.Nil(err)
+		assert.Equal("user", verified["guid"])
+	}
+}
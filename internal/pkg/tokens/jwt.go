@@ -0,0 +1,54 @@
+package tokens
+
+import (
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// HS512 signed JWT.
+type jwtFormat struct {
+	secret []byte
+}
+
+func newJWT(secret string) *jwtFormat {
+	return &jwtFormat{secret: []byte(secret)}
+}
+
+func (j *jwtFormat) Sign(claims map[string]any) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims(claims)).SignedString(j.secret)
+}
+
+// Parse token from provided string.
+// Check if it is valid.
+// Return its claims.
+func (j *jwtFormat) Verify(tokenString string, checkExpiry bool) (map[string]any, error) {
+	options := []jwt.ParserOption{jwt.WithValidMethods([]string{jwt.SigningMethodHS512.Alg()})}
+	if !checkExpiry {
+		options = append(options, jwt.WithoutClaimsValidation())
+	}
+
+	// Parse token from provided string.
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		_, ok := t.Method.(*jwt.SigningMethodHMAC)
+		if !ok {
+			return nil, e.ErrInvalidSigningMethod
+		}
+		return j.secret, nil
+	}, options...)
+
+	// Check if it is valid.
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, e.ErrInvalidToken
+	}
+
+	// Return its claims.
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, e.ErrInvalidToken
+	}
+
+	return claims, nil
+}
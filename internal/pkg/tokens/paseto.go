@@ -0,0 +1,125 @@
+package tokens
+
+import (
+	"time"
+
+	"aidanwoods.dev/go-paseto"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+)
+
+const (
+	pasetoPublicHeader = "v4.public."
+	pasetoLocalHeader  = "v4.local."
+)
+
+// Registered PASETO claims are RFC 3339 strings, the rest of the service uses unix seconds.
+var timeClaims = []string{"exp", "iat", "nbf"}
+
+// PASETO v4.public (Ed25519 signed).
+type pasetoPublic struct {
+	secret paseto.V4AsymmetricSecretKey
+	public paseto.V4AsymmetricPublicKey
+}
+
+func newPasetoPublic(secret paseto.V4AsymmetricSecretKey) *pasetoPublic {
+	return &pasetoPublic{secret: secret, public: secret.Public()}
+}
+
+func (p *pasetoPublic) Sign(claims map[string]any) (string, error) {
+	token, err := toPaseto(claims)
+	if err != nil {
+		return "", err
+	}
+	return token.V4Sign(p.secret, nil), nil
+}
+
+func (p *pasetoPublic) Verify(tokenString string, checkExpiry bool) (map[string]any, error) {
+	token, err := parser(checkExpiry).ParseV4Public(p.public, tokenString, nil)
+	if err != nil {
+		return nil, err
+	}
+	return fromPaseto(token)
+}
+
+// PASETO v4.local (XChaCha20 encrypted, BLAKE2b authenticated).
+type pasetoLocal struct {
+	key paseto.V4SymmetricKey
+}
+
+func newPasetoLocal(key paseto.V4SymmetricKey) *pasetoLocal {
+	return &pasetoLocal{key: key}
+}
+
+func (p *pasetoLocal) Sign(claims map[string]any) (string, error) {
+	token, err := toPaseto(claims)
+	if err != nil {
+		return "", err
+	}
+	return token.V4Encrypt(p.key, nil), nil
+}
+
+func (p *pasetoLocal) Verify(tokenString string, checkExpiry bool) (map[string]any, error) {
+	token, err := parser(checkExpiry).ParseV4Local(p.key, tokenString, nil)
+	if err != nil {
+		return nil, err
+	}
+	return fromPaseto(token)
+}
+
+func parser(checkExpiry bool) paseto.Parser {
+	if checkExpiry {
+		return paseto.NewParser()
+	}
+	return paseto.NewParserWithoutExpiryCheck()
+}
+
+// Convert unix time claims to RFC 3339.
+// Make token from claims.
+func toPaseto(claims map[string]any) (*paseto.Token, error) {
+	converted := make(map[string]any, len(claims))
+	for k, v := range claims {
+		converted[k] = v
+	}
+
+	// Convert unix time claims to RFC 3339.
+	for _, claim := range timeClaims {
+		v, ok := converted[claim]
+		if !ok {
+			continue
+		}
+		var unix int64
+		switch t := v.(type) {
+		case int64:
+			unix = t
+		case float64:
+			unix = int64(t)
+		default:
+			return nil, e.ErrInvalidToken
+		}
+		converted[claim] = time.Unix(unix, 0).UTC().Format(time.RFC3339)
+	}
+
+	// Make token from claims.
+	return paseto.MakeToken(converted, nil)
+}
+
+// Take claims from token.
+// Convert RFC 3339 time claims to unix seconds.
+func fromPaseto(token *paseto.Token) (map[string]any, error) {
+	// Take claims from token.
+	claims := token.Claims()
+
+	// Convert RFC 3339 time claims to unix seconds.
+	for _, claim := range timeClaims {
+		if _, ok := claims[claim]; !ok {
+			continue
+		}
+		t, err := token.GetTime(claim)
+		if err != nil {
+			return nil, err
+		}
+		claims[claim] = float64(t.Unix())
+	}
+
+	return claims, nil
+}
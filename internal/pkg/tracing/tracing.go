@@ -0,0 +1,92 @@
+// Package tracing sets up OpenTelemetry tracing of the service.
+// Spans are exported over OTLP if OTEL_TRACES_EXPORTER=otlp (endpoint, headers and so on are read from
+// the standard OTEL_EXPORTER_OTLP_* variables), otherwise tracing is a no-op.
+// W3C trace context is propagated in any case.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/VanLavr/auth/internal/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	tracerName  = "github.com/VanLavr/auth"
+	serviceName = "auth"
+)
+
+// Install W3C trace context propagation.
+// Keep no-op tracing if exporter is not configured.
+// Export spans over OTLP in batches, resource attributes can be overridden by OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES.
+// Returned function flushes and stops the exporter.
+func Setup(ctx context.Context, cfg *config.Config) (func(context.Context) error, error) {
+	// Install W3C trace context propagation.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	// Keep no-op tracing if exporter is not configured.
+	if cfg.TracesExporter != config.TracesExporterOTLP {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// Export spans over OTLP in batches.
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// SetupInMemory() makes every span to be exported synchronously to the returned exporter (for tests).
+// Returned function restores no-op tracing.
+func SetupInMemory() (*tracetest.InMemoryExporter, func()) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	return exporter, func() { otel.SetTracerProvider(noop.NewTracerProvider()) }
+}
+
+// Start() starts a span as a child of the span in the context.
+// The context is returned untouched if the span is not recorded (tracing is off or the trace is not sampled).
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	spanCtx, span := otel.Tracer(tracerName).Start(ctx, name, opts...)
+	if !span.IsRecording() {
+		return ctx, span
+	}
+	return spanCtx, span
+}
+
+// End() marks the span as failed if there is an error and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Extract() returns context of the request with the remote span from its trace context headers.
+func Extract(r *http.Request) context.Context {
+	return otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+}
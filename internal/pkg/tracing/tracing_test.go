@@ -0,0 +1,60 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VanLavr/auth/internal/pkg/config"
+	"github.com/VanLavr/auth/internal/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Without exporter spans are not recorded and contexts are untouched.
+func TestNoop(t *testing.T) {
+	assert := assert.New(t)
+	flush, err := tracing.Setup(context.Background(), &config.Config{})
+	assert.Nil(err)
+
+	ctx, span := tracing.Start(context.Background(), "noop")
+	tracing.End(span, nil)
+
+	assert.False(span.IsRecording())
+	assert.Equal(context.Background(), ctx)
+	assert.Nil(flush(context.Background()))
+}
+
+// Testcases:
+// 1) span continues the trace of the incoming request
+// 2) child span has the parent from context
+// 3) failed span has error status
+func TestSpans(t *testing.T) {
+	assert := assert.New(t)
+	exporter, restore := tracing.SetupInMemory()
+	defer restore()
+
+	// 1
+	r := httptest.NewRequest("GET", "/restricted", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, parent := tracing.Start(tracing.Extract(r), "GET /restricted", trace.WithSpanKind(trace.SpanKindServer))
+
+	// 2
+	_, child := tracing.Start(ctx, "child")
+
+	// 3
+	tracing.End(child, errors.New("failed"))
+	tracing.End(parent, nil)
+
+	spans := exporter.GetSpans()
+	if !assert.Len(spans, 2) {
+		return
+	}
+	assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", spans[1].SpanContext.TraceID().String())
+	assert.Equal("00f067aa0ba902b7", spans[1].Parent.SpanID().String())
+	assert.Equal(spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(codes.Error, spans[0].Status.Code)
+	assert.Equal(codes.Unset, spans[1].Status.Code)
+}
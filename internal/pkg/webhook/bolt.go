@@ -0,0 +1,282 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// Pending deliveries in a bucket per subscription keyed by next attempt and id, so due ones are found by seeking.
+	pendingBucket = []byte("pending_due")
+	// Next attempt key of every pending delivery by its id.
+	indexBucket = []byte("pending_index")
+	deadBucket  = []byte("dead")
+	logBucket   = []byte("delivery_log")
+
+	// Buckets of deliveries keyed by id only, they are rekeyed on open.
+	legacyPendingBucket = []byte("pending")
+	legacyDeadBucket    = []byte("dead_letters")
+)
+
+// BoltQueue keeps deliveries in a bbolt file, so pending ones survive restarts.
+// Pending deliveries are kept per subscription in the order of their next attempt,
+// dead letters and the delivery log by their sequence (the oldest ones are dropped).
+type BoltQueue struct {
+	db *bolt.DB
+}
+
+func OpenBoltQueue(path string) (*BoltQueue, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{pendingBucket, indexBucket, deadBucket, logBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return rekey(tx)
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltQueue{db: db}, nil
+}
+
+// Move deliveries of the buckets keyed by id to the current ones.
+func rekey(tx *bolt.Tx) error {
+	for _, legacy := range [][]byte{legacyPendingBucket, legacyDeadBucket} {
+		bucket := tx.Bucket(legacy)
+		if bucket == nil {
+			continue
+		}
+		err := bucket.ForEach(func(k, v []byte) error {
+			var delivery Delivery
+			if err := json.Unmarshal(v, &delivery); err != nil {
+				return err
+			}
+			if bytes.Equal(legacy, legacyDeadBucket) {
+				return putDead(tx, delivery)
+			}
+			return putPending(tx, delivery)
+		})
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteBucket(legacy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BoltQueue) Push(ctx context.Context, deliveries ...Delivery) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, delivery := range deliveries {
+			if err := putPending(tx, delivery); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Seek due deliveries of the subscription (of every one if it is empty), they are ordered by next attempt.
+func (b *BoltQueue) Due(ctx context.Context, subscription string, now time.Time, limit int) ([]Delivery, error) {
+	var due []Delivery
+	err := b.db.View(func(tx *bolt.Tx) error {
+		pending := tx.Bucket(pendingBucket)
+		if subscription != "" {
+			var err error
+			due, err = dueOf(pending.Bucket([]byte(subscription)), now, limit)
+			return err
+		}
+		return pending.ForEachBucket(func(name []byte) error {
+			of, err := dueOf(pending.Bucket(name), now, limit)
+			due = append(due, of...)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return earliest(due, limit), nil
+}
+
+// Up to limit deliveries of the subscription bucket (nil if it has none) that are due at now.
+func dueOf(bucket *bolt.Bucket, now time.Time, limit int) ([]Delivery, error) {
+	if bucket == nil {
+		return nil, nil
+	}
+	var due []Delivery
+	end := attemptKey(now)
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil && bytes.Compare(k[:len(end)], end) <= 0 && (limit <= 0 || len(due) < limit); k, v = cursor.Next() {
+		var delivery Delivery
+		if err := json.Unmarshal(v, &delivery); err != nil {
+			return nil, err
+		}
+		due = append(due, delivery)
+	}
+	return due, nil
+}
+
+func (b *BoltQueue) Done(ctx context.Context, delivery Delivery, dead bool) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := deletePending(tx, delivery); err != nil {
+			return err
+		}
+		if !dead {
+			return nil
+		}
+		return putDead(tx, delivery)
+	})
+}
+
+func (b *BoltQueue) DeadLetters(ctx context.Context, subscription string, limit int) ([]Delivery, error) {
+	var dead []Delivery
+	err := b.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(deadBucket).Cursor()
+		for k, v := cursor.First(); k != nil && (limit <= 0 || len(dead) < limit); k, v = cursor.Next() {
+			var delivery Delivery
+			if err := json.Unmarshal(v, &delivery); err != nil {
+				return err
+			}
+			if subscription == "" || delivery.Subscription == subscription {
+				dead = append(dead, delivery)
+			}
+		}
+		return nil
+	})
+	return dead, err
+}
+
+// Append the attempt under the next sequence.
+// Drop attempts older than the max log size (every append drops one of them).
+func (b *BoltQueue) Log(ctx context.Context, attempt Attempt) error {
+	value, err := json.Marshal(attempt)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		// Append the attempt under the next sequence.
+		bucket := tx.Bucket(logBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(sequenceKey(seq), value); err != nil {
+			return err
+		}
+
+		// Drop attempts older than the max log size (every append drops one of them).
+		if seq <= maxLogSize {
+			return nil
+		}
+		return bucket.Delete(sequenceKey(seq - maxLogSize))
+	})
+}
+
+func (b *BoltQueue) Attempts(ctx context.Context, subscription string, limit int) ([]Attempt, error) {
+	var attempts []Attempt
+	err := b.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(logBucket).Cursor()
+		for k, v := cursor.Last(); k != nil && (limit <= 0 || len(attempts) < limit); k, v = cursor.Prev() {
+			var attempt Attempt
+			if err := json.Unmarshal(v, &attempt); err != nil {
+				return err
+			}
+			if subscription == "" || attempt.Subscription == subscription {
+				attempts = append(attempts, attempt)
+			}
+		}
+		return nil
+	})
+	return attempts, err
+}
+
+func (b *BoltQueue) Close(ctx context.Context) error {
+	return b.db.Close()
+}
+
+// Remove the previous schedule of the delivery if it was pending.
+// Store it under its next attempt in the bucket of its subscription and index it.
+func putPending(tx *bolt.Tx, delivery Delivery) error {
+	// Remove the previous schedule of the delivery if it was pending.
+	if err := deletePending(tx, delivery); err != nil {
+		return err
+	}
+
+	// Store it under its next attempt in the bucket of its subscription and index it.
+	value, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	bucket, err := tx.Bucket(pendingBucket).CreateBucketIfNotExists([]byte(delivery.Subscription))
+	if err != nil {
+		return err
+	}
+	key := append(attemptKey(delivery.NextAttempt), delivery.ID...)
+	if err := bucket.Put(key, value); err != nil {
+		return err
+	}
+	return tx.Bucket(indexBucket).Put([]byte(delivery.ID), key)
+}
+
+// Remove the pending delivery by its indexed key, nothing is removed if it is not pending.
+func deletePending(tx *bolt.Tx, delivery Delivery) error {
+	index := tx.Bucket(indexBucket)
+	key := index.Get([]byte(delivery.ID))
+	if key == nil {
+		return nil
+	}
+	if bucket := tx.Bucket(pendingBucket).Bucket([]byte(delivery.Subscription)); bucket != nil {
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+	return index.Delete([]byte(delivery.ID))
+}
+
+// Append the dead letter under the next sequence.
+// Drop dead letters older than the max number of them (every append drops one of them).
+func putDead(tx *bolt.Tx, delivery Delivery) error {
+	value, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	// Append the dead letter under the next sequence.
+	bucket := tx.Bucket(deadBucket)
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put(sequenceKey(seq), value); err != nil {
+		return err
+	}
+
+	// Drop dead letters older than the max number of them (every append drops one of them).
+	if seq <= maxDeadLetters {
+		return nil
+	}
+	return bucket.Delete(sequenceKey(seq - maxDeadLetters))
+}
+
+// Big endian unix nanoseconds keep pending deliveries in the order of their next attempt.
+func attemptKey(t time.Time) []byte {
+	return sequenceKey(uint64(t.UnixNano()))
+}
+
+// Big endian keys keep the log in sequence order.
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
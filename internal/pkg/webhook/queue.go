@@ -0,0 +1,181 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/config"
+)
+
+// Results of delivery attempts.
+const (
+	ResultDelivered = "delivered"
+	ResultRetry     = "retry"
+	ResultDead      = "dead"
+)
+
+const (
+	// Attempts kept in the delivery log, older ones are dropped.
+	maxLogSize = 10000
+	// Dead letters kept, older ones are dropped.
+	maxDeadLetters = 10000
+)
+
+// Delivery is an event queued for an endpoint.
+type Delivery struct {
+	ID           string          `json:"id"`
+	Subscription string          `json:"subscription"`
+	Event        string          `json:"event"`
+	Payload      json.RawMessage `json:"payload"`
+	Attempts     int             `json:"attempts"`
+	CreatedAt    time.Time       `json:"created_at"`
+	NextAttempt  time.Time       `json:"next_attempt"`
+	LastError    string          `json:"last_error,omitempty"`
+}
+
+// Attempt is a record of the delivery log.
+type Attempt struct {
+	Delivery     string        `json:"delivery"`
+	Subscription string        `json:"subscription"`
+	Event        string        `json:"event"`
+	Attempt      int           `json:"attempt"`
+	Time         time.Time     `json:"time"`
+	Duration     time.Duration `json:"duration"`
+	StatusCode   int           `json:"status_code,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	Result       string        `json:"result"`
+}
+
+// Queue keeps pending deliveries, dead letters and the delivery log.
+type Queue interface {
+	// Push() stores new or rescheduled deliveries at once.
+	Push(context.Context, ...Delivery) error
+	// Due() returns up to limit pending deliveries (of the subscription if it is not empty)
+	// whose next attempt is not after now, earliest first.
+	Due(ctx context.Context, subscription string, now time.Time, limit int) ([]Delivery, error)
+	// Done() removes the delivery from pending ones, dead deliveries are kept as dead letters.
+	Done(ctx context.Context, delivery Delivery, dead bool) error
+	// DeadLetters() returns up to limit dead letters (of the subscription if it is not empty), oldest first.
+	DeadLetters(ctx context.Context, subscription string, limit int) ([]Delivery, error)
+	// Log() appends the attempt to the delivery log.
+	Log(context.Context, Attempt) error
+	// Attempts() returns up to limit latest attempts (of the subscription if it is not empty), newest first.
+	Attempts(ctx context.Context, subscription string, limit int) ([]Attempt, error)
+	Close(context.Context) error
+}
+
+// OpenQueue() opens the bolt queue if its file is configured, otherwise deliveries are kept in memory
+// (a warning is logged if webhooks are configured, pending retries are lost on restart).
+func OpenQueue(cfg *config.Config) (Queue, error) {
+	if cfg.WebhookQueue == "" {
+		if len(cfg.Webhooks) > 0 {
+			slog.Warn("WEBHOOK_QUEUE is not set, webhook deliveries are kept in memory: pending retries and dead letters are lost on restart, set a queue file")
+		}
+		return NewMemoryQueue(), nil
+	}
+	return OpenBoltQueue(cfg.WebhookQueue)
+}
+
+// MemoryQueue keeps deliveries in memory, they are lost on restart.
+type MemoryQueue struct {
+	mu      sync.Mutex
+	pending map[string]Delivery
+	dead    []Delivery
+	log     []Attempt
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{pending: make(map[string]Delivery)}
+}
+
+func (m *MemoryQueue) Push(ctx context.Context, deliveries ...Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, delivery := range deliveries {
+		m.pending[delivery.ID] = delivery
+	}
+	return nil
+}
+
+func (m *MemoryQueue) Due(ctx context.Context, subscription string, now time.Time, limit int) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var due []Delivery
+	for _, delivery := range m.pending {
+		if delivery.due(subscription, now) {
+			due = append(due, delivery)
+		}
+	}
+	return earliest(due, limit), nil
+}
+
+func (m *MemoryQueue) Done(ctx context.Context, delivery Delivery, dead bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pending, delivery.ID)
+	if dead {
+		m.dead = append(m.dead, delivery)
+		if len(m.dead) > maxDeadLetters {
+			m.dead = m.dead[len(m.dead)-maxDeadLetters:]
+		}
+	}
+	return nil
+}
+
+func (m *MemoryQueue) DeadLetters(ctx context.Context, subscription string, limit int) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var dead []Delivery
+	for _, delivery := range m.dead {
+		if (subscription == "" || delivery.Subscription == subscription) && (limit <= 0 || len(dead) < limit) {
+			dead = append(dead, delivery)
+		}
+	}
+	return dead, nil
+}
+
+func (m *MemoryQueue) Log(ctx context.Context, attempt Attempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.log = append(m.log, attempt)
+	if len(m.log) > maxLogSize {
+		m.log = m.log[len(m.log)-maxLogSize:]
+	}
+	return nil
+}
+
+func (m *MemoryQueue) Attempts(ctx context.Context, subscription string, limit int) ([]Attempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var attempts []Attempt
+	for i := len(m.log) - 1; i >= 0 && (limit <= 0 || len(attempts) < limit); i-- {
+		if subscription == "" || m.log[i].Subscription == subscription {
+			attempts = append(attempts, m.log[i])
+		}
+	}
+	return attempts, nil
+}
+
+func (m *MemoryQueue) Close(ctx context.Context) error {
+	return nil
+}
+
+// Sort deliveries by next attempt and keep up to limit of them.
+func earliest(deliveries []Delivery, limit int) []Delivery {
+	slices.SortFunc(deliveries, func(a, b Delivery) int {
+		return a.NextAttempt.Compare(b.NextAttempt)
+	})
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries
+}
+
+// Check if the delivery belongs to the subscription (any if it is empty) and is due at now.
+func (d Delivery) due(subscription string, now time.Time) bool {
+	return (subscription == "" || d.Subscription == subscription) && !d.NextAttempt.After(now)
+}
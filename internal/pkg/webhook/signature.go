@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+)
+
+// SignatureHeader carries "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">".
+// The timestamp is signed too, so receivers can reject replayed payloads.
+const SignatureHeader = "X-Webhook-Signature"
+
+// Sign() returns the signature header value of the body sent at t.
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, mac(secret, timestamp, body))
+}
+
+// Parse the header.
+// Check that it was signed within tolerance of now.
+// Compare the signature in constant time.
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	// Parse the header.
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signature == "" {
+		return e.ErrInvalidSignature
+	}
+
+	// Check that it was signed within tolerance of now.
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return e.ErrInvalidSignature
+	}
+
+	// Compare the signature in constant time.
+	if !hmac.Equal([]byte(signature), []byte(mac(secret, timestamp, body))) {
+		return e.ErrInvalidSignature
+	}
+	return nil
+}
+
+func mac(secret, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,356 @@
+// Package webhook delivers audited authentication events to subscribed endpoints.
+// Every event is queued for the endpoints subscribed to it and posted with an HMAC signature (./signature.go).
+// Failed deliveries are retried with exponential backoff and dead-lettered after the configured number of attempts,
+// every attempt is written to the delivery log. Every endpoint is delivered to independently, so a slow one
+// does not hold up the others.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	"github.com/VanLavr/auth/internal/pkg/metrics"
+)
+
+// Headers of delivered requests besides the signature.
+const (
+	IDHeader    = "X-Webhook-ID"
+	EventHeader = "X-Webhook-Event"
+)
+
+const (
+	// Deliveries of an endpoint attempted at once.
+	batchSize = 100
+	// Interval of polling the queue for retries.
+	pollInterval = time.Second
+	// Max delay between attempts.
+	maxBackoff = time.Hour
+)
+
+// Payload is the body of delivered requests. ID is the same for every attempt of the delivery,
+// so receivers can drop duplicates.
+type Payload struct {
+	ID        string            `json:"id"`
+	Event     string            `json:"event"`
+	Time      time.Time         `json:"time"`
+	GUID      string            `json:"guid,omitempty"`
+	Actor     string            `json:"actor,omitempty"`
+	ClientIP  string            `json:"client_ip,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// Dispatcher queues events and delivers them.
+type Dispatcher struct {
+	subscriptions map[string]config.Webhook
+	order         []config.Webhook
+	queue         Queue
+	client        *http.Client
+	attempts      int
+	backoff       time.Duration
+	// Wakes the worker of every subscription when its deliveries are queued.
+	wake map[string]chan struct{}
+}
+
+func New(cfg *config.Config, queue Queue) *Dispatcher {
+	subscriptions := make(map[string]config.Webhook, len(cfg.Webhooks))
+	wake := make(map[string]chan struct{}, len(cfg.Webhooks))
+	for _, w := range cfg.Webhooks {
+		subscriptions[w.ID] = w
+		wake[w.ID] = make(chan struct{}, 1)
+	}
+	return &Dispatcher{
+		subscriptions: subscriptions,
+		order:         cfg.Webhooks,
+		queue:         queue,
+		client:        &http.Client{Timeout: cfg.WebhookTimeout},
+		attempts:      cfg.WebhookAttempts,
+		backoff:       cfg.WebhookBackoff,
+		wake:          wake,
+	}
+}
+
+// Notify() queues the audited entry for every endpoint subscribed to its event (all of them at once) and wakes their workers.
+// It is called by the audit writer after the entry is appended, failures are logged and do not fail the audited action.
+func (d *Dispatcher) Notify(ctx context.Context, entry audit.Entry) {
+	now := time.Now()
+	var deliveries []Delivery
+	for _, w := range d.order {
+		if !w.Subscribed(entry.Event) {
+			continue
+		}
+
+		id := newID()
+		payload, err := json.Marshal(Payload{
+			ID:        id,
+			Event:     entry.Event,
+			Time:      entry.Time.UTC(),
+			GUID:      entry.GUID,
+			Actor:     entry.Actor,
+			ClientIP:  entry.ClientIP,
+			RequestID: entry.RequestID,
+			Details:   entry.Details,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, err.Error())
+			continue
+		}
+		deliveries = append(deliveries, Delivery{
+			ID:           id,
+			Subscription: w.ID,
+			Event:        entry.Event,
+			Payload:      payload,
+			CreatedAt:    now,
+			NextAttempt:  now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+
+	if err := d.queue.Push(ctx, deliveries...); err != nil {
+		slog.ErrorContext(ctx, "webhook is not queued: "+err.Error(), "event", entry.Event, "subscriptions", len(deliveries))
+		return
+	}
+	for _, delivery := range deliveries {
+		select {
+		case d.wake[delivery.Subscription] <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run() dead-letters deliveries of subscriptions removed from config, then delivers queued events of every endpoint
+// in its own worker when they are notified and polls the queue for retries until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	if err := d.dropRemoved(ctx, time.Now()); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
+
+	var workers sync.WaitGroup
+	for _, w := range d.order {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			d.run(ctx, w.ID)
+		}()
+	}
+	workers.Wait()
+}
+
+// Deliver queued events of the subscription when they are notified and poll the queue for retries until ctx is done.
+func (d *Dispatcher) run(ctx context.Context, subscription string) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake[subscription]:
+		}
+
+		// Keep delivering while batches are full.
+		for {
+			n, err := d.deliver(ctx, subscription, time.Now())
+			if err != nil {
+				slog.ErrorContext(ctx, err.Error())
+			}
+			if err != nil || n < batchSize || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+}
+
+// Deliver() attempts deliveries that are due at now, every endpoint concurrently,
+// and returns the number of attempted ones.
+func (d *Dispatcher) Deliver(ctx context.Context, now time.Time) (int, error) {
+	var (
+		workers   sync.WaitGroup
+		mu        sync.Mutex
+		attempted int
+		errs      []error
+	)
+	for _, w := range d.order {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			n, err := d.deliver(ctx, w.ID, now)
+			mu.Lock()
+			defer mu.Unlock()
+			attempted += n
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}()
+	}
+	workers.Wait()
+	return attempted, errors.Join(errs...)
+}
+
+// Attempt deliveries of the subscription that are due at now and return the number of attempted ones.
+func (d *Dispatcher) deliver(ctx context.Context, subscription string, now time.Time) (int, error) {
+	due, err := d.queue.Due(ctx, subscription, now, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, delivery := range due {
+		if err := d.attempt(ctx, delivery, now); err != nil {
+			return 0, err
+		}
+	}
+	return len(due), nil
+}
+
+// Dead-letter pending deliveries of subscriptions removed from config, they have no endpoint to be delivered to.
+func (d *Dispatcher) dropRemoved(ctx context.Context, now time.Time) error {
+	// Retries are never scheduled later than maxBackoff.
+	pending, err := d.queue.Due(ctx, "", now.Add(maxBackoff), 0)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range pending {
+		if _, ok := d.subscriptions[delivery.Subscription]; ok {
+			continue
+		}
+		if err := d.attempt(ctx, delivery, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Attempt the delivery that is due at now (retries are scheduled from it),
+// the request is signed and the attempt is logged with the time it is actually made.
+//
+// Post the delivery to its endpoint.
+// Log the attempt.
+// Remove it if it is delivered, dead-letter it if it failed permanently or ran out of attempts,
+// reschedule it with exponential backoff otherwise.
+func (d *Dispatcher) attempt(ctx context.Context, delivery Delivery, now time.Time) error {
+	// Subscriptions removed from config are dead-lettered.
+	w, ok := d.subscriptions[delivery.Subscription]
+	delivery.Attempts++
+
+	// Post the delivery to its endpoint.
+	start := time.Now()
+	status, err := 0, fmt.Errorf("subscription %q is not configured", delivery.Subscription)
+	if ok {
+		status, err = d.post(ctx, w, delivery)
+	}
+	result := d.result(ok, status, delivery.Attempts)
+
+	// Log the attempt.
+	attempt := Attempt{
+		Delivery:     delivery.ID,
+		Subscription: delivery.Subscription,
+		Event:        delivery.Event,
+		Attempt:      delivery.Attempts,
+		Time:         start,
+		Duration:     time.Since(start),
+		StatusCode:   status,
+		Result:       result,
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		delivery.LastError = err.Error()
+	}
+	if err := d.queue.Log(ctx, attempt); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
+	metrics.WebhookDeliveries.WithLabelValues(delivery.Subscription, result).Inc()
+
+	// Remove it if it is delivered, dead-letter it if it failed permanently or ran out of attempts,
+	// reschedule it with exponential backoff otherwise.
+	switch result {
+	case ResultDelivered:
+		return d.queue.Done(ctx, delivery, false)
+	case ResultDead:
+		slog.WarnContext(ctx, "webhook is dead-lettered", "subscription", delivery.Subscription, "delivery", delivery.ID, "error", delivery.LastError)
+		return d.queue.Done(ctx, delivery, true)
+	default:
+		delivery.NextAttempt = now.Add(d.delay(delivery.Attempts))
+		return d.queue.Push(ctx, delivery)
+	}
+}
+
+// Sign the payload with the current time and post it, the status code is returned for any response.
+// Non-2xx responses are errors.
+func (d *Dispatcher) post(ctx context.Context, w config.Webhook, delivery Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDHeader, delivery.ID)
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(SignatureHeader, Sign(w.Secret, time.Now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// 2xx responses deliver the event.
+// 4xx responses (except timeouts and rate limiting) will not change on retry, so they are dead-lettered at once.
+// Other failures are retried until attempts run out.
+func (d *Dispatcher) result(configured bool, status, attempts int) string {
+	switch {
+	case status >= 200 && status <= 299:
+		return ResultDelivered
+	case !configured:
+		return ResultDead
+	case status >= 400 && status <= 499 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests:
+		return ResultDead
+	case attempts >= d.attempts:
+		return ResultDead
+	default:
+		return ResultRetry
+	}
+}
+
+// Delay after the attempt: backoff doubled with every failed attempt, up to an hour.
+func (d *Dispatcher) delay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// Attempts() returns up to limit latest delivery attempts (of the subscription if it is not empty), newest first.
+func (d *Dispatcher) Attempts(ctx context.Context, subscription string, limit int) ([]Attempt, error) {
+	return d.queue.Attempts(ctx, subscription, limit)
+}
+
+// DeadLetters() returns up to limit dead-lettered deliveries (of the subscription if it is not empty).
+func (d *Dispatcher) DeadLetters(ctx context.Context, subscription string, limit int) ([]Delivery, error) {
+	return d.queue.DeadLetters(ctx, subscription, limit)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
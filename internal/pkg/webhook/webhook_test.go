@@ -0,0 +1,396 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VanLavr/auth/internal/pkg/audit"
+	"github.com/VanLavr/auth/internal/pkg/config"
+	e "github.com/VanLavr/auth/internal/pkg/errors"
+	"github.com/VanLavr/auth/internal/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+const secret = "webhook-secret"
+
+// receiver is an endpoint that answers with queued status codes (200 when they run out)
+// and keeps the requests it got.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []received
+}
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, received{header: req.Header, body: body})
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() []received {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]received(nil), r.requests...)
+}
+
+func newConfig(webhooks ...config.Webhook) *config.Config {
+	return &config.Config{
+		Webhooks:        webhooks,
+		WebhookAttempts: 3,
+		WebhookBackoff:  time.Second,
+		WebhookTimeout:  time.Second,
+	}
+}
+
+// Testcases:
+// 1) events are delivered only to endpoints subscribed to them
+// 2) delivered payload is signed with the secret of the endpoint and described by headers
+// 3) signature of another secret, modified body or stale timestamp is rejected
+func TestDelivery(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	reuse, all := newReceiver(t), newReceiver(t)
+	queue := webhook.NewMemoryQueue()
+	dispatcher := webhook.New(newConfig(
+		config.Webhook{ID: "reuse", URL: reuse.URL, Secret: secret, Events: []string{audit.EventTokenReuse}},
+		config.Webhook{ID: "all", URL: all.URL, Secret: "other-secret"},
+	), queue)
+
+	// 1
+	dispatcher.Notify(ctx, audit.Entry{Event: audit.EventTokenIssued, GUID: "user"})
+	dispatcher.Notify(ctx, audit.Entry{Event: audit.EventTokenReuse, GUID: "user", Actor: "web", Details: map[string]string{"reason": "replay"}})
+	n, err := dispatcher.Deliver(ctx, time.Now())
+	assert.Nil(err)
+	assert.Equal(3, n)
+	assert.Len(all.received(), 2)
+	if !assert.Len(reuse.received(), 1) {
+		return
+	}
+
+	// 2
+	request := reuse.received()[0]
+	var payload webhook.Payload
+	assert.Nil(json.Unmarshal(request.body, &payload))
+	assert.Equal(audit.EventTokenReuse, payload.Event)
+	assert.Equal("user", payload.GUID)
+	assert.Equal("web", payload.Actor)
+	assert.Equal("replay", payload.Details["reason"])
+	assert.Equal(payload.ID, request.header.Get(webhook.IDHeader))
+	assert.Equal(audit.EventTokenReuse, request.header.Get(webhook.EventHeader))
+	assert.Equal("application/json", request.header.Get("Content-Type"))
+	signature := request.header.Get(webhook.SignatureHeader)
+	assert.Nil(webhook.Verify(secret, signature, request.body, time.Now(), time.Minute))
+
+	// 3
+	assert.ErrorIs(webhook.Verify("other-secret", signature, request.body, time.Now(), time.Minute), e.ErrInvalidSignature)
+	assert.ErrorIs(webhook.Verify(secret, signature, append(request.body, ' '), time.Now(), time.Minute), e.ErrInvalidSignature)
+	assert.ErrorIs(webhook.Verify(secret, signature, request.body, time.Now().Add(time.Hour), time.Minute), e.ErrInvalidSignature)
+	assert.ErrorIs(webhook.Verify(secret, "v1=abc", request.body, time.Now(), time.Minute), e.ErrInvalidSignature)
+
+	attempts, err := dispatcher.Attempts(ctx, "", 0)
+	assert.Nil(err)
+	assert.Len(attempts, 3)
+	for _, attempt := range attempts {
+		assert.Equal(webhook.ResultDelivered, attempt.Result)
+		assert.Equal(http.StatusOK, attempt.StatusCode)
+		assert.Equal(1, attempt.Attempt)
+	}
+}
+
+// Testcases:
+// 1) failed delivery is not retried before its backoff
+// 2) backoff doubles with every failed attempt and the delivery succeeds on retry
+// 3) delivery is dead-lettered when attempts run out
+// 4) delivery is dead-lettered at once on a permanent client error
+// 5) delivery log keeps every attempt newest first
+// 6) retries are signed and logged with the time they are made, not the time they were due
+func TestRetry(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	flaky := newReceiver(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	down := newReceiver(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	gone := newReceiver(t, http.StatusGone)
+	queue := webhook.NewMemoryQueue()
+	dispatcher := webhook.New(newConfig(
+		config.Webhook{ID: "flaky", URL: flaky.URL, Secret: secret},
+		config.Webhook{ID: "down", URL: down.URL, Secret: secret},
+		config.Webhook{ID: "gone", URL: gone.URL, Secret: secret},
+	), queue)
+	dispatcher.Notify(ctx, audit.Entry{Event: audit.EventTokenRevoked, GUID: "user"})
+	now := time.Now()
+
+	// 1
+	n, err := dispatcher.Deliver(ctx, now)
+	assert.Nil(err)
+	assert.Equal(3, n)
+	n, err = dispatcher.Deliver(ctx, now.Add(time.Second-time.Millisecond))
+	assert.Nil(err)
+	assert.Equal(0, n)
+
+	// 2
+	n, err = dispatcher.Deliver(ctx, now.Add(time.Second))
+	assert.Nil(err)
+	assert.Equal(2, n)
+	n, err = dispatcher.Deliver(ctx, now.Add(3*time.Second-time.Millisecond))
+	assert.Nil(err)
+	assert.Equal(0, n)
+	n, err = dispatcher.Deliver(ctx, now.Add(3*time.Second))
+	assert.Nil(err)
+	assert.Equal(2, n)
+	assert.Len(flaky.received(), 3)
+
+	// 3
+	assert.Len(down.received(), 3)
+	n, err = dispatcher.Deliver(ctx, now.Add(time.Hour))
+	assert.Nil(err)
+	assert.Equal(0, n)
+	dead, err := dispatcher.DeadLetters(ctx, "down", 0)
+	assert.Nil(err)
+	if assert.Len(dead, 1) {
+		assert.Equal(3, dead[0].Attempts)
+		assert.Equal("endpoint responded with 502", dead[0].LastError)
+	}
+
+	// 4
+	assert.Len(gone.received(), 1)
+	dead, err = dispatcher.DeadLetters(ctx, "gone", 0)
+	assert.Nil(err)
+	if assert.Len(dead, 1) {
+		assert.Equal(1, dead[0].Attempts)
+	}
+
+	// 5
+	attempts, err := dispatcher.Attempts(ctx, "flaky", 0)
+	assert.Nil(err)
+	var results []string
+	var statuses []int
+	for _, attempt := range attempts {
+		results = append(results, attempt.Result)
+		statuses = append(statuses, attempt.StatusCode)
+	}
+	assert.Equal([]string{webhook.ResultDelivered, webhook.ResultRetry, webhook.ResultRetry}, results)
+	assert.Equal([]int{http.StatusOK, http.StatusTooManyRequests, http.StatusInternalServerError}, statuses)
+	attempts, err = dispatcher.Attempts(ctx, "", 2)
+	assert.Nil(err)
+	assert.Len(attempts, 2)
+
+	// 6
+	retry := flaky.received()[2]
+	assert.Nil(webhook.Verify(secret, retry.header.Get(webhook.SignatureHeader), retry.body, time.Now(), time.Second))
+	attempts, err = dispatcher.Attempts(ctx, "flaky", 1)
+	assert.Nil(err)
+	if assert.Len(attempts, 1) {
+		assert.WithinDuration(time.Now(), attempts[0].Time, time.Second)
+	}
+}
+
+// Testcases:
+// 1) pending deliveries, dead letters and the delivery log survive reopening
+// 2) pending delivery is delivered after reopening
+func TestBoltQueue(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "webhooks.db")
+	endpoint := newReceiver(t, http.StatusServiceUnavailable, http.StatusNotFound)
+	cfg := newConfig(config.Webhook{ID: "endpoint", URL: endpoint.URL, Secret: secret})
+	cfg.WebhookQueue = path
+
+	queue, err := webhook.OpenQueue(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher := webhook.New(cfg, queue)
+	dispatcher.Notify(ctx, audit.Entry{Event: audit.EventTokenIssued})
+	dispatcher.Notify(ctx, audit.Entry{Event: audit.EventTokenRefreshed})
+	_, err = dispatcher.Deliver(ctx, time.Now())
+	assert.Nil(err)
+	assert.Nil(queue.Close(ctx))
+
+	// 1
+	queue, err = webhook.OpenQueue(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close(ctx)
+	dispatcher = webhook.New(cfg, queue)
+	dead, err := dispatcher.DeadLetters(ctx, "", 0)
+	assert.Nil(err)
+	assert.Len(dead, 1)
+	attempts, err := dispatcher.Attempts(ctx, "endpoint", 0)
+	assert.Nil(err)
+	assert.Len(attempts, 2)
+
+	// 2
+	n, err := dispatcher.Deliver(ctx, time.Now().Add(time.Minute))
+	assert.Nil(err)
+	assert.Equal(1, n)
+	assert.Len(endpoint.received(), 3)
+	attempts, err = dispatcher.Attempts(ctx, "endpoint", 1)
+	assert.Nil(err)
+	if assert.Len(attempts, 1) {
+		assert.Equal(webhook.ResultDelivered, attempts[0].Result)
+		assert.Equal(2, attempts[0].Attempt)
+	}
+	due, err := queue.Due(ctx, "", time.Now().Add(time.Hour), 0)
+	assert.Nil(err)
+	assert.Empty(due)
+}
+
+// Testcases:
+// 1) events are delivered to an endpoint while another one is still responding
+// 2) the slow endpoint gets its event once it responds
+func TestSlowEndpoint(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	fast := newReceiver(t)
+	dispatcher := webhook.New(newConfig(
+		config.Webhook{ID: "slow", URL: slow.URL, Secret: secret},
+		config.Webhook{ID: "fast", URL: fast.URL, Secret: secret},
+	), webhook.NewMemoryQueue())
+
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// 1
+	dispatcher.Notify(ctx, audit.Entry{Event: audit.EventTokenIssued, GUID: "user"})
+	dispatcher.Notify(ctx, audit.Entry{Event: audit.EventTokenRevoked, GUID: "user"})
+	assert.Eventually(func() bool { return len(fast.received()) == 2 }, 500*time.Millisecond, 10*time.Millisecond)
+
+	// 2
+	close(release)
+	assert.Eventually(func() bool {
+		attempts, err := dispatcher.Attempts(ctx, "slow", 0)
+		return err == nil && len(attempts) == 2 && attempts[0].Result == webhook.ResultDelivered
+	}, time.Second, 10*time.Millisecond)
+}
+
+// Testcases:
+// 1) due deliveries of a subscription are returned earliest first up to the limit
+// 2) rescheduled delivery is moved, not duplicated
+// 3) delivered one is removed and dead one becomes a dead letter
+// 4) deliveries of files keyed by id are kept after reopening
+func TestBoltQueueSchedule(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "webhooks.db")
+	queue, err := webhook.OpenBoltQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	delivery := func(id, subscription string, next time.Duration) webhook.Delivery {
+		return webhook.Delivery{ID: id, Subscription: subscription, NextAttempt: now.Add(next)}
+	}
+	ids := func(deliveries []webhook.Delivery) []string {
+		var ids []string
+		for _, d := range deliveries {
+			ids = append(ids, d.ID)
+		}
+		return ids
+	}
+
+	// 1
+	assert.Nil(queue.Push(ctx,
+		delivery("c", "a", 2*time.Second),
+		delivery("a", "a", 0),
+		delivery("b", "a", time.Second),
+		delivery("later", "a", time.Hour),
+		delivery("other", "b", 0),
+	))
+	due, err := queue.Due(ctx, "a", now.Add(time.Minute), 2)
+	assert.Nil(err)
+	assert.Equal([]string{"a", "b"}, ids(due))
+	due, err = queue.Due(ctx, "a", now.Add(time.Minute), 0)
+	assert.Nil(err)
+	assert.Equal([]string{"a", "b", "c"}, ids(due))
+	due, err = queue.Due(ctx, "", now, 0)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"a", "other"}, ids(due))
+
+	// 2
+	assert.Nil(queue.Push(ctx, delivery("a", "a", 3*time.Second)))
+	due, err = queue.Due(ctx, "a", now.Add(time.Minute), 0)
+	assert.Nil(err)
+	assert.Equal([]string{"b", "c", "a"}, ids(due))
+
+	// 3
+	assert.Nil(queue.Done(ctx, due[0], false))
+	assert.Nil(queue.Done(ctx, due[1], true))
+	due, err = queue.Due(ctx, "a", now.Add(time.Minute), 0)
+	assert.Nil(err)
+	assert.Equal([]string{"a"}, ids(due))
+	dead, err := queue.DeadLetters(ctx, "a", 0)
+	assert.Nil(err)
+	assert.Equal([]string{"c"}, ids(dead))
+	assert.Nil(queue.Close(ctx))
+
+	// 4
+	legacyPath := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := bolt.Open(legacyPath, 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(db.Update(func(tx *bolt.Tx) error {
+		for bucket, d := range map[string]webhook.Delivery{"pending": delivery("p", "a", 0), "dead_letters": delivery("d", "a", 0)} {
+			b, err := tx.CreateBucket([]byte(bucket))
+			if err != nil {
+				return err
+			}
+			value, _ := json.Marshal(d)
+			if err := b.Put([]byte(d.ID), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	assert.Nil(db.Close())
+	queue, err = webhook.OpenBoltQueue(legacyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close(ctx)
+	due, err = queue.Due(ctx, "a", now, 0)
+	assert.Nil(err)
+	assert.Equal([]string{"p"}, ids(due))
+	dead, err = queue.DeadLetters(ctx, "", 0)
+	assert.Nil(err)
+	assert.Equal([]string{"d"}, ids(dead))
+}